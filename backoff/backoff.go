@@ -0,0 +1,129 @@
+// Package backoff 实现lock-free循环中cas失败后的退避策略。
+//
+// 高并发时cas失败后立即重试，多个goroutine反复争抢同一个cache line，
+// 失败的越多，争抢越激烈。退避让失败的goroutine等待一会再重试，
+// 降低争抢，提高整体吞吐量，但是会增加单次操作的延迟。
+//
+// 使用方式:
+//
+//	for n := 0; ; n++ {
+//		old := atomic.LoadPointer(&q.head)
+//		if cas(&q.head, old, new) {
+//			break
+//		}
+//		backoff.Wait(b, n)
+//	}
+package backoff
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSpin Spin默认的自旋次数
+	DefaultSpin = 1 << 4
+
+	// DefaultBase,DefaultLimit Exponential默认的初始和最大自旋次数
+	DefaultBase  = 1 << 2
+	DefaultLimit = 1 << 10
+
+	// DefaultSleep Sleep默认的休眠时间
+	DefaultSleep = time.Microsecond
+)
+
+// Backoff 退避策略。
+//
+// Wait在一次操作第n次(从0开始)重试前调用，n由调用者记录，
+// 所以Backoff本身没有状态，同一个Backoff可以被多个goroutine和多个结构共享。
+type Backoff interface {
+	Wait(n int)
+}
+
+// Wait 调用b.Wait(n)。b为nil时直接返回，即立即重试。
+func Wait(b Backoff, n int) {
+	if b != nil {
+		b.Wait(n)
+	}
+}
+
+// Spin 每次重试前自旋N次，N<1时使用DefaultSpin。
+// 不让出处理器，适合核数多、冲突时间很短的场景。
+type Spin struct {
+	N int
+}
+
+func (s Spin) Wait(n int) {
+	if s.N < 1 {
+		spin(DefaultSpin)
+		return
+	}
+	spin(s.N)
+}
+
+// Exponential 指数退避：第n次重试前自旋Base<<n次，不超过Limit，
+// 实际次数在[spins/2,spins]之间随机(jitter)，避免多个goroutine同时重试。
+// Base,Limit<1时使用DefaultBase,DefaultLimit。
+type Exponential struct {
+	Base  int
+	Limit int
+}
+
+func (e Exponential) Wait(n int) {
+	base, limit := e.Base, e.Limit
+	if base < 1 {
+		base = DefaultBase
+	}
+	if limit < 1 {
+		limit = DefaultLimit
+	}
+	spins := limit
+	// 避免移位溢出
+	if n < 32 && base<<uint(n) < limit {
+		spins = base << uint(n)
+	}
+	half := spins >> 1
+	spin(spins - half + int(jitter(n)%uint64(half+1)))
+}
+
+// Gosched 每次重试前调用runtime.Gosched让出处理器，
+// 适合goroutine数量多于GOMAXPROCS的场景。
+type Gosched struct{}
+
+func (Gosched) Wait(int) {
+	runtime.Gosched()
+}
+
+// Sleep 每次重试前休眠D，D<1时使用DefaultSleep。
+// 休眠的精度取决于系统，通常远大于D，只适合冲突很严重、不在意延迟的场景。
+type Sleep struct {
+	D time.Duration
+}
+
+func (s Sleep) Wait(int) {
+	if s.D < 1 {
+		time.Sleep(DefaultSleep)
+		return
+	}
+	time.Sleep(s.D)
+}
+
+// sink 自旋时读取，避免空循环被优化。
+var sink uint32
+
+func spin(n int) {
+	for i := 0; i < n; i++ {
+		atomic.LoadUint32(&sink)
+	}
+}
+
+// jitter 返回一个伪随机数。
+// math/rand的全局源有锁，退避时再争抢锁得不偿失，
+// 所以用当前时间和n经过splitmix64混合，不需要共享状态。
+func jitter(n int) uint64 {
+	x := uint64(time.Now().UnixNano()) + uint64(n)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,182 @@
+// Package codec 值和字节之间的转换，用于持久化队列和队列、栈的快照。
+//
+// 快照的格式：
+//
+//	version byte	// snapshotVersion
+//	count   uvarint	// 值的数量
+//	count个: len uvarint, data [len]byte	// 每个值由Codec编码
+//
+// json格式的快照是一个数组，每个元素是Codec编码的结果，见EncodeJSON。
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// Codec 值和字节之间的转换。
+// Decode返回后不能再持有data,调用者可能复用它。
+type Codec[T any] interface {
+	Encode(val T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+var (
+	_ Codec[interface{}] = Gob[interface{}]{}
+	_ Codec[interface{}] = JSON[interface{}]{}
+	_ Codec[[]byte]      = Bytes{}
+	_ Codec[string]      = String{}
+)
+
+// Gob 使用encoding/gob编码，每个值都带有类型信息。
+// T是interface{}时，实际类型需要先gob.Register。
+type Gob[T any] struct{}
+
+func (Gob[T]) Encode(val T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Gob[T]) Decode(data []byte) (val T, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&val)
+	return
+}
+
+// JSON 使用encoding/json编码。
+// T是interface{}时，解码得到的是json的默认类型，例如数字为float64。
+type JSON[T any] struct{}
+
+func (JSON[T]) Encode(val T) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSON[T]) Decode(data []byte) (val T, err error) {
+	err = json.Unmarshal(data, &val)
+	return
+}
+
+// Bytes 直接储存[]byte，解码时复制一份。
+type Bytes struct{}
+
+func (Bytes) Encode(val []byte) ([]byte, error) {
+	return val, nil
+}
+
+func (Bytes) Decode(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// String 直接储存string。
+type String struct{}
+
+func (String) Encode(val string) ([]byte, error) {
+	return []byte(val), nil
+}
+
+func (String) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+const snapshotVersion = 1
+
+// ErrFormat 快照数据格式错误或者不完整。
+var ErrFormat = errors.New("codec: invalid snapshot")
+
+// EncodeSlice 按顺序将vals编码成一个快照。
+func EncodeSlice[T any](vals []T, c Codec[T]) ([]byte, error) {
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64)
+	buf[0] = snapshotVersion
+	buf = appendUvarint(buf, uint64(len(vals)))
+	for _, v := range vals {
+		data, err := c.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+// DecodeSlice 解码EncodeSlice生成的快照，返回的值保持原来的顺序。
+func DecodeSlice[T any](data []byte, c Codec[T]) ([]T, error) {
+	if len(data) == 0 || data[0] != snapshotVersion {
+		return nil, ErrFormat
+	}
+	data = data[1:]
+	count, n := binary.Uvarint(data)
+	// 每个值至少有1字节的长度
+	if n <= 0 || count > uint64(len(data)-n) {
+		return nil, ErrFormat
+	}
+	data = data[n:]
+	vals := make([]T, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, ErrFormat
+		}
+		v, err := c.Decode(data[n : n+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		data = data[n+int(size):]
+	}
+	if len(data) != 0 {
+		return nil, ErrFormat
+	}
+	return vals, nil
+}
+
+// ErrNotJSON json快照中Codec编码的结果不是合法的json。
+var ErrNotJSON = errors.New("codec: encoded value is not json")
+
+// EncodeJSON 按顺序将vals编码成json数组，每个元素直接使用c编码的结果，
+// 所以c的输出必须是合法的json，例如JSON；否则返回ErrNotJSON，Gob,String等使用EncodeSlice。
+func EncodeJSON[T any](vals []T, c Codec[T]) ([]byte, error) {
+	buf := []byte{'['}
+	for i, v := range vals {
+		data, err := c.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(data) {
+			return nil, ErrNotJSON
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, data...)
+	}
+	return append(buf, ']'), nil
+}
+
+// DecodeJSON 解码EncodeJSON生成的json数组，每个元素由c解码，返回的值保持原来的顺序。
+func DecodeJSON[T any](data []byte, c Codec[T]) ([]T, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, err
+	}
+	vals := make([]T, 0, len(raws))
+	for _, raw := range raws {
+		v, err := c.Decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
@@ -0,0 +1,227 @@
+// package deque
+// work-stealing deque
+
+package deque
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Deque 工作窃取双端队列。
+//
+// 只有一个owner goroutine可以PushBottom,PopBottom，后进先出；
+// 其他goroutine通过Steal从另一端窃取，先进先出。
+type Deque[T any] interface {
+	PushBottom(val T) bool
+	PopBottom() (val T, ok bool)
+	Steal() (val T, ok bool)
+	Size() int
+	Empty() bool
+}
+
+const (
+	DefauleSize   = 1 << 5
+	cacheLineSize = 64
+)
+
+// cacheLinePad 隔开owner和thief频繁写入的字段，避免伪共享。
+type cacheLinePad [cacheLineSize]byte
+
+// CLDeque is a Chase-Lev dynamic circular work-stealing deque.
+//
+// top由Steal和PopBottom取最后一个值时cas移动，bottom只由owner写入。
+// top,bottom只增加，不回绕，通过ID&mod取slot，和LRQueue的enID,deID一样。
+// 空条件为top>=bottom，buffer满了(bottom-top>mod)时，
+// owner复制[top,bottom)到两倍大小的buffer再替换。
+// 旧buffer不会再写入，持有旧buffer的Steal依旧能读到正确的值，由GC回收。
+type CLDeque[T any] struct {
+	// 放在第一个，保证32位平台原子操作8字节对齐。
+	top int64
+	_   cacheLinePad
+	// bottom 只由owner写入
+	bottom int64
+	_      cacheLinePad
+
+	buf unsafe.Pointer // *ring[T]
+
+	once sync.Once
+	cap  int64 // 初始容量，自动向上调整至2^n
+}
+
+// ring 环形数组，slot储存*T，Steal在cas前读取slot,
+// owner可能同时写入同一个slot，原子读写指针保证读到完整的值。
+type ring[T any] struct {
+	mod  int64
+	data []unsafe.Pointer
+}
+
+func newRing[T any](cap int64) *ring[T] {
+	mod := minMod(cap)
+	return &ring[T]{
+		mod:  mod,
+		data: make([]unsafe.Pointer, mod+1),
+	}
+}
+
+func (r *ring[T]) load(id int64) unsafe.Pointer {
+	return atomic.LoadPointer(&r.data[id&r.mod])
+}
+
+func (r *ring[T]) store(id int64, p unsafe.Pointer) {
+	atomic.StorePointer(&r.data[id&r.mod], p)
+}
+
+// grow 复制[top,bottom)到两倍大小的ring
+func (r *ring[T]) grow(top, bottom int64) *ring[T] {
+	n := newRing[T]((r.mod + 1) << 1)
+	for i := top; i < bottom; i++ {
+		n.store(i, r.load(i))
+	}
+	return n
+}
+
+// 溢出环形计算需要，得出2^n-1。
+func minMod(u int64) int64 {
+	if u < 1 {
+		u = DefauleSize
+	}
+	u -= 1
+	u |= u >> 1
+	u |= u >> 2
+	u |= u >> 4
+	u |= u >> 8
+	u |= u >> 16
+	u |= u >> 32
+	return u
+}
+
+// NewCLDeque 返回一个空的Chase-Lev deque
+func NewCLDeque[T any]() Deque[T] {
+	var d CLDeque[T]
+	d.onceInit()
+	return &d
+}
+
+func (d *CLDeque[T]) onceInit() {
+	d.once.Do(func() {
+		d.init()
+	})
+}
+
+func (d *CLDeque[T]) init() {
+	atomic.StoreInt64(&d.top, 0)
+	atomic.StoreInt64(&d.bottom, 0)
+	atomic.StorePointer(&d.buf, unsafe.Pointer(newRing[T](d.cap)))
+}
+
+// Init 清空deque,只能由owner在没有并发Steal时调用。
+func (d *CLDeque[T]) Init() {
+	d.InitWith()
+}
+
+// InitWith 初始化容量为cap的deque,满了自动扩容。
+// 如果未提供，则使用默认值: DefauleSize。只能由owner在没有并发Steal时调用。
+func (d *CLDeque[T]) InitWith(caps ...int) {
+	d.onceInit()
+	if len(caps) > 0 && caps[0] > 0 {
+		d.cap = int64(caps[0])
+	}
+	d.init()
+}
+
+func (d *CLDeque[T]) loadBuf() *ring[T] {
+	return (*ring[T])(atomic.LoadPointer(&d.buf))
+}
+
+// PushBottom 将val加入bottom端，只能由owner调用，总是成功。
+func (d *CLDeque[T]) PushBottom(val T) bool {
+	d.onceInit()
+	b := atomic.LoadInt64(&d.bottom)
+	t := atomic.LoadInt64(&d.top)
+	r := d.loadBuf()
+	if b-t > r.mod {
+		// buffer满了，扩容
+		r = r.grow(t, b)
+		atomic.StorePointer(&d.buf, unsafe.Pointer(r))
+	}
+	p := new(T)
+	*p = val
+	r.store(b, unsafe.Pointer(p))
+	// 先写入slot,再移动bottom,Steal看到bottom时slot已经写入。
+	atomic.StoreInt64(&d.bottom, b+1)
+	return true
+}
+
+// PopBottom 从bottom端取出最后加入的值，只能由owner调用。
+func (d *CLDeque[T]) PopBottom() (val T, ok bool) {
+	d.onceInit()
+	// 先减少bottom,再读取top。
+	// Steal先读取top再读取bottom,两边至少有一个能看到对方。
+	b := atomic.LoadInt64(&d.bottom) - 1
+	r := d.loadBuf()
+	atomic.StoreInt64(&d.bottom, b)
+	t := atomic.LoadInt64(&d.top)
+	if t > b {
+		// deque空，恢复bottom
+		atomic.StoreInt64(&d.bottom, b+1)
+		return
+	}
+	p := r.load(b)
+	if t == b {
+		// 只剩最后一个值，和Steal竞争top。
+		ok = atomic.CompareAndSwapInt64(&d.top, t, t+1)
+		atomic.StoreInt64(&d.bottom, b+1)
+		if !ok {
+			return
+		}
+	} else {
+		// 没有竞争，清空slot,不再引用值。
+		r.store(b, nil)
+	}
+	return *(*T)(p), true
+}
+
+// Steal 从top端取出最早加入的值，可以由任意goroutine调用。
+// 和其他Steal或者PopBottom竞争失败时重试，直到成功或者deque空。
+func (d *CLDeque[T]) Steal() (val T, ok bool) {
+	d.onceInit()
+	for {
+		t := atomic.LoadInt64(&d.top)
+		b := atomic.LoadInt64(&d.bottom)
+		if t >= b {
+			// deque空
+			return
+		}
+		// 在cas前读取slot,cas成功后slot可能被owner覆盖。
+		p := d.loadBuf().load(t)
+		if p == nil {
+			// owner已经取出，重新读取
+			continue
+		}
+		if atomic.CompareAndSwapInt64(&d.top, t, t+1) {
+			return *(*T)(p), true
+		}
+	}
+}
+
+// Size 数量，并发时只是近似值。
+func (d *CLDeque[T]) Size() int {
+	t := atomic.LoadInt64(&d.top)
+	b := atomic.LoadInt64(&d.bottom)
+	if b <= t {
+		return 0
+	}
+	return int(b - t)
+}
+
+func (d *CLDeque[T]) Empty() bool {
+	return d.Size() == 0
+}
+
+// Cap 当前buffer的容量
+func (d *CLDeque[T]) Cap() int {
+	d.onceInit()
+	return int(d.loadBuf().mod + 1)
+}
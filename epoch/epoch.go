@@ -0,0 +1,214 @@
+// Package epoch 实现基于epoch的内存回收(epoch-based reclamation, EBR)。
+//
+// 相比危险指针，EBR不需要保护每个访问的node，每次操作只需要Pin一次，开销更低。
+//
+// 全局有一个epoch，每个participant有自己的局部epoch。
+// Pin时participant记录当前全局epoch，并标记为pinned,Unpin时清除标记。
+// 只有所有pinned的participant都已经观察到当前全局epoch时，全局epoch才能前进。
+// 在全局epoch为e时退休的node，等全局epoch到达e+2时，
+// 所有可能持有它的goroutine都已经Unpin，可以安全回收。
+//
+// 使用方式:
+//
+//	g := d.Pin()
+//	defer g.Unpin()
+//	head := atomic.LoadPointer(&q.head)
+//	...
+//	g.Retire(head)
+package epoch
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// collectLimit 退休列表达到该长度时尝试回收
+	collectLimit = 64
+
+	// limboLimit 退休列表回收后依旧超过该长度时，最早退休的node不再等待，
+	// 直接交给GC，见Flush。
+	limboLimit = 1 << 10
+
+	pinnedBit = 1
+)
+
+// retired 一个等待回收的node或者回调
+type retired struct {
+	epoch uint64
+	p     unsafe.Pointer
+	fn    func()
+}
+
+// participant 一个goroutine Pin期间独占的局部epoch。
+type participant struct {
+	// local = epoch<<1 | pinned
+	local uint64
+
+	// active为1表示participant正在被使用
+	active uint32
+
+	// next 加入domain后不再改变
+	next *participant
+
+	// limbo只由持有participant的goroutine访问
+	limbo []retired
+
+	// limit 下次尝试回收时limbo的长度。
+	// 有goroutine长时间Pin时，每退休collectLimit个才尝试一次。
+	limit int
+}
+
+// Domain 管理全局epoch和所有participant，零值可用。
+//
+// 通常每个lock-free结构拥有一个Domain。
+type Domain struct {
+	epoch uint64 // 全局epoch
+	head  unsafe.Pointer
+	count uint32
+
+	// reclaim 回收Retire的node，为nil时直接交给GC。
+	reclaim func(unsafe.Pointer)
+}
+
+// NewDomain 返回一个回收函数为reclaim的Domain。
+func NewDomain(reclaim func(unsafe.Pointer)) *Domain {
+	d := &Domain{}
+	d.SetReclaim(reclaim)
+	return d
+}
+
+// SetReclaim 设置回收函数，必须在Domain使用前调用。
+func (d *Domain) SetReclaim(reclaim func(unsafe.Pointer)) {
+	d.reclaim = reclaim
+}
+
+// Epoch 返回当前全局epoch。
+func (d *Domain) Epoch() uint64 {
+	return atomic.LoadUint64(&d.epoch)
+}
+
+// acquire 获取一个空闲participant，没有则新建并加入domain。
+func (d *Domain) acquire() *participant {
+	for p := (*participant)(atomic.LoadPointer(&d.head)); p != nil; p = p.next {
+		if atomic.LoadUint32(&p.active) == 0 && atomic.CompareAndSwapUint32(&p.active, 0, 1) {
+			return p
+		}
+	}
+	p := &participant{active: 1}
+	for {
+		head := atomic.LoadPointer(&d.head)
+		p.next = (*participant)(head)
+		if atomic.CompareAndSwapPointer(&d.head, head, unsafe.Pointer(p)) {
+			atomic.AddUint32(&d.count, 1)
+			return p
+		}
+	}
+}
+
+// Pin 进入临界区，返回的Guard在Unpin前，
+// 期间读取到的node都不会被回收。
+func (d *Domain) Pin() Guard {
+	p := d.acquire()
+	if len(p.limbo) > 0 {
+		// 还没有Pin，不会阻碍epoch前进，尽早让退休的node满足回收条件。
+		d.tryAdvance()
+	}
+	e := atomic.LoadUint64(&d.epoch)
+	atomic.StoreUint64(&p.local, e<<1|pinnedBit)
+	return Guard{d: d, p: p}
+}
+
+// tryAdvance 所有pinned的participant都观察到当前epoch时，前进全局epoch。
+func (d *Domain) tryAdvance() uint64 {
+	e := atomic.LoadUint64(&d.epoch)
+	for p := (*participant)(atomic.LoadPointer(&d.head)); p != nil; p = p.next {
+		local := atomic.LoadUint64(&p.local)
+		if local&pinnedBit == pinnedBit && local>>1 != e {
+			return e
+		}
+	}
+	if atomic.CompareAndSwapUint64(&d.epoch, e, e+1) {
+		return e + 1
+	}
+	return atomic.LoadUint64(&d.epoch)
+}
+
+// Guard Pin返回的临界区凭证，只能在Pin的goroutine里使用。
+type Guard struct {
+	d *Domain
+	p *participant
+}
+
+// Unpin 离开临界区，之后不能再访问Pin期间读取的node。
+// 未回收的退休列表保留在participant里，由下一个使用者继续回收。
+func (g Guard) Unpin() {
+	atomic.StoreUint64(&g.p.local, 0)
+	atomic.StoreUint32(&g.p.active, 0)
+}
+
+// Retire 退休node，等所有goroutine都不可能持有它时，调用Domain的回收函数。
+// p必须已经从结构中移除，并且只能退休一次。
+func (g Guard) Retire(p unsafe.Pointer) {
+	g.retire(retired{p: p})
+}
+
+// Defer 延迟执行fn，直到当前所有Pin的goroutine都已经Unpin。
+func (g Guard) Defer(fn func()) {
+	g.retire(retired{fn: fn})
+}
+
+func (g Guard) retire(r retired) {
+	r.epoch = atomic.LoadUint64(&g.d.epoch)
+	g.p.limbo = append(g.p.limbo, r)
+	if len(g.p.limbo) >= collectLimit && len(g.p.limbo) >= g.p.limit {
+		g.Flush()
+	}
+}
+
+// Flush 尝试前进全局epoch，并回收已经安全的退休node。
+//
+// 被挂起的goroutine一直Pin时epoch不能前进，退休列表会无限增长。
+// 超过limboLimit的部分，最早退休的node直接丢弃，不调用回收函数：
+// GC保证还被持有的node不会被释放，丢弃只是不再复用它。Defer的回调依旧等待执行。
+func (g Guard) Flush() {
+	e := g.d.tryAdvance()
+	limbo := g.p.limbo
+	// limbo按退休顺序排列，epoch单调不减，只需要回收满足条件的前缀。
+	n := 0
+	for ; n < len(limbo) && limbo[n].epoch+2 <= e; n++ {
+		r := limbo[n]
+		// 让GC回收已经移除的指针
+		limbo[n] = retired{}
+		if r.fn != nil {
+			r.fn()
+		} else if g.d.reclaim != nil {
+			g.d.reclaim(r.p)
+		}
+	}
+	if n == len(limbo) {
+		limbo = limbo[:0]
+	} else {
+		limbo = limbo[n:]
+	}
+	if over := len(limbo) - limboLimit; over > 0 {
+		kept := limbo[:0]
+		for i, r := range limbo {
+			if i < over && r.fn == nil {
+				continue
+			}
+			kept = append(kept, r)
+		}
+		for i := len(kept); i < len(limbo); i++ {
+			limbo[i] = retired{}
+		}
+		limbo = kept
+	}
+	g.p.limbo = limbo
+	g.p.limit = len(limbo) + collectLimit
+}
+
+// Retired 返回当前participant退休列表中还未回收的数量。
+func (g Guard) Retired() int {
+	return len(g.p.limbo)
+}
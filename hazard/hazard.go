@@ -0,0 +1,183 @@
+// Package hazard 实现危险指针(hazard pointer)，用于安全回收lock-free结构中的node。
+//
+// 每次操作前从Domain获取一个Record，把将要访问的node写入hazard槽，
+// 再验证node依旧可达，之后才能访问node。
+// 移出结构的node不能直接释放，先退休(Retire)到Record的退休列表，
+// 当所有Record的hazard槽都不指向它时，才调用回收函数真正回收。
+// 这样node被回收复用时，不可能有其他goroutine持有它，也就不会出现ABA问题。
+//
+// 使用方式:
+//
+//	rec := d.Acquire()
+//	defer d.Release(rec)
+//	head := rec.Protect(0, &q.head)
+//	...
+//	d.Retire(rec, head)
+package hazard
+
+import (
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// Slots 每个Record的hazard槽数量
+	Slots = 4
+
+	// retireLimit 退休列表最小扫描长度
+	retireLimit = 64
+)
+
+// Record 一个goroutine操作期间独占的hazard槽。
+//
+// Go没有goroutine局部储存，所以每次操作通过Acquire获取，
+// 操作完成后Release归还，同一时间只会被一个goroutine使用。
+type Record struct {
+	hazard [Slots]unsafe.Pointer
+
+	// active为1表示record正在被使用
+	active uint32
+
+	// next 加入domain后不再改变
+	next *Record
+
+	// retired只由持有record的goroutine访问
+	retired []unsafe.Pointer
+	scratch []unsafe.Pointer
+}
+
+// Protect 读取addr指向的node并写入hazard槽i,
+// 直到写入后addr依旧指向该node，才表明保护成功。
+func (r *Record) Protect(i int, addr *unsafe.Pointer) unsafe.Pointer {
+	for {
+		p := atomic.LoadPointer(addr)
+		atomic.StorePointer(&r.hazard[i], p)
+		if p == atomic.LoadPointer(addr) {
+			return p
+		}
+	}
+}
+
+// Set 直接将p写入hazard槽i。
+// 调用者需要自己验证p在写入后依旧可达。
+func (r *Record) Set(i int, p unsafe.Pointer) {
+	atomic.StorePointer(&r.hazard[i], p)
+}
+
+// Clear 清空hazard槽i。
+func (r *Record) Clear(i int) {
+	atomic.StorePointer(&r.hazard[i], nil)
+}
+
+// Domain 管理一组Record，零值可用。
+//
+// 通常每个lock-free结构拥有一个Domain。
+type Domain struct {
+	head  unsafe.Pointer // *Record
+	count uint32
+
+	// reclaim 回收没有被保护的node，为nil时直接交给GC。
+	reclaim func(unsafe.Pointer)
+}
+
+// NewDomain 返回一个回收函数为reclaim的Domain。
+func NewDomain(reclaim func(unsafe.Pointer)) *Domain {
+	d := &Domain{}
+	d.SetReclaim(reclaim)
+	return d
+}
+
+// SetReclaim 设置回收函数，必须在Domain使用前调用。
+func (d *Domain) SetReclaim(reclaim func(unsafe.Pointer)) {
+	d.reclaim = reclaim
+}
+
+// Acquire 获取一个空闲Record，没有则新建并加入domain。
+func (d *Domain) Acquire() *Record {
+	for r := (*Record)(atomic.LoadPointer(&d.head)); r != nil; r = r.next {
+		if atomic.LoadUint32(&r.active) == 0 && atomic.CompareAndSwapUint32(&r.active, 0, 1) {
+			return r
+		}
+	}
+	r := &Record{active: 1}
+	for {
+		head := atomic.LoadPointer(&d.head)
+		r.next = (*Record)(head)
+		if atomic.CompareAndSwapPointer(&d.head, head, unsafe.Pointer(r)) {
+			atomic.AddUint32(&d.count, 1)
+			return r
+		}
+	}
+}
+
+// Release 清空hazard槽，归还Record。
+// 退休列表保留在Record里，由下一个使用者继续回收。
+// 只清空使用过的槽，写入指针有GC写屏障的开销。
+func (d *Domain) Release(r *Record) {
+	for i := range r.hazard {
+		if atomic.LoadPointer(&r.hazard[i]) != nil {
+			atomic.StorePointer(&r.hazard[i], nil)
+		}
+	}
+	atomic.StoreUint32(&r.active, 0)
+}
+
+// Retire 退休node，退休列表足够长时扫描回收。
+// p必须已经从结构中移除，并且只能退休一次。
+func (d *Domain) Retire(r *Record, p unsafe.Pointer) {
+	r.retired = append(r.retired, p)
+	limit := int(2 * Slots * atomic.LoadUint32(&d.count))
+	if limit < retireLimit {
+		limit = retireLimit
+	}
+	if len(r.retired) >= limit {
+		d.Scan(r)
+	}
+}
+
+// Scan 回收r退休列表中没有被任何hazard槽保护的node。
+// hazard排序后二分查找，goroutine多时退休列表和hazard都很长，逐个比较太慢。
+func (d *Domain) Scan(r *Record) {
+	hazards := r.scratch[:0]
+	for rec := (*Record)(atomic.LoadPointer(&d.head)); rec != nil; rec = rec.next {
+		for i := range rec.hazard {
+			if p := atomic.LoadPointer(&rec.hazard[i]); p != nil {
+				hazards = append(hazards, p)
+			}
+		}
+	}
+	sort.Slice(hazards, func(i, j int) bool {
+		return uintptr(hazards[i]) < uintptr(hazards[j])
+	})
+	remain := r.retired[:0]
+	for _, p := range r.retired {
+		if containsPointer(hazards, p) {
+			remain = append(remain, p)
+		} else if d.reclaim != nil {
+			d.reclaim(p)
+		}
+	}
+	// 让GC回收已经移除的指针
+	for i := len(remain); i < len(r.retired); i++ {
+		r.retired[i] = nil
+	}
+	for i := range hazards {
+		hazards[i] = nil
+	}
+	r.retired = remain
+	r.scratch = hazards[:0]
+}
+
+// Retired 返回r退休列表中还未回收的node数量。
+func (r *Record) Retired() int {
+	return len(r.retired)
+}
+
+// containsPointer 在排好序的ps中查找p。
+func containsPointer(ps []unsafe.Pointer, p unsafe.Pointer) bool {
+	i := sort.Search(len(ps), func(i int) bool {
+		return uintptr(ps[i]) >= uintptr(p)
+	})
+	return i < len(ps) && ps[i] == p
+}
@@ -0,0 +1,56 @@
+package reclaim
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Node 可以放入FreeList的node，NextAddr返回它的next字段的地址。
+type Node[N any] interface {
+	*N
+	NextAddr() *unsafe.Pointer
+}
+
+// FreeList 空闲node链表
+//
+// 只能在epoch Pin期间Pop:node回到空闲链表前要经过一个宽限期，
+// Pop读取的head在Pop完成前不可能被复用后再放回，所以不会出现ABA问题。
+type FreeList[N any, P Node[N]] struct {
+	head unsafe.Pointer
+	len  int32
+}
+
+// freeLimit 空闲链表最多缓存的node数量，超出的交给GC。
+const freeLimit = 1 << 12
+
+// Push 缓存n，空闲链表已满时返回false。
+func (l *FreeList[N, P]) Push(n P) bool {
+	if atomic.LoadInt32(&l.len) >= freeLimit {
+		return false
+	}
+	atomic.AddInt32(&l.len, 1)
+	next := n.NextAddr()
+	for {
+		head := atomic.LoadPointer(&l.head)
+		atomic.StorePointer(next, head)
+		if atomic.CompareAndSwapPointer(&l.head, head, unsafe.Pointer(n)) {
+			return true
+		}
+	}
+}
+
+func (l *FreeList[N, P]) Pop() P {
+	for {
+		head := atomic.LoadPointer(&l.head)
+		if head == nil {
+			return nil
+		}
+		n := P(head)
+		next := n.NextAddr()
+		if atomic.CompareAndSwapPointer(&l.head, head, atomic.LoadPointer(next)) {
+			atomic.StorePointer(next, nil)
+			atomic.AddInt32(&l.len, -1)
+			return n
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package reclaim 封装queue,stack的无锁链表共用的node回收：
+// 危险指针(hazard)和epoch两种回收方式，以及epoch方式复用node的空闲链表。
+package reclaim
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/min1324/data/epoch"
+	"github.com/min1324/data/hazard"
+)
+
+// Mode 无锁链表node的回收方式。
+type Mode uint32
+
+const (
+	// Hazard 危险指针，访问node前先保护，默认方式。
+	Hazard Mode = iota
+
+	// Epoch 基于epoch回收，每次操作只Pin一次，开销更低。
+	// 回收的node放入空闲链表，供新node复用。
+	Epoch
+)
+
+// Reclaimer 封装危险指针和epoch两种回收方式，零值使用危险指针。
+// 使用前设置Mode，再调用Init。
+type Reclaimer struct {
+	Mode    Mode
+	hp      hazard.Domain
+	ebr     epoch.Domain
+	reclaim func(unsafe.Pointer)
+
+	// readers 正在遍历链表的Range数量，见Hold
+	readers int32
+}
+
+// Guard 一次操作期间的回收凭证。
+type Guard struct {
+	rec *hazard.Record
+	eg  epoch.Guard
+}
+
+// Init 设置回收函数，退休的node确认不再被访问后交给reclaim。
+func (r *Reclaimer) Init(reclaim func(unsafe.Pointer)) {
+	r.reclaim = reclaim
+	r.hp.SetReclaim(r.recycle)
+	r.ebr.SetReclaim(r.recycle)
+}
+
+// recycle 回收退休的node。有Range正在遍历时不复用node，直接交给GC，
+// 遍历经过的node即使已经移出链表，它的值和next也不会被清空。
+func (r *Reclaimer) recycle(p unsafe.Pointer) {
+	if atomic.LoadInt32(&r.readers) > 0 {
+		return
+	}
+	r.reclaim(p)
+}
+
+// Hold 开始遍历，之后从链表读取到的node在Release前都不会被回收复用。
+// 在Hold之前已经退休的node，遍历时不可能再读取到。
+func (r *Reclaimer) Hold() {
+	atomic.AddInt32(&r.readers, 1)
+}
+
+// Release 结束遍历，之后退休的node恢复回收复用。
+func (r *Reclaimer) Release() {
+	atomic.AddInt32(&r.readers, -1)
+}
+
+func (r *Reclaimer) Pin() (g Guard) {
+	if r.Mode == Epoch {
+		g.eg = r.ebr.Pin()
+	} else {
+		g.rec = r.hp.Acquire()
+	}
+	return
+}
+
+func (r *Reclaimer) Unpin(g Guard) {
+	if g.rec != nil {
+		r.hp.Release(g.rec)
+	} else {
+		g.eg.Unpin()
+	}
+}
+
+// Protect 读取addr指向的node，保证在Unpin前不会被回收。
+// epoch方式在Pin期间读取的node都不会被回收，直接读取即可。
+func (r *Reclaimer) Protect(g Guard, i int, addr *unsafe.Pointer) unsafe.Pointer {
+	if g.rec != nil {
+		return g.rec.Protect(i, addr)
+	}
+	return atomic.LoadPointer(addr)
+}
+
+// Retire 退休已经移出链表的node。
+func (r *Reclaimer) Retire(g Guard, p unsafe.Pointer) {
+	if g.rec != nil {
+		r.hp.Retire(g.rec, p)
+	} else {
+		g.eg.Retire(p)
+	}
+}
+
+// RetireChain 退休已经整体移出链表的一串node:[head,end)。
+// 危险指针方式需要逐个退休；epoch方式只需要一次Defer，避免退休列表过长。
+func (r *Reclaimer) RetireChain(g Guard, head, end unsafe.Pointer, next func(unsafe.Pointer) unsafe.Pointer) {
+	if g.rec == nil {
+		g.eg.Defer(func() {
+			for head != end && head != nil {
+				p := head
+				head = next(p)
+				r.recycle(p)
+			}
+		})
+		return
+	}
+	for head != end && head != nil {
+		p := head
+		head = next(p)
+		r.Retire(g, p)
+	}
+}
@@ -12,20 +12,20 @@ const (
 )
 
 // poolChain is a dynamically-sized version of LRQueue.
-type Chain struct {
+type Chain[T any] struct {
 	once sync.Once
 	// tail is the LRQueue to push to. This is only accessed
 	// by the producers, so reads and writes must be atomic.
-	tail *chainElt
+	tail *chainElt[T]
 
 	// head is the LRQueue to pop from. This is accessed
 	// by consumers, so reads and writes must be atomic.
-	head *chainElt
+	head *chainElt[T]
 }
 
-type chainElt struct {
+type chainElt[T any] struct {
 	// DRQueue
-	LRQueue
+	LRQueue[T]
 
 	// next and prev link to the adjacent poolChainElts in this
 	// poolChain.
@@ -37,36 +37,36 @@ type chainElt struct {
 	// prev is written atomically by the consumer and read
 	// atomically by the producer. It only transitions from
 	// non-nil to nil.
-	next *chainElt
+	next *chainElt[T]
 }
 
-func storeChainElt(pp **chainElt, v *chainElt) {
+func storeChainElt[T any](pp **chainElt[T], v *chainElt[T]) {
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(pp)), unsafe.Pointer(v))
 }
 
-func loadChainElt(pp **chainElt) *chainElt {
-	return (*chainElt)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(pp))))
+func loadChainElt[T any](pp **chainElt[T]) *chainElt[T] {
+	return (*chainElt[T])(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(pp))))
 }
 
-func casChainElt(pp **chainElt, old, new *chainElt) bool {
+func casChainElt[T any](pp **chainElt[T], old, new *chainElt[T]) bool {
 	return atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(pp)), unsafe.Pointer(old), unsafe.Pointer(new))
 }
 
-func (c *Chain) onceInit() {
+func (c *Chain[T]) onceInit() {
 	c.once.Do(func() {
 		c.init()
 	})
 }
 
-func (c *Chain) init() {
-	newNode := &chainElt{next: nil}
+func (c *Chain[T]) init() {
+	newNode := &chainElt[T]{next: nil}
 	newCap := 8
 	newNode.InitWith(newCap)
 	storeChainElt(&c.head, newNode)
 	storeChainElt(&c.tail, newNode)
 }
 
-func (c *Chain) Push(val interface{}) bool {
+func (c *Chain[T]) Push(val T) bool {
 	c.onceInit()
 	for {
 		tail := loadChainElt(&c.tail)
@@ -75,7 +75,7 @@ func (c *Chain) Push(val interface{}) bool {
 			return true
 		}
 		// 队列不存在或满了，需要扩容。
-		newNode := &chainElt{}
+		newNode := &chainElt[T]{}
 		newCap := tail.Cap() << 1
 		if newCap >= queueLimit {
 			newCap = queueLimit
@@ -92,7 +92,7 @@ func (c *Chain) Push(val interface{}) bool {
 	}
 }
 
-func (c *Chain) Pop() (val interface{}, ok bool) {
+func (c *Chain[T]) Pop() (val T, ok bool) {
 	c.onceInit()
 	head := loadChainElt(&c.head)
 	if head == nil {
@@ -111,7 +111,7 @@ func (c *Chain) Pop() (val interface{}, ok bool) {
 		}
 		// 当前的头部没有值，切换到下一个节点pop
 		if head2 == nil {
-			return nil, false
+			return
 		}
 		// The tail of the chain has been drained, so move on
 		// to the next dequeue. Try to drop it from the chain
@@ -127,7 +127,20 @@ func (c *Chain) Pop() (val interface{}, ok bool) {
 	}
 }
 
-func (c *Chain) Init() {
+// Peek 返回队头的值，不取出。
+// 和Pop一样依次查看每一段LRQueue，返回第一个不空的队头。
+func (c *Chain[T]) Peek() (val T, ok bool) {
+	c.onceInit()
+	for head := loadChainElt(&c.head); head != nil; head = loadChainElt(&head.next) {
+		if val, ok = head.Peek(); ok {
+			return
+		}
+	}
+	return
+}
+
+func (c *Chain[T]) Init() {
+	c.onceInit()
 	c.init()
 	// for {
 	// 	tail := loadChainElt(&c.tail)
@@ -143,7 +156,22 @@ func (c *Chain) Init() {
 	// }
 }
 
-func (c *Chain) Size() int {
+// Range 按出队顺序遍历每一段LRQueue，f返回false时停止。
+// 每一段的一致性见LRQueue.Range。
+func (c *Chain[T]) Range(f func(v T) bool) {
+	c.onceInit()
+	stop := false
+	for head := loadChainElt(&c.head); head != nil && !stop; head = loadChainElt(&head.next) {
+		head.Range(func(v T) bool {
+			if !f(v) {
+				stop = true
+			}
+			return !stop
+		})
+	}
+}
+
+func (c *Chain[T]) Size() int {
 	head := loadChainElt(&c.head)
 	var sum = 0
 	for head != nil {
@@ -153,5 +181,5 @@ func (c *Chain) Size() int {
 	return sum
 }
 
-func (c *Chain) EnQueue(val interface{}) bool { return c.Push(val) }
-func (c *Chain) DeQueue() (interface{}, bool) { return c.Pop() }
+func (c *Chain[T]) EnQueue(val T) bool { return c.Push(val) }
+func (c *Chain[T]) DeQueue() (T, bool) { return c.Pop() }
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// 非阻塞队列满(空)时轮询的间隔，从pollMin加倍到pollMax。
+const (
+	pollMin = time.Microsecond
+	pollMax = time.Millisecond
+)
+
+// poller 队列满(空)时等待，第一次只让出cpu，之后sleep的间隔逐次加倍，ctx取消时返回ctx.Err()。
+type poller struct {
+	d time.Duration
+}
+
+func (p *poller) wait(ctx context.Context) error {
+	if p.d == 0 {
+		p.d = pollMin
+		runtime.Gosched()
+		return ctx.Err()
+	}
+	t := time.NewTimer(p.d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if p.d < pollMax {
+		p.d <<= 1
+	}
+	return nil
+}
+
+// ctxEnQueuer 满时可以挂起等待的队列，如BlockingQueue。
+type ctxEnQueuer[T any] interface {
+	EnQueueCtx(ctx context.Context, val T) error
+}
+
+// ctxDeQueuer 空时可以挂起等待的队列，如BlockingQueue,LLQueue,LCRQueue。
+type ctxDeQueuer[T any] interface {
+	DeQueueCtx(ctx context.Context) (T, error)
+}
+
+// isClosed q是否已经关闭。只有提供Done的队列可以关闭。
+func isClosed[T any](q Queue[T]) bool {
+	dq, ok := q.(interface{ Done() <-chan struct{} })
+	if !ok {
+		return false
+	}
+	select {
+	case <-dq.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// enQueueWait 加入q，满时等待，q关闭返回ErrClosed，ctx取消返回ctx.Err()。
+// 有EnQueueCtx的队列挂起等待，其他队列轮询。
+func enQueueWait[T any](ctx context.Context, q Queue[T], val T) error {
+	if cq, ok := q.(ctxEnQueuer[T]); ok {
+		return cq.EnQueueCtx(ctx, val)
+	}
+	var p poller
+	for !q.EnQueue(val) {
+		if isClosed(q) {
+			return ErrClosed
+		}
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deQueueWait 从q取出，空时等待，q关闭并且取完返回ErrClosed，ctx取消返回ctx.Err()。
+// 有DeQueueCtx的队列挂起等待，其他队列轮询。
+func deQueueWait[T any](ctx context.Context, q Queue[T]) (val T, err error) {
+	if cq, ok := q.(ctxDeQueuer[T]); ok {
+		return cq.DeQueueCtx(ctx)
+	}
+	var p poller
+	for {
+		if val, ok := q.DeQueue(); ok {
+			return val, nil
+		}
+		if isClosed(q) {
+			// Done关闭后EnQueue不会再成功，再取一次，取不到就是取完了
+			if val, ok := q.DeQueue(); ok {
+				return val, nil
+			}
+			return val, ErrClosed
+		}
+		if err = p.wait(ctx); err != nil {
+			return
+		}
+	}
+}
+
+// Pump 将ch中的值依次加入q，直到ch关闭，返回加入的数量。
+// q满时等待，q关闭时返回ErrClosed，已经从ch取出的那个值被丢弃，ch中剩下的值不会取出。
+// ctx取消时返回ctx.Err()。
+func Pump[T any](ctx context.Context, ch <-chan T, q Queue[T]) (n int, err error) {
+	for {
+		select {
+		case val, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err = enQueueWait(ctx, q, val); err != nil {
+				return
+			}
+			n++
+		case <-ctx.Done():
+			return n, ctx.Err()
+		}
+	}
+}
+
+// In 返回一个channel，发送到channel的值由后台goroutine加入q，q满时发送阻塞。
+//
+// 关闭channel后，后台goroutine退出，q有Close时被关闭，Out取完剩下的值后也关闭。
+// q被其他地方关闭后，后台goroutine继续接收并丢弃发送的值，发送者不会一直阻塞，
+// 直到channel被关闭。
+// ctx取消后后台goroutine马上退出，不再接收，也不关闭q，发送者应该同时等待ctx.Done()。
+func In[T any](ctx context.Context, q Queue[T]) chan<- T {
+	in := make(chan T)
+	go func() {
+		if _, err := Pump(ctx, in, q); err != nil {
+			if err != ErrClosed {
+				return
+			}
+			for {
+				select {
+				case _, ok := <-in:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if cq, ok := q.(interface{ Close() }); ok {
+			cq.Close()
+		}
+	}()
+	return in
+}
+
+// Out 返回一个channel，后台goroutine不断从q取出值发送到channel，可以放在select中接收。
+// In和Out接在同一个LLQueue，Chain等无界队列上，就是一个无界的channel。
+//
+// q关闭并且取完，或者ctx取消后，后台goroutine退出并关闭channel。
+// 不能关闭的队列(Chain,MPSCQueue)只能通过ctx停止。
+// 后台goroutine是q的一个消费者，取出值后阻塞在发送上，ctx取消时这个值被丢弃；
+// 所以接收者要一直取到channel关闭，或者取消ctx，否则goroutine和取出的值都不会释放。
+func Out[T any](ctx context.Context, q Queue[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			val, err := deQueueWait(ctx, q)
+			if err != nil {
+				return
+			}
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
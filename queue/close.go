@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ErrClosed 队列已经关闭。
+// EnQueueCtx在关闭后返回ErrClosed，DeQueueCtx在关闭并且取完后返回ErrClosed。
+var ErrClosed = errors.New("queue: closed")
+
+// closedNext 关闭标记，Close将最后一个node(ring)的next从nil换成closedNext，
+// 之后不能再连接新的node(ring)。只用来比较，不能访问。
+var closedNext = unsafe.Pointer(new(byte))
+
+// closer 记录队列是否关闭，零值可用。
+//
+// 关闭分两步：先标记closed，EnQueue看到后失败；
+// 再seal等待标记前开始的EnQueue完成，或者阻止它们成功，之后标记sealed。
+// sealed之后队列不会再增加值，DeQueue取不到值就是取完了。
+type closer struct {
+	closed uint32
+	sealed uint32
+	mu     sync.Mutex
+	done   chan struct{}
+}
+
+func (c *closer) isClosed() bool {
+	return atomic.LoadUint32(&c.closed) != 0
+}
+
+func (c *closer) isSealed() bool {
+	return atomic.LoadUint32(&c.sealed) != 0
+}
+
+// close 标记关闭，执行seal让EnQueue不能再成功，最后关闭done。
+// 所以Done返回的channel关闭后，EnQueue一定失败。已经关闭返回false。
+func (c *closer) close(seal func()) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed != 0 {
+		return false
+	}
+	atomic.StoreUint32(&c.closed, 1)
+	seal()
+	atomic.StoreUint32(&c.sealed, 1)
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	close(c.done)
+	return true
+}
+
+func (c *closer) doneChan() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	return c.done
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *SAQueue[T]) Close() {
+	q.onceInit()
+	ok := q.cl.close(func() {
+		q.mu.Lock()
+		q.mu.Unlock()
+	})
+	if ok {
+		q.notEmpty.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *SAQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+// drained 队列已经关闭，并且值都已经取出。
+func (q *SAQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *SRQueue[T]) Close() {
+	q.onceInit()
+	ok := q.cl.close(func() {
+		q.mu.Lock()
+		q.mu.Unlock()
+	})
+	if ok {
+		q.notEmpty.signal()
+		q.notFull.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *SRQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *SRQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *DRQueue[T]) Close() {
+	q.onceInit()
+	// 标记关闭后等待持有enMu的EnQueue完成，之后没有正在写入的EnQueue。
+	ok := q.cl.close(func() {
+		q.enMu.Lock()
+		q.enMu.Unlock()
+	})
+	if ok {
+		q.notEmpty.signal()
+		q.notFull.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *DRQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *DRQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *SLQueue[T]) Close() {
+	q.onceInit()
+	ok := q.cl.close(func() {
+		q.mu.Lock()
+		q.mu.Unlock()
+	})
+	if ok {
+		q.notEmpty.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *SLQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *SLQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *DLQueue[T]) Close() {
+	q.onceInit()
+	ok := q.cl.close(func() {
+		q.enMu.Lock()
+		q.enMu.Unlock()
+	})
+	if ok {
+		q.notEmpty.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *DLQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *DLQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+//
+// 将最后一个node的next换成closedNext，之后EnQueue不能再连接node，
+// 关闭前已经连接的node都会储存完成，所以DeQueue能取完关闭前入队的值。
+func (q *LLQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(q.seal) {
+		q.notEmpty.signal()
+	}
+}
+
+// seal 将最后一个node的next换成closedNext。
+func (q *LLQueue[T]) seal() {
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
+	for {
+		tail := q.rc.Protect(g, 0, &q.tail)
+		slot := (*ptrNode[T])(tail)
+		next := atomic.LoadPointer(&slot.next)
+		if tail != atomic.LoadPointer(&q.tail) {
+			continue
+		}
+		if next == closedNext {
+			break
+		}
+		if next != nil {
+			cas(&q.tail, tail, next)
+			continue
+		}
+		if cas(&slot.next, nil, closedNext) {
+			break
+		}
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *LLQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+// drained 关闭后head==tail，没有正在储存的node。
+func (q *LLQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+//
+// 关闭最后一个ring，并将它的next换成closedNext，之后不能再扩容。
+func (q *LRQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(q.seal) {
+		q.notEmpty.signal()
+		q.notFull.signal()
+	}
+}
+
+// seal 关闭最后一个ring，并将它的next换成closedNext。
+func (q *LRQueue[T]) seal() {
+	for {
+		tail := q.loadTail()
+		tail.close()
+		if cas(&tail.next, nil, closedNext) {
+			break
+		}
+		next := atomic.LoadPointer(&tail.next)
+		if next == closedNext {
+			break
+		}
+		cas(&q.tail, unsafe.Pointer(tail), next)
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *LRQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+// drained 所有ring都已经关闭并且取完，预留了还没写入的slot也算没取完。
+func (q *LRQueue[T]) drained() bool {
+	if !q.cl.isSealed() {
+		return false
+	}
+	for r := q.loadHead(); r != nil; r = r.loadNext() {
+		if !r.drained() {
+			return false
+		}
+	}
+	return true
+}
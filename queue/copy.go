@@ -1,40 +1,96 @@
 package queue
 
 import (
+	"sync"
 	"sync/atomic"
 	"unsafe"
+
+	"github.com/min1324/data/backoff"
+	"github.com/min1324/data/hazard"
 )
 
 // LKQueue is a lock-free unbounded queue.
-type LKQueue struct {
+type LKQueue[T any] struct {
+	once sync.Once
 	len  uint32
 	head unsafe.Pointer
 	tail unsafe.Pointer
+
+	// 出队的哨兵node用危险指针保护，安全后清空放入pool复用。
+	hp   hazard.Domain
+	pool sync.Pool
+
+	// bo cas失败后的退避策略，nil时立即重试，见NewLKQueueWith
+	bo backoff.Backoff
 }
-type lkNode struct {
-	value interface{}
+type lkNode[T any] struct {
+	value T
 	next  unsafe.Pointer
 }
 
 // NewLKQueue returns an empty queue.
-func NewLKQueue() *LKQueue {
-	n := unsafe.Pointer(&lkNode{})
-	return &LKQueue{head: n, tail: n}
+func NewLKQueue[T any]() *LKQueue[T] {
+	var q LKQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// NewLKQueueWith returns an empty queue, cas失败后按b退避。
+func NewLKQueueWith[T any](b backoff.Backoff) *LKQueue[T] {
+	var q LKQueue[T]
+	q.bo = b
+	q.onceInit()
+	return &q
+}
+
+func (q *LKQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init()
+	})
+}
+
+func (q *LKQueue[T]) init() {
+	n := unsafe.Pointer(&lkNode[T]{})
+	q.head = n
+	q.tail = n
+	q.len = 0
+	q.hp.SetReclaim(q.reclaim)
+}
+
+func (q *LKQueue[T]) newNode(v T) *lkNode[T] {
+	if n, ok := q.pool.Get().(*lkNode[T]); ok {
+		n.value = v
+		return n
+	}
+	return &lkNode[T]{value: v}
+}
+
+func (q *LKQueue[T]) reclaim(p unsafe.Pointer) {
+	var zero T
+	n := (*lkNode[T])(p)
+	n.value = zero
+	atomic.StorePointer(&n.next, nil)
+	q.pool.Put(n)
 }
 
 // Enqueue puts the given value v at the tail of the queue.
-func (q *LKQueue) EnQueue(v interface{}) bool {
-	n := &lkNode{value: v}
-	for {
-		tail := loadlkNode(&q.tail)
-		next := loadlkNode(&tail.next)
-		if tail == loadlkNode(&q.tail) { // are tail and next consistent?
+func (q *LKQueue[T]) EnQueue(v T) bool {
+	q.onceInit()
+	rec := q.hp.Acquire()
+	defer q.hp.Release(rec)
+
+	n := q.newNode(v)
+	for i := 0; ; i++ {
+		tail := (*lkNode[T])(rec.Protect(0, &q.tail))
+		next := loadlkNode[T](&tail.next)
+		if tail == loadlkNode[T](&q.tail) { // are tail and next consistent?
 			if next == nil {
 				if caslkNode(&tail.next, next, n) {
 					caslkNode(&q.tail, tail, n) // Enqueue is done.  try to swing tail to the inserted lkNode
 					atomic.AddUint32(&q.len, 1)
 					return true
 				}
+				backoff.Wait(q.bo, i)
 			} else { // tail was not pointing to the last lkNode
 				// try to swing Tail to the next lkNode
 				caslkNode(&q.tail, tail, next)
@@ -44,16 +100,21 @@ func (q *LKQueue) EnQueue(v interface{}) bool {
 }
 
 // Dequeue removes and returns the value at the head of the queue.
-// It returns nil if the queue is empty.
-func (q *LKQueue) DeQueue() (val interface{}, ok bool) {
-	for {
-		head := loadlkNode(&q.head)
-		tail := loadlkNode(&q.tail)
-		next := loadlkNode(&head.next)
-		if head == loadlkNode(&q.head) { // are head, tail, and next consistent?
+// It returns false if the queue is empty.
+func (q *LKQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	rec := q.hp.Acquire()
+	defer q.hp.Release(rec)
+
+	for i := 0; ; i++ {
+		head := (*lkNode[T])(rec.Protect(0, &q.head))
+		tail := loadlkNode[T](&q.tail)
+		// next的value在cas之前读取，也需要保护
+		next := (*lkNode[T])(rec.Protect(1, &head.next))
+		if head == loadlkNode[T](&q.head) { // are head, tail, and next consistent?
 			if head == tail { // is queue empty or tail falling behind?
 				if next == nil { // is queue empty?
-					return nil, false
+					return
 				}
 				// tail is falling behind.  try to advance it
 				caslkNode(&q.tail, tail, next)
@@ -62,30 +123,54 @@ func (q *LKQueue) DeQueue() (val interface{}, ok bool) {
 				v := next.value
 				if caslkNode(&q.head, head, next) {
 					atomic.AddUint32(&q.len, negativeOne)
-					atomic.StorePointer(&head.next, nil)
+					// 旧的哨兵node不能直接释放，其他goroutine可能还在读取。
+					q.hp.Retire(rec, unsafe.Pointer(head))
 					return v, true // Dequeue is done.  return
 				}
+				backoff.Wait(q.bo, i)
 			}
 		}
 	}
 }
 
-func (q *LKQueue) Init() {
-	n := unsafe.Pointer(&lkNode{})
+// Peek returns the value at the head of the queue without removing it.
+// 保护head和head.next，确认head没有变化后读取值。
+func (q *LKQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	rec := q.hp.Acquire()
+	defer q.hp.Release(rec)
+
+	for {
+		head := (*lkNode[T])(rec.Protect(0, &q.head))
+		next := (*lkNode[T])(rec.Protect(1, &head.next))
+		if head != loadlkNode[T](&q.head) {
+			continue
+		}
+		// head还是哨兵，next没有出队，被保护后不会回收
+		if next == nil {
+			return
+		}
+		return next.value, true
+	}
+}
+
+func (q *LKQueue[T]) Init() {
+	q.onceInit()
+	n := unsafe.Pointer(&lkNode[T]{})
 	q.tail = n
 	q.head = n
 	q.len = 0
 }
 
-func (q *LKQueue) Size() int {
+func (q *LKQueue[T]) Size() int {
 	return int(q.len)
 }
 
-func loadlkNode(p *unsafe.Pointer) (n *lkNode) {
-	return (*lkNode)(atomic.LoadPointer(p))
+func loadlkNode[T any](p *unsafe.Pointer) (n *lkNode[T]) {
+	return (*lkNode[T])(atomic.LoadPointer(p))
 }
 
-func caslkNode(p *unsafe.Pointer, old, new *lkNode) (ok bool) {
+func caslkNode[T any](p *unsafe.Pointer, old, new *lkNode[T]) (ok bool) {
 	return atomic.CompareAndSwapPointer(
 		p, unsafe.Pointer(old), unsafe.Pointer(new))
 }
@@ -2,13 +2,20 @@ package queue
 
 /*
 // Queue接口
-type Queue interface {
-	EnQueue(interface{}) bool
-	DeQueue() (val interface{}, ok bool)
+type Queue[T any] interface {
+	EnQueue(T) bool
+	DeQueue() (val T, ok bool)
 }
 
-type DataQueue interface {
-	Queue
+type DataQueue[T any] interface {
+	Queue[T]
+	EnQueueMany(vals []T) (n int)
+	DeQueueMany(dst []T) (n int)
+	Peek() (val T, ok bool)
+	Close()
+	Done() <-chan struct{}
+	Range(f func(v T) bool)
+	Snapshot() []T
 	onceInit()
 	Init()
 	Size() int
@@ -16,7 +23,7 @@ type DataQueue interface {
 	Empty() bool
 }
 
-type XXQueue struct {
+type XXQueue[T any] struct {
 	// 链表形式
 	//
 	// once sync.Once
@@ -35,17 +42,18 @@ type XXQueue struct {
 	// mod  uint32
 	// deID uint32 // 指向下次取出数据的位置:deID&mod
 	// enID uint32 // 指向下次写入数据的位置:enID&mod
-	// data []node
+	// data []node[T]
 	//
 	// 额外可选项
 	// deMu sync.Mutex // pop操作锁
 	// enMu sync.Mutex // push操作锁
 }
 
-type XXNode struct {
+type XXNode[T any] struct {
 	// 链表节点
 	next  unsafe.Pointer
-	p     interface{}
+	p     T
+	state uint32 // slot是否储存了值
 
 	// 额外可选
 	// prev unsafe.Pointer
@@ -64,26 +72,38 @@ const (
 环形		q.deID == q.enID		q.enID-q.deID == q.cap
 
 slot:储存或者取出value时的节点node。
-slot储存的val为空，则可以存入val，或者是DeQueue时为队列空。
-val不为空时，可以取出val，或者是EnQueue时队列满。
+值以T类型直接储存，不再装箱，也不再需要包装nil值。
+slot的state为0，则可以存入val，或者是DeQueue时为队列空。
+state不为0时，可以取出val，或者是EnQueue时队列满。
 
 链表队列：
-head指向第一个出队的node,如果node的val为空，则可能由EnQueue操作，但未完成。
+head指向第一个出队的node,如果node的state为0，则可能由EnQueue操作，但未完成。
 此时队列依旧认为空，直接返回。
 tail指向下一个EnQueue的位置slot，先加入一个nilNode在slot.next,移动tail=slot.next。
 然后在将value存入slot，完成加入操作。
+lock-free链表队列访问node前先用危险指针(hazard pointer)保护，
+出队的node先退休，没有goroutine保护时才清空并回收复用，见hazard包。
 
 数组队列：
 deID指向下次出队的node,enID指向下次入队的node,先操作，后移动ID.
+enID,deID是uint32，约40亿次操作后回绕。数量只用enID-deID计算，
+cap为2^n并且不超过queueLimit，回绕后差值依旧正确，不能直接比较enID和deID的大小。
+SRQueue,DRQueue,LRQueue,SPRQueue的InitAt可以让ID从回绕点附近开始。
 
 结构体名字：XXQueue
 第一个字母:
 single mutex	=>	S
 dobule mutex	=>	D
 lock-free		=>	L
+single producer/consumer	=>	SP	// wait-free
+multi producer single consumer	=>	MPSC
+wait-free		=>	W
 
 第二个字母:
 list	=>	L	// 链表
 array	=>	A	// 数组
 ring 	=>	R	// 环形数组
+
+LCRQueue: lock-free的FAA环形数组链(linked concurrent ring queue)。
+ShardQueue: n路队列，每一路是一个Shard(DataQueue或者Chain)，宽松FIFO。
 */
@@ -0,0 +1,397 @@
+// Package durable 实现基于文件的持久化队列。
+//
+// 值由codec.Codec编码后追加到dir中的日志文件，每个文件写满SegmentSize后切换到新的文件。
+// 出队的序号记录在offset文件中，重新Open后从上次的位置继续取出。
+// 读取完的日志文件被删除。
+//
+// 崩溃恢复：最后一个日志文件可能有一条没有写完的记录，
+// Open时校验每条记录的长度和crc，截断不完整的部分。
+// offset文件的两个slot轮流写入，写到一半崩溃时使用另一个slot。
+//
+// Sync为false时，EnQueue和DeQueue只写入操作系统的缓存，
+// 进程崩溃不会丢失，系统崩溃会丢失最后一次Sync之后的操作：
+// 入队的值可能丢失，出队的值可能再次取出。
+// Sync为true时，每次操作都fsync，系统崩溃最多再次取出最后一个出队的值。
+package durable
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/min1324/data/codec"
+	"github.com/min1324/data/queue"
+)
+
+const (
+	// DefaultSegmentSize 默认日志文件大小
+	DefaultSegmentSize = 1 << 26
+
+	// maxRecordSize 单条记录payload的最大长度
+	maxRecordSize = 1<<32 - 1
+)
+
+var (
+	// ErrCorrupt 日志文件中间的记录损坏，或者日志文件缺失。
+	ErrCorrupt = errors.New("durable: corrupt log")
+
+	// ErrTooLarge 编码后的值超过maxRecordSize。
+	ErrTooLarge = errors.New("durable: value too large")
+)
+
+var _ queue.Queue[int] = (*Queue[int])(nil)
+
+// Options 持久化队列的设置，零值使用默认设置。
+type Options struct {
+	// SegmentSize 单个日志文件的大小，超过后切换到新的文件。
+	// 单条记录大于SegmentSize时独占一个文件。默认DefaultSegmentSize。
+	SegmentSize int64
+
+	// Sync 每次EnQueue，DeQueue后fsync。
+	// 为false时只在调用Sync，Close时fsync。
+	Sync bool
+}
+
+// Queue is a file-backed FIFO queue that survives process restarts.
+//
+// 单锁队列，EnQueue，DeQueue出错时返回false，之后的操作都失败，错误由Err返回。
+type Queue[T any] struct {
+	mu    sync.Mutex
+	dir   string
+	codec codec.Codec[T]
+	opt   Options
+
+	// segs 还没有删除的日志文件的起始序号，升序。
+	// segs[0]正在读取，segs[len(segs)-1]正在写入。
+	segs []uint64
+
+	w     *os.File // 正在写入的日志文件
+	wSize int64    // w中完整记录的大小
+	enSeq uint64   // 下一个入队的序号
+	wbuf  []byte
+
+	r     *os.File // 正在读取的日志文件
+	rPos  int64    // 下一条记录在r中的位置
+	rEnd  int64    // r的大小，r正在写入时使用wSize
+	deSeq uint64   // 下一个出队的序号
+	rbuf  []byte
+
+	off     *os.File // offset文件
+	offSlot int      // 上次写入的slot
+
+	err    error
+	closed bool
+}
+
+// Open 打开dir中的队列，dir不存在时创建，使用默认设置。
+func Open[T any](dir string, c codec.Codec[T]) (*Queue[T], error) {
+	return OpenWith(dir, c, Options{})
+}
+
+// OpenWith 打开dir中的队列，dir不存在时创建。
+// 最后一个日志文件末尾不完整的记录被截断。
+// 同一个dir同一时刻只能由一个Queue打开。
+func OpenWith[T any](dir string, c codec.Codec[T], opt Options) (*Queue[T], error) {
+	if opt.SegmentSize <= 0 {
+		opt.SegmentSize = DefaultSegmentSize
+	}
+	q := &Queue[T]{dir: dir, codec: c, opt: opt}
+	if err := q.open(); err != nil {
+		q.closeFiles()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue[T]) open() (err error) {
+	if err = os.MkdirAll(q.dir, 0o755); err != nil {
+		return
+	}
+	q.off, err = os.OpenFile(filepath.Join(q.dir, offsetName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return
+	}
+	deSeq, slot, _ := readOffset(q.off)
+	q.offSlot = slot
+
+	if q.segs, err = listSegments(q.dir); err != nil {
+		return
+	}
+	if len(q.segs) == 0 {
+		// 新的队列，或者日志文件都已经读取完并删除
+		if q.w, err = q.createSegment(deSeq); err != nil {
+			return
+		}
+		q.segs = []uint64{deSeq}
+		q.enSeq = deSeq
+	} else if err = q.recoverTail(); err != nil {
+		return
+	}
+
+	// 没有fsync的offset可能在已经删除的文件中，
+	// 没有fsync的日志可能比offset少。
+	if deSeq < q.segs[0] {
+		deSeq = q.segs[0]
+	}
+	if deSeq > q.enSeq {
+		deSeq = q.enSeq
+	}
+	q.deSeq = deSeq
+	for len(q.segs) > 1 && q.segs[1] <= q.deSeq {
+		if err = q.removeHead(); err != nil {
+			return
+		}
+	}
+	return q.openHead()
+}
+
+// recoverTail 打开最后一个日志文件，截断末尾不完整的记录。
+func (q *Queue[T]) recoverTail() (err error) {
+	base := q.segs[len(q.segs)-1]
+	if q.w, err = os.OpenFile(segmentPath(q.dir, base), os.O_RDWR, 0o644); err != nil {
+		return
+	}
+	st, err := q.w.Stat()
+	if err != nil {
+		return
+	}
+	n, size, err := scanSegment(q.w, st.Size())
+	if err != nil {
+		return
+	}
+	if err = q.w.Truncate(size); err != nil {
+		return
+	}
+	q.wSize = size
+	q.enSeq = base + n
+	return
+}
+
+// openHead 打开segs[0]，跳到deSeq处。
+func (q *Queue[T]) openHead() (err error) {
+	if q.r, err = os.Open(segmentPath(q.dir, q.segs[0])); err != nil {
+		if os.IsNotExist(err) {
+			err = ErrCorrupt
+		}
+		return
+	}
+	st, err := q.r.Stat()
+	if err != nil {
+		return
+	}
+	q.rEnd = st.Size()
+	q.rPos, err = skipRecords(q.r, q.deSeq-q.segs[0])
+	return
+}
+
+// createSegment 创建以base开始的日志文件。
+func (q *Queue[T]) createSegment(base uint64) (*os.File, error) {
+	f, err := os.OpenFile(segmentPath(q.dir, base), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if q.opt.Sync {
+		if err = syncDir(q.dir); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// removeHead 删除已经读取完的segs[0]。
+func (q *Queue[T]) removeHead() error {
+	if q.r != nil {
+		q.r.Close()
+		q.r = nil
+	}
+	if err := os.Remove(segmentPath(q.dir, q.segs[0])); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.segs = q.segs[1:]
+	return nil
+}
+
+// fail 记录第一个错误，之后的操作都失败。
+func (q *Queue[T]) fail(err error) bool {
+	if q.err == nil {
+		q.err = err
+	}
+	return false
+}
+
+// EnQueue 编码val，追加到最后一个日志文件。
+func (q *Queue[T]) EnQueue(val T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.err != nil {
+		return false
+	}
+	payload, err := q.codec.Encode(val)
+	if err != nil {
+		return q.fail(err)
+	}
+	if int64(len(payload)) > maxRecordSize {
+		return q.fail(ErrTooLarge)
+	}
+	q.wbuf = appendRecord(q.wbuf[:0], payload)
+	if q.wSize > 0 && q.wSize+int64(len(q.wbuf)) > q.opt.SegmentSize {
+		if err = q.rotate(); err != nil {
+			return q.fail(err)
+		}
+	}
+	if _, err = q.w.WriteAt(q.wbuf, q.wSize); err != nil {
+		return q.fail(err)
+	}
+	if q.opt.Sync {
+		if err = q.w.Sync(); err != nil {
+			return q.fail(err)
+		}
+	}
+	q.wSize += int64(len(q.wbuf))
+	q.enSeq++
+	return true
+}
+
+// rotate 关闭写满的日志文件，创建以enSeq开始的新文件。
+// 旧文件总是先fsync，所以只有最后一个日志文件可能不完整。
+func (q *Queue[T]) rotate() error {
+	if err := q.w.Sync(); err != nil {
+		return err
+	}
+	w, err := q.createSegment(q.enSeq)
+	if err != nil {
+		return err
+	}
+	if len(q.segs) == 1 {
+		// 正在读取的文件不再写入，大小固定
+		q.rEnd = q.wSize
+	}
+	q.w.Close()
+	q.w, q.wSize = w, 0
+	q.segs = append(q.segs, q.enSeq)
+	return nil
+}
+
+// DeQueue 读取下一条记录并解码，记录出队的序号。
+// 读取完一个日志文件后删除它。
+func (q *Queue[T]) DeQueue() (val T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.err != nil || q.deSeq == q.enSeq {
+		return
+	}
+	if len(q.segs) > 1 && q.deSeq == q.segs[1] {
+		if err := q.removeHead(); err != nil {
+			q.fail(err)
+			return
+		}
+		if err := q.openHead(); err != nil {
+			q.fail(err)
+			return
+		}
+	}
+	end := q.rEnd
+	if len(q.segs) == 1 {
+		end = q.wSize
+	}
+	payload, err := readRecord(q.r, q.rPos, q.rbuf, end)
+	if err != nil {
+		if err == errTorn {
+			err = ErrCorrupt
+		}
+		q.fail(err)
+		return
+	}
+	q.rbuf = payload
+	if val, err = q.codec.Decode(payload); err != nil {
+		q.fail(err)
+		return
+	}
+	if err = q.commit(q.deSeq + 1); err != nil {
+		q.fail(err)
+		return
+	}
+	q.rPos += headerSize + int64(len(payload))
+	return val, true
+}
+
+// commit 将下一个出队的序号写入offset文件。
+func (q *Queue[T]) commit(seq uint64) error {
+	slot := q.offSlot ^ 1
+	if err := writeOffset(q.off, slot, seq); err != nil {
+		return err
+	}
+	if q.opt.Sync {
+		if err := q.off.Sync(); err != nil {
+			return err
+		}
+	}
+	q.offSlot = slot
+	q.deSeq = seq
+	return nil
+}
+
+// Size 队列中值的数量。
+func (q *Queue[T]) Size() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int(q.enSeq - q.deSeq)
+}
+
+func (q *Queue[T]) Empty() bool {
+	return q.Size() == 0
+}
+
+// Err 返回第一个导致操作失败的错误。
+func (q *Queue[T]) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Sync fsync日志文件和offset文件。
+func (q *Queue[T]) Sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return os.ErrClosed
+	}
+	return q.sync()
+}
+
+func (q *Queue[T]) sync() error {
+	if err := q.w.Sync(); err != nil {
+		return err
+	}
+	return q.off.Sync()
+}
+
+// Close fsync并关闭所有文件，之后EnQueue，DeQueue失败。
+func (q *Queue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	err := q.sync()
+	if cerr := q.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (q *Queue[T]) closeFiles() (err error) {
+	for _, f := range []**os.File{&q.r, &q.w, &q.off} {
+		if *f == nil {
+			continue
+		}
+		if cerr := (*f).Close(); err == nil {
+			err = cerr
+		}
+		*f = nil
+	}
+	return
+}
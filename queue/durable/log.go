@@ -0,0 +1,188 @@
+package durable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 日志文件由连续的记录组成，每条记录:
+//
+//	length uint32	// payload的长度，小端
+//	crc    uint32	// payload的crc32(Castagnoli)
+//	payload [length]byte
+//
+// 日志文件名是第一条记录的序号，见segmentName。
+// 序号从0开始，每条记录加1，跨文件连续，所以下一个文件的名字就是上一个文件的结束序号。
+const (
+	headerSize = 8
+
+	segmentExt = ".log"
+	offsetName = "offset"
+
+	// offset文件有两个slot，轮流写入，每个slot:
+	//	seq uint64 // 下一个出队的序号
+	//	crc uint32 // seq的crc32
+	//	_   uint32
+	offsetSlotSize = 16
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn 记录不完整或者校验失败，只在恢复最后一个日志文件时出现。
+var errTorn = errors.New("durable: torn record")
+
+func segmentName(base uint64) string {
+	return fmt.Sprintf("%020d%s", base, segmentExt)
+}
+
+// listSegments 返回dir中所有日志文件的起始序号，升序。
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, base)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+// appendRecord 将payload编码成一条记录，追加到buf后面。
+func appendRecord(buf, payload []byte) []byte {
+	var h [headerSize]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(h[4:], crc32.Checksum(payload, crcTable))
+	buf = append(buf, h[:]...)
+	return append(buf, payload...)
+}
+
+// readRecord 读取f中pos处的一条记录，payload复用buf。
+// 记录超过end，或者校验失败，返回errTorn。
+func readRecord(f *os.File, pos int64, buf []byte, end int64) (payload []byte, err error) {
+	if pos+headerSize > end {
+		return nil, errTorn
+	}
+	var h [headerSize]byte
+	if _, err = f.ReadAt(h[:], pos); err != nil {
+		if err == io.EOF {
+			err = errTorn
+		}
+		return
+	}
+	n := int64(binary.LittleEndian.Uint32(h[0:]))
+	if pos+headerSize+n > end {
+		return nil, errTorn
+	}
+	if int64(cap(buf)) < n {
+		buf = make([]byte, n)
+	}
+	payload = buf[:n]
+	if _, err = f.ReadAt(payload, pos+headerSize); err != nil {
+		if err == io.EOF {
+			err = errTorn
+		}
+		return
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(h[4:]) {
+		return nil, errTorn
+	}
+	return payload, nil
+}
+
+// scanSegment 从头读取大小为end的f，返回完整记录的数量和结束位置。
+// 遇到不完整的记录时停止，后面的内容是崩溃时没有写完的。
+func scanSegment(f *os.File, end int64) (n uint64, size int64, err error) {
+	var buf []byte
+	for {
+		buf, err = readRecord(f, size, buf, end)
+		if err == errTorn {
+			return n, size, nil
+		}
+		if err != nil {
+			return
+		}
+		n++
+		size += headerSize + int64(len(buf))
+	}
+}
+
+// skipRecords 从头跳过f中的n条记录，返回第n条记录的位置。
+// 只读取长度，不校验payload。
+func skipRecords(f *os.File, n uint64) (pos int64, err error) {
+	var h [headerSize]byte
+	for ; n > 0; n-- {
+		if _, err = f.ReadAt(h[:], pos); err != nil {
+			if err == io.EOF {
+				err = ErrCorrupt
+			}
+			return
+		}
+		pos += headerSize + int64(binary.LittleEndian.Uint32(h[0:]))
+	}
+	return
+}
+
+// readOffset 读取offset文件，返回两个slot中有效的较大的序号。
+// 新的文件或者没有有效的slot时，ok为false。
+func readOffset(f *os.File) (seq uint64, slot int, ok bool) {
+	var b [2 * offsetSlotSize]byte
+	n, _ := f.ReadAt(b[:], 0)
+	for i := 0; i < 2; i++ {
+		s := b[i*offsetSlotSize : (i+1)*offsetSlotSize]
+		if n < (i+1)*offsetSlotSize {
+			break
+		}
+		if crc32.Checksum(s[:8], crcTable) != binary.LittleEndian.Uint32(s[8:]) {
+			continue
+		}
+		if v := binary.LittleEndian.Uint64(s); !ok || v > seq {
+			seq, slot, ok = v, i, true
+		}
+	}
+	return
+}
+
+// writeOffset 将seq写入offset文件的slot。
+// 两个slot轮流写入，写到一半崩溃时，另一个slot依旧有效。
+func writeOffset(f *os.File, slot int, seq uint64) error {
+	var b [offsetSlotSize]byte
+	binary.LittleEndian.PutUint64(b[:], seq)
+	binary.LittleEndian.PutUint32(b[8:], crc32.Checksum(b[:8], crcTable))
+	_, err := f.WriteAt(b[:], int64(slot*offsetSlotSize))
+	return err
+}
+
+// syncDir 持久化dir中文件的创建和删除。
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, segmentName(base))
+}
@@ -0,0 +1,606 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// LCRQueue is an unbounded lock-free queue of FAA-indexed ring segments (LCRQ).
+//
+// Morrison & Afek的LCRQ。每个ring(CRQ)的head,tail用FAA取得下标，
+// 不同的EnQueue(DeQueue)拿到不同的slot，不需要在同一个ID上cas重试，高并发时竞争更少。
+// ring满了，或者EnQueue多次失败(饥饿)时关闭ring，在后面连接一个新的ring，
+// 和LRQueue扩容一样，取完旧ring再取新ring。ring不会复用，由GC回收。
+//
+// 原算法slot需要双字cas(safe,idx,val)，这里slot储存指向不可修改的crqCell的指针，
+// 每次改变状态都cas成一个新的cell，效果和双字cas相同：
+// EnQueue一次cas写入值，DeQueue遇到还没写入的slot直接标记为空，不等待EnQueue。
+type LCRQueue[T any] struct {
+	once sync.Once
+
+	cap uint32 // 每个ring的容量，自动向上调整至2^n
+
+	// head指向DeQueue的ring,tail指向EnQueue的ring。
+	head unsafe.Pointer
+	tail unsafe.Pointer
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+}
+
+const (
+	crqUnsafe = 1 << 63 // cell不安全，更早的值还没取出时DeQueue已经经过，EnQueue要确认head<=t才能使用
+	crqFull   = 1 << 62 // cell储存了ID为idx的值
+	crqIdx    = crqFull - 1
+
+	crqClosed = 1 << 63 // tail的关闭标记
+
+	// EnQueue失败超过crqStarving次，关闭ring。
+	crqStarving = 1 << 6
+)
+
+// crqCell slot的状态，cas进slot之后不再修改，读取不需要同步。
+// word：unsafe|full|idx，idx表示slot当前可以使用的ID。
+type crqCell[T any] struct {
+	word uint64
+	val  T
+}
+
+// crq 一个FAA环形数组。
+//
+// EnQueue在ID为t的slot: 空并且idx<=t时，cas成储存了值的cell{full|t}。
+// DeQueue在ID为h的slot: idx==h并且full时，cas成空的cell{h+cap}，取出值，留给下一圈；
+// slot空时，同样cas成空的cell{h+cap}，之后拿到t==h的EnQueue会失败，重新FAA；
+// idx<h的值属于更早的DeQueue，标记unsafe，EnQueue确认head<=t后才能使用。
+// Peek和遍历读取cell，cell不会修改，也不会复用。
+type crq[T any] struct {
+	// 放在第一个，保证32位平台原子操作8字节对齐。
+	head uint64
+	_    cacheLinePad
+	// 最高位为crqClosed
+	tail uint64
+	_    cacheLinePad
+
+	cap uint64
+	mod uint64
+
+	// slots 指向*crqCell[T]，nil表示第一圈还没使用，即空的cell{i}。
+	slots []unsafe.Pointer
+
+	// len 数量，同一次Init后的ring共用一个。
+	// Init丢弃的ring上还在进行的操作，只修改旧的len,不影响Size。
+	// EnQueue写入后才增加，DeQueue可能先减少，按int32读取。
+	len *uint32
+
+	// next 下一个ring,只能由nil变成非nil。
+	// 队列关闭时，最后一个ring的next变成closedNext。
+	next unsafe.Pointer
+}
+
+func newCRQ[T any](cap uint32, size *uint32) *crq[T] {
+	mod := uint64(modUint32(cap))
+	return &crq[T]{
+		len:   size,
+		cap:   mod + 1,
+		mod:   mod,
+		slots: make([]unsafe.Pointer, mod+1),
+	}
+}
+
+// newCRQWith 新的ring,第一个slot储存val。
+func newCRQWith[T any](cap uint32, size *uint32, val T) *crq[T] {
+	r := newCRQ[T](cap, size)
+	r.slots[0] = unsafe.Pointer(&crqCell[T]{word: crqFull, val: val})
+	r.tail = 1
+	return r
+}
+
+// loadCell 读取ID为id的slot，返回slot当前的cell和它的word。
+func (r *crq[T]) loadCell(id uint64) (p unsafe.Pointer, w uint64) {
+	i := id & r.mod
+	p = atomic.LoadPointer(&r.slots[i])
+	if p == nil {
+		return nil, i
+	}
+	return p, (*crqCell[T])(p).word
+}
+
+// loadNext 下一个ring,队列关闭后返回nil。
+func (r *crq[T]) loadNext() *crq[T] {
+	next := atomic.LoadPointer(&r.next)
+	if next == closedNext {
+		return nil
+	}
+	return (*crq[T])(next)
+}
+
+// close 关闭ring，之后的EnQueue都失败。
+func (r *crq[T]) close() {
+	for {
+		t := atomic.LoadUint64(&r.tail)
+		if t&crqClosed != 0 || atomic.CompareAndSwapUint64(&r.tail, t, t|crqClosed) {
+			return
+		}
+	}
+}
+
+// drained ring已经关闭，并且head已经移过关闭前所有EnQueue拿到的ID。
+// FAA了更小ID的DeQueue会取出或者作废对应的slot，所以之后不会再有值可以取出。
+func (r *crq[T]) drained() bool {
+	t := atomic.LoadUint64(&r.tail)
+	return t&crqClosed != 0 && atomic.LoadUint64(&r.head) >= t&^crqClosed
+}
+
+// put 在ID为t的slot写入c，c还没有发布，可以修改。
+// slot储存着上一圈的值，或者DeQueue已经经过，返回false。
+func (r *crq[T]) put(t uint64, c *crqCell[T]) bool {
+	p, w := r.loadCell(t)
+	if w&crqFull != 0 || w&crqIdx > t || (w&crqUnsafe != 0 && atomic.LoadUint64(&r.head) > t) {
+		return false
+	}
+	// 写入后清除unsafe
+	c.word = crqFull | t
+	return cas(&r.slots[t&r.mod], p, unsafe.Pointer(c))
+}
+
+func (r *crq[T]) enQueue(val T) bool {
+	c := &crqCell[T]{val: val}
+	for tries := 0; ; tries++ {
+		t := atomic.AddUint64(&r.tail, 1) - 1
+		if t&crqClosed != 0 {
+			return false
+		}
+		if r.put(t, c) {
+			return true
+		}
+		h := atomic.LoadUint64(&r.head)
+		if int64(t-h) >= int64(r.cap) || tries >= crqStarving {
+			// ring满了，或者一直被DeQueue抢先，关闭ring
+			r.close()
+			return false
+		}
+	}
+}
+
+// enQueueMany 一次FAA预留连续的ID，按顺序写入vals，遇到不能写入的slot停止，
+// 返回写入的数量，剩下的ID由DeQueue作废。
+func (r *crq[T]) enQueueMany(vals []T) int {
+	n := uint64(len(vals))
+	if n > r.cap {
+		n = r.cap
+	}
+	t := atomic.AddUint64(&r.tail, n) - n
+	if t&crqClosed != 0 {
+		return 0
+	}
+	for i := uint64(0); i < n; i++ {
+		if !r.put(t+i, &crqCell[T]{val: vals[i]}) {
+			return int(i)
+		}
+	}
+	return int(n)
+}
+
+// take 处理ID为h的slot，返回储存的值。
+// 拿到h的DeQueue只有一个，返回前slot一定已经移到下一圈，或者标记了unsafe，
+// 之后拿到t==h的EnQueue都会失败。
+func (r *crq[T]) take(h uint64) (val T, ok bool) {
+	slot := &r.slots[h&r.mod]
+	for {
+		p, w := r.loadCell(h)
+		idx := w & crqIdx
+		if idx > h {
+			return
+		}
+		if w&crqFull != 0 {
+			c := (*crqCell[T])(p)
+			if idx == h {
+				if cas(slot, p, unsafe.Pointer(&crqCell[T]{word: w&crqUnsafe | (h + r.cap)})) {
+					return c.val, true
+				}
+				continue
+			}
+			// 更早的值还没取出，标记unsafe
+			if w&crqUnsafe != 0 || cas(slot, p, unsafe.Pointer(&crqCell[T]{word: w | crqUnsafe, val: c.val})) {
+				return
+			}
+		} else if cas(slot, p, unsafe.Pointer(&crqCell[T]{word: w&crqUnsafe | (h + r.cap)})) {
+			// slot空，跳过这一圈，还没写入的EnQueue会失败
+			return
+		}
+	}
+}
+
+func (r *crq[T]) deQueue() (val T, ok bool) {
+	for {
+		h := atomic.AddUint64(&r.head, 1) - 1
+		if val, ok = r.take(h); ok {
+			return
+		}
+		t := atomic.LoadUint64(&r.tail) &^ crqClosed
+		if t <= h+1 {
+			// ring空
+			r.fixState()
+			return
+		}
+	}
+}
+
+// deQueueMany 一次FAA取得最多len(dst)个连续的ID，按顺序取出，返回取出的数量。
+// 只FAA tail之前的ID，取得的ID都作废时和deQueue一样重试，ring空时返回0。
+func (r *crq[T]) deQueueMany(dst []T) int {
+	for {
+		h := atomic.LoadUint64(&r.head)
+		t := atomic.LoadUint64(&r.tail) &^ crqClosed
+		if t <= h {
+			// ring空
+			r.fixState()
+			return 0
+		}
+		n := uint64(len(dst))
+		if n > t-h {
+			n = t - h
+		}
+		h = atomic.AddUint64(&r.head, n) - n
+		var m int
+		for i := uint64(0); i < n; i++ {
+			if val, ok := r.take(h + i); ok {
+				dst[m] = val
+				m++
+			}
+		}
+		if atomic.LoadUint64(&r.tail)&^crqClosed <= h+n {
+			r.fixState()
+		}
+		if m > 0 {
+			return m
+		}
+	}
+}
+
+// peek 返回ring中从head开始第一个储存了值的slot，不取出，也不占用slot。
+func (r *crq[T]) peek() (val T, ok bool) {
+	for h := atomic.LoadUint64(&r.head); h < atomic.LoadUint64(&r.tail)&^crqClosed; h++ {
+		if val, ok = r.read(h); ok {
+			return
+		}
+	}
+	return
+}
+
+// read 读取ID为h的值，cell不会修改，读到的就是某一时刻slot中的值。
+func (r *crq[T]) read(h uint64) (val T, ok bool) {
+	p, w := r.loadCell(h)
+	if w&^crqUnsafe != crqFull|h {
+		return
+	}
+	return (*crqCell[T])(p).val, true
+}
+
+// walk 按ID顺序读取[from,end)中还没被DeQueue FAA、储存了值的slot，f返回false时停止并返回false。
+// 每读一个slot都重新读取head，跳过已经FAA的ID；没有值的slot也跳过，不等待EnQueue。
+func (r *crq[T]) walk(from, end uint64, f func(v T) bool) bool {
+	for h := from; h < end; h++ {
+		if head := atomic.LoadUint64(&r.head); head > h {
+			// 已经被DeQueue取走或者跳过，跳到head
+			h = head - 1
+			continue
+		}
+		if val, ok := r.read(h); ok && !f(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// fixState DeQueue让head超过tail时，将tail提升到head。
+func (r *crq[T]) fixState() {
+	for {
+		h := atomic.LoadUint64(&r.head)
+		t := atomic.LoadUint64(&r.tail)
+		if atomic.LoadUint64(&r.tail) != t {
+			continue
+		}
+		// 已经关闭的tail很大，直接返回
+		if h <= t || atomic.CompareAndSwapUint64(&r.tail, t, h) {
+			return
+		}
+	}
+}
+
+func (q *LCRQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init()
+	})
+}
+
+func (q *LCRQueue[T]) init() {
+	if q.cap < 1 {
+		q.cap = DefauleSize
+	}
+	if q.cap > queueLimit {
+		q.cap = queueLimit
+	}
+	r := unsafe.Pointer(newCRQ[T](q.cap, new(uint32)))
+	q.head = r
+	q.tail = r
+}
+
+func (q *LCRQueue[T]) loadHead() *crq[T] {
+	return (*crq[T])(atomic.LoadPointer(&q.head))
+}
+
+func (q *LCRQueue[T]) loadTail() *crq[T] {
+	return (*crq[T])(atomic.LoadPointer(&q.tail))
+}
+
+// Init 清空队列
+func (q *LCRQueue[T]) Init() {
+	q.InitWith()
+}
+
+// InitWith 清空队列，之后新的ring容量为cap,
+// 如果未提供，则使用原来的设置，默认值: DefauleSize.
+func (q *LCRQueue[T]) InitWith(caps ...int) {
+	q.onceInit()
+	newCap := atomic.LoadUint32(&q.cap)
+	if len(caps) > 0 && caps[0] > 0 {
+		newCap = uint32(caps[0])
+		if newCap > queueLimit {
+			newCap = queueLimit
+		}
+		atomic.StoreUint32(&q.cap, newCap)
+	}
+	ring := newCRQ[T](newCap, new(uint32))
+	// 关闭最后一个ring并连接上新ring,让运行中的EnQueue转到新ring，
+	// 再让head指向新ring，丢弃旧的ring。
+	for {
+		tail := q.loadTail()
+		tail.close()
+		if cas(&tail.next, nil, unsafe.Pointer(ring)) {
+			atomic.StorePointer(&q.head, unsafe.Pointer(ring))
+			cas(&q.tail, unsafe.Pointer(tail), unsafe.Pointer(ring))
+			return
+		}
+		next := atomic.LoadPointer(&tail.next)
+		if next == closedNext {
+			// 队列已经关闭，新ring同样关闭，队列保持关闭状态。
+			ring.close()
+			ring.next = closedNext
+			atomic.StorePointer(&q.head, unsafe.Pointer(ring))
+			atomic.StorePointer(&q.tail, unsafe.Pointer(ring))
+			return
+		}
+		cas(&q.tail, unsafe.Pointer(tail), next)
+	}
+}
+
+func (q *LCRQueue[T]) EnQueue(val T) bool {
+	q.onceInit()
+	for {
+		tail := q.loadTail()
+		next := atomic.LoadPointer(&tail.next)
+		if next == closedNext {
+			// 队列已经关闭。tail.enQueue可能FAA移动了已经关闭的tail，
+			// 唤醒DeQueueCtx，重新检查drained。
+			q.notEmpty.signal()
+			return false
+		}
+		if next != nil {
+			// ring已经关闭，提升tail到新ring
+			cas(&q.tail, unsafe.Pointer(tail), next)
+			continue
+		}
+		if tail.enQueue(val) {
+			atomic.AddUint32(tail.len, 1)
+			q.notEmpty.signal()
+			return true
+		}
+		// ring已经关闭，连接一个储存了val的新ring
+		ring := newCRQWith[T](atomic.LoadUint32(&q.cap), tail.len, val)
+		if cas(&tail.next, nil, unsafe.Pointer(ring)) {
+			cas(&q.tail, unsafe.Pointer(tail), unsafe.Pointer(ring))
+			atomic.AddUint32(tail.len, 1)
+			q.notEmpty.signal()
+			return true
+		}
+	}
+}
+
+func (q *LCRQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	var head *crq[T]
+	for {
+		head = q.loadHead()
+		if val, ok = head.deQueue(); ok {
+			break
+		}
+		next := head.loadNext()
+		if next == nil {
+			// queue empty,
+			return
+		}
+		// 有下一个ring时，head已经关闭，
+		// 再取一次，确认关闭前写入的值都已经取出，才能转到下一个ring。
+		if val, ok = head.deQueue(); ok {
+			break
+		}
+		cas(&q.head, unsafe.Pointer(head), unsafe.Pointer(next))
+	}
+	atomic.AddUint32(head.len, negativeOne)
+	return
+}
+
+// Peek 返回队头的值，不取出。
+//
+// 依次查看每个ring中从head开始储存了值的slot，不占用slot，不会让DeQueue等待。
+// 返回的值在读取时还没有取出，但并发时不一定是下一个DeQueue取出的值：
+// 已经FAA的EnQueue可能在更早的ID上写入，FAA了这个ID的DeQueue也可能马上取走它。
+func (q *LCRQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	for r := q.loadHead(); r != nil; r = r.loadNext() {
+		if val, ok = r.peek(); ok {
+			return
+		}
+	}
+	return
+}
+
+// EnQueueMany 在tail ring上一次FAA预留len(vals)个ID，按顺序写入。
+// 不能写入的部分交给EnQueue，由它关闭ring并连接新ring，再继续批量写入。
+func (q *LCRQueue[T]) EnQueueMany(vals []T) (n int) {
+	q.onceInit()
+	for n < len(vals) {
+		tail := q.loadTail()
+		if atomic.LoadPointer(&tail.next) == nil {
+			if m := tail.enQueueMany(vals[n:]); m > 0 {
+				atomic.AddUint32(tail.len, uint32(m))
+				q.notEmpty.signal()
+				n += m
+				continue
+			}
+		}
+		if !q.EnQueue(vals[n]) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// DeQueueMany 在head ring上一次FAA取得多个ID，按顺序取出，
+// 和DeQueue一样，head ring关闭并且取完后转到下一个ring。
+func (q *LCRQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	for n < len(dst) {
+		head := q.loadHead()
+		m := head.deQueueMany(dst[n:])
+		if m == 0 {
+			next := head.loadNext()
+			if next == nil {
+				// queue empty,
+				break
+			}
+			// 有下一个ring时，head已经关闭，再取一次才能转到下一个ring。
+			if m = head.deQueueMany(dst[n:]); m == 0 {
+				cas(&q.head, unsafe.Pointer(head), unsafe.Pointer(next))
+				continue
+			}
+		}
+		atomic.AddUint32(head.len, ^uint32(m-1))
+		n += m
+	}
+	return n
+}
+
+func (q *LCRQueue[T]) Cap() int {
+	return queueLimit
+}
+
+func (q *LCRQueue[T]) Full() bool {
+	return false
+}
+
+// 数量，并发时只是近似值。
+func (q *LCRQueue[T]) Size() int {
+	q.onceInit()
+	n := int32(atomic.LoadUint32(q.loadHead().len))
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+func (q *LCRQueue[T]) Empty() bool {
+	return q.Size() == 0
+}
+
+// snapshot 收集弱一致遍历的值，见LCRQueue.Range。
+func (q *LCRQueue[T]) snapshot() []T {
+	vals := make([]T, 0, q.Size())
+	q.Range(func(v T) bool {
+		vals = append(vals, v)
+		return true
+	})
+	return vals
+}
+
+// walk 从head开始，依次按ID顺序读取每个ring的值，f返回false时停止。
+//
+// tail在开始读取ring时确定，读完后如果已经连接了下一个ring，
+// 旧ring已经关闭，再读取期间新写入的值，
+// 否则可能漏掉旧ring的值，却读到之后写入新ring的值。
+func (q *LCRQueue[T]) walk(f func(v T) bool) {
+	for r := q.loadHead(); r != nil; {
+		from, end := atomic.LoadUint64(&r.head), atomic.LoadUint64(&r.tail)&^crqClosed
+		if !r.walk(from, end, f) {
+			return
+		}
+		next := r.loadNext()
+		if next == nil {
+			return
+		}
+		// 关闭后tail之后的ID不会再写入
+		if !r.walk(end, atomic.LoadUint64(&r.tail)&^crqClosed, f) {
+			return
+		}
+		r = next
+	}
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+//
+// 关闭最后一个ring，并将它的next换成closedNext，之后不能再连接新ring。
+func (q *LCRQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(q.seal) {
+		q.notEmpty.signal()
+	}
+}
+
+// seal 关闭最后一个ring，并将它的next换成closedNext。
+func (q *LCRQueue[T]) seal() {
+	for {
+		tail := q.loadTail()
+		tail.close()
+		if cas(&tail.next, nil, closedNext) {
+			break
+		}
+		next := atomic.LoadPointer(&tail.next)
+		if next == closedNext {
+			break
+		}
+		cas(&q.tail, unsafe.Pointer(tail), next)
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *LCRQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+// drained 关闭后所有ring都已经取完，按ring的head,tail判断，见crq.drained。
+// 不能用Size：EnQueue写入之后才增加len，关闭前开始的EnQueue可能还在写入。
+func (q *LCRQueue[T]) drained() bool {
+	if !q.cl.isSealed() {
+		return false
+	}
+	for r := q.loadHead(); r != nil; r = r.loadNext() {
+		if !r.drained() {
+			return false
+		}
+	}
+	return true
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *LCRQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
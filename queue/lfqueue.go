@@ -3,31 +3,31 @@
 package queue
 
 import (
-	"errors"
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
+
+	"github.com/min1324/data/backoff"
+	"github.com/min1324/data/internal/reclaim"
 )
 
 // LLQueue is a lock-free unbounded linked list queue.
-type LLQueue struct {
+type LLQueue[T any] struct {
 	// 声明队列后，如果没有调用Init(),队列不能使用
 	// 为解决这个问题，加入一次性操作。
 	// 能在队列声明后，调用EnQueue或者DeQueue操作前，初始化队列。
 	// 见 onceInit()
 	once sync.Once
 
-	// len is num of value store in queue
-	len uint32
-
 	// head指向第一个取数据的node。tail可能指向队尾元素。
 	//
 	// 出队操作，先检测head==tail判断队列是否空。
 	// slot指向head先标记需要出队的数据。
 	// 如果slot的val是空，表明队列空。
 	// 然后通过cas将head指针指向slot.next以移除slot。
-	// 保存slot的val,释放slot,并返回val。
+	// 保存slot的val,退休slot,并返回val。
 	//
 	// 入队操作，由于是链表队列，大小无限制，
 	// 队列无满条件或者直到用完内存。不用判断是否满。
@@ -38,348 +38,660 @@ type LLQueue struct {
 	// 将val存在slot里面,DeQueue可以取出了。
 	//
 	// head只能在DeQueue里面修改，tail只能在Enqueue修改。
-	head unsafe.Pointer
-	tail unsafe.Pointer
+	//
+	// 数量分成入队数量enLen和出队数量deLen，Size=enLen-deLen。
+	// EnQueue只写tail,enLen，DeQueue只写head,deLen，
+	// 两组字段用cacheLinePad隔开，生产者和消费者不会互相让对方的缓存行失效。
+	_     cacheLinePad
+	head  unsafe.Pointer
+	deLen uint32 // DeQueue,Init移出的数量
+	_     cacheLinePad
+	tail  unsafe.Pointer
+	enLen uint32 // 加入链表的数量
+	_     cacheLinePad
+
+	// 访问head,tail指向的node前，先用危险指针保护。
+	// 出队的node退休后，等到没有goroutine保护时，
+	// 清空并放入pool，供EnQueue复用。见 hazard 包
+	//
+	// ReclaimEpoch方式则每次操作Pin一次，
+	// 回收的node放入空闲链表free。见 epoch 包
+	rc   reclaim.Reclaimer
+	pool sync.Pool
+	free reclaim.FreeList[ptrNode[T], *ptrNode[T]]
+
+	// bo cas失败后的退避策略，nil时立即重试，见NewLLQueueWith
+	bo backoff.Backoff
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
 }
 
 // 一次性初始化,线程安全。
-func (q *LLQueue) onceInit() {
+func (q *LLQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *LLQueue) init() {
-	q.head = unsafe.Pointer(newPrtNode(nil))
+func (q *LLQueue[T]) init() {
+	q.head = unsafe.Pointer(newPrtNode[T]())
 	q.tail = q.head
-	q.len = 0
+	q.rc.Init(q.reclaim)
 }
 
-func (q *LLQueue) Init() {
+// newNode 优先复用已回收的node，需要在pin期间调用。
+func (q *LLQueue[T]) newNode() *ptrNode[T] {
+	if q.rc.Mode == ReclaimEpoch {
+		if n := q.free.Pop(); n != nil {
+			return n
+		}
+	} else if n, ok := q.pool.Get().(*ptrNode[T]); ok {
+		return n
+	}
+	return newPrtNode[T]()
+}
+
+// reclaim 回收没有goroutine持有的node
+func (q *LLQueue[T]) reclaim(p unsafe.Pointer) {
+	n := (*ptrNode[T])(p)
+	n.free()
+	if q.rc.Mode == ReclaimEpoch {
+		q.free.Push(n)
+	} else {
+		q.pool.Put(n)
+	}
+}
+
+func (q *LLQueue[T]) Init() {
 	q.onceInit()
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
 	for {
-		head := q.head
-		tail := q.tail
+		head := q.rc.Protect(g, 0, &q.head)
+		tail := atomic.LoadPointer(&q.tail)
 		if head == tail {
 			return //  空队列不需要初始化
 		}
-		oldLen := atomic.LoadUint32(&q.len)
 		if cas(&q.head, head, tail) {
-			// cas成功，表明期间并无其他操作，队列已经空了，但是len还没置0
-			// 有可能并发EnQueue,增加len；即使有并发DeQueue,也能保持newLen>=oldLen
-			// 需要用新len-旧len。负数为：反码+1。
-			atomic.AddUint32(&q.len, (^oldLen + 1))
-			for head != tail && head != nil {
-				freeNode := (*ptrNode)(head)
-				head = freeNode.next
-				freeNode.free()
+			// cas成功，head到tail之间的node已经移出队列，但是deLen还没增加。
+			// 并发EnQueue在node加入链表后才增加enLen，
+			// 所以按移出的node数量增加deLen，而不是直接让两者相等。
+			var n uint32
+			for p := head; p != tail && p != nil; p = loadNext[T](p) {
+				n++
 			}
+			atomic.AddUint32(&q.deLen, n)
+			// 移出的node只有当前goroutine能退休。
+			q.rc.RetireChain(g, head, tail, loadNext[T])
 			return
 		}
 	}
 }
 
-func (q *LLQueue) EnQueue(val interface{}) bool {
+func (q *LLQueue[T]) EnQueue(val T) bool {
+	q.onceInit()
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
+
+	nilNode := unsafe.Pointer(q.newNode())
+	slot := q.link(g, nilNode, nilNode, 1)
+	if slot == nil {
+		// 队列已经关闭。node来自空闲链表时，其他Pop可能还读着它，
+		// 不能直接放回，和出队的node一样退休，经过宽限期再复用。
+		q.rc.Retire(g, nilNode)
+		return false
+	}
+
+	// 将val储存到slot，让DeQueue可以取走
+	// slot依旧被保护，不会在储存前被回收。
+	slot.store(val)
+	q.notEmpty.signal()
+	return true
+}
+
+// EnQueueMany 先在本地连好一串node，再用一次cas接到队尾。
+func (q *LLQueue[T]) EnQueueMany(vals []T) (n int) {
+	if len(vals) == 0 {
+		return
+	}
 	q.onceInit()
-	if val == nil {
-		val = empty
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
+
+	// vals[0]存入当前tail，其余的存入新node，最后一个新node作为新的tail。
+	first := q.newNode()
+	last := first
+	for _, v := range vals[1:] {
+		last.store(v)
+		next := q.newNode()
+		atomic.StorePointer(&last.next, unsafe.Pointer(next))
+		last = next
+	}
+	slot := q.link(g, unsafe.Pointer(first), unsafe.Pointer(last), uint32(len(vals)))
+	if slot == nil {
+		// 队列已经关闭，node没有加入队列，同EnQueue一样退休，不能直接放回空闲链表。
+		q.rc.RetireChain(g, unsafe.Pointer(first), nil, loadNext[T])
+		return 0
 	}
-	var slot *ptrNode
-	nilNode := unsafe.Pointer(newPrtNode(nil))
+	slot.store(vals[0])
+	q.notEmpty.signal()
+	return len(vals)
+}
+
+// link 将first->...->last接到最后一个node后面，返回原来最后一个node,即储存的slot。
+// first到last之间已经存入n-1个val,last是空node。
+// 队列已经关闭返回nil。
+func (q *LLQueue[T]) link(g reclaim.Guard, first, last unsafe.Pointer, n uint32) *ptrNode[T] {
+	var slot *ptrNode[T]
 	// 获取储存的slot
-	for {
-		tail := atomic.LoadPointer(&q.tail)
-		slot = (*ptrNode)(tail)
-		next := slot.next
+	for i := 0; ; i++ {
+		// 保护tail，保证slot在使用期间不会被回收复用
+		tail := q.rc.Protect(g, 0, &q.tail)
+		slot = (*ptrNode[T])(tail)
+		next := atomic.LoadPointer(&slot.next)
 		if tail != atomic.LoadPointer(&q.tail) {
 			continue
 		}
+		if next == closedNext {
+			return nil
+		}
 		// 提升tail直到指向最后一个node
 		if next != nil {
 			cas(&q.tail, tail, next)
 			continue
 		}
-		v := slot.load()
-		if v != nil {
+		if slot.stored() {
 			continue
 		}
 
 		// next==nil,确定slot是最后一个node
-		if cas(&slot.next, nil, nilNode) {
+		if cas(&slot.next, nil, first) {
 			// 获得储存的slot，尝试将tail提升到最后一个node。
-			cas(&q.tail, tail, slot.next)
-			atomic.AddUint32(&q.len, 1)
-			break
+			cas(&q.tail, tail, last)
+			atomic.AddUint32(&q.enLen, n)
+			return slot
 		}
+		backoff.Wait(q.bo, i)
 	}
+}
 
-	// 将val储存到slot，让DeQueue可以取走
-	slot.store(val)
-	return true
+func (q *LLQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
+	return q.deQueue(g)
 }
 
-func (q *LLQueue) DeQueue() (val interface{}, ok bool) {
+// DeQueueMany 只Pin一次，逐个取出。
+func (q *LLQueue[T]) DeQueueMany(dst []T) (n int) {
 	q.onceInit()
 	if q.Empty() {
 		return
 	}
-	var slot *ptrNode
-	// 获取slot
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
+	for ; n < len(dst); n++ {
+		val, ok := q.deQueue(g)
+		if !ok {
+			break
+		}
+		dst[n] = val
+	}
+	return n
+}
+
+// Peek 返回队头的值，不取出。
+//
+// 保护head后读取它的值，再确认head没有变化：读取时它就是队头，
+// head储存的值在退休前不会改变，所以返回的是那一刻DeQueue会取出的值。
+// 和DeQueue一样，队头的EnQueue还没储存完成时返回false。
+func (q *LLQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	g := q.rc.Pin()
+	defer q.rc.Unpin(g)
 	for {
-		head := atomic.LoadPointer(&q.head)
+		head := q.rc.Protect(g, 0, &q.head)
+		val, ok = (*ptrNode[T])(head).load()
+		if head == atomic.LoadPointer(&q.head) {
+			return
+		}
+	}
+}
+
+func (q *LLQueue[T]) deQueue(g reclaim.Guard) (val T, ok bool) {
+	var slot *ptrNode[T]
+	// 获取slot
+	for n := 0; ; n++ {
+		// 保护head，head被保护期间不会被回收复用，cas不会出现ABA问题。
+		head := q.rc.Protect(g, 0, &q.head)
 		tail := atomic.LoadPointer(&q.tail)
-		slot = (*ptrNode)(head)
+		slot = (*ptrNode[T])(head)
+		next := atomic.LoadPointer(&slot.next)
 		if head != atomic.LoadPointer(&q.head) {
 			continue
 		}
 		if head == tail {
-			// 即便tail落后了，也不提升。
-			// tail只能在EnQueue里改变
-			// return nil, false
-
 			// 尝试提升tail,
-			if slot.next == nil {
-				return nil, false
+			// tail==head已经被保护，cas tail也不会出现ABA问题。
+			if next == nil || next == closedNext {
+				return
 			}
-			cas(&q.tail, tail, slot.next)
+			cas(&q.tail, tail, next)
 			continue
 		}
 		// 先记录slot，然后尝试取出
-		val = slot.load()
-		if val == nil {
+		v, stored := slot.load()
+		if !stored {
 			// Enqueue还没添加完成，直接退出.如需等待，用continue
-			return nil, false
+			return
 		}
-		if cas(&q.head, head, slot.next) {
+		if cas(&q.head, head, next) {
 			// 成功取出slot
+			val = v
 			break
 		}
+		backoff.Wait(q.bo, n)
 	}
-	if val == empty {
-		val = nil
-	}
-	atomic.AddUint32(&q.len, negativeOne)
+	atomic.AddUint32(&q.deLen, 1)
 
-	// 释放slot
-	// BUG memory reclamation
+	// 退休slot
 	//
-	// 队列假设有node1->node2->node3
+	// 不能直接释放slot，假设队列有node1->node2->node3
 	// 进程1：队头head=node1.准备cas(&q.head, head, slot.next)
 	// 进程2：刚好pop完node1,2,3，并且释放，队列空了。
-	// 然后进程3：刚好new(node1，node3,node2),push(node1,node3,node2)进队，
+	// 然后进程3：刚好复用(node1，node3,node2),push(node1,node3,node2)进队，
 	// 此时队列有node1->node3,node2
 	// 回到进程1进行cas，head还是指向node1,成功，但是next并不是预期的node2。
 	//
-	// slot.free()
+	// 进程1已经用危险指针保护了node1(或者已经Pin)，node1只能退休，
+	// 直到进程1操作完成，才会被回收复用。
+	q.rc.Retire(g, unsafe.Pointer(slot))
 	return val, true
 }
 
-func (q *LLQueue) Cap() int {
+func (q *LLQueue[T]) Cap() int {
 	return queueLimit
 }
 
-func (q *LLQueue) Full() bool {
+func (q *LLQueue[T]) Full() bool {
 	return false
 }
 
-func (q *LLQueue) Empty() bool {
-	return q.head == q.tail
+func (q *LLQueue[T]) Empty() bool {
+	return atomic.LoadPointer(&q.head) == atomic.LoadPointer(&q.tail)
 }
 
-func (q *LLQueue) Size() int {
-	return int(atomic.LoadUint32(&q.len))
+// Size 入队数量减去出队数量。
+// node先加入链表再增加enLen，可能先被取出增加了deLen，这时暂时按0计算。
+func (q *LLQueue[T]) Size() int {
+	// 先读deLen，减少enLen-deLen为负的情况
+	deLen := atomic.LoadUint32(&q.deLen)
+	if n := int32(atomic.LoadUint32(&q.enLen) - deLen); n > 0 {
+		return int(n)
+	}
+	return 0
 }
 
 // lock-free queue implement with array
 //
 // LRQueue is a lock-free ring array queue.
-type LRQueue struct {
+//
+// 设置了大于cap的最大容量时，ring满了会在线扩容：
+// 关闭当前ring，在后面连接一个两倍大小的ring,EnQueue转到新ring，
+// DeQueue取完旧ring后转到新ring。扩容期间EnQueue,DeQueue依旧lock-free。
+// 旧ring的空位不能再EnQueue,所以Size可能暂时超过max,
+// 最后一个ring满了之后，要等旧ring取完并且最后一个ring有空位才能EnQueue。
+type LRQueue[T any] struct {
 	once sync.Once
 
-	cap  uint32 // 队列容量，自动向上调整至2^n
-	mod  uint32 // cap-1,即2^n-1,用作取slot: data[ID&mod]
-	deID uint32 // 指向下次取出数据的位置:deID&mod
-	enID uint32 // 指向下次写入数据的位置:enID&mod
+	cap uint32 // 初始容量，自动向上调整至2^n
+	max uint32 // 最大容量，大于cap时可以扩容
+
+	// bo ring中cas失败后的退避策略，nil时立即重试，见NewLRQueueWith
+	bo backoff.Backoff
+
+	// padded 不为0时，新建的ring每个slot独占缓存行，见PadSlots
+	padded uint32
+
+	// head指向DeQueue的ring,tail指向EnQueue的ring。
+	// 没有扩容时，head==tail。
+	head unsafe.Pointer
+	tail unsafe.Pointer
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+	// 队列满时EnQueueCtx在notFull等待
+	notFull notifier
 
-	// 环形队列，大小必须是2的倍数。
-	// val为空，表示可以EnQUeue,如果是DeQueue操作，表示队列空。
-	// val不为空，表所可以DeQueue,如果是EnQUeue操作，表示队列满了。
-	// 并且只能由EnQUeue将val从nil变成非nil,
-	// 只能由DeQueue将val从非niu变成nil.
-	data []baseNode
+	// of 队列满时EnQueue的处理方式，见SetOverflow
+	of overflow[T]
 }
 
 // 一次性初始化
-func (q *LRQueue) onceInit() {
+func (q *LRQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
 // 无并发初始化
-func (q *LRQueue) init() {
+func (q *LRQueue[T]) init() {
+	q.resize()
+	r := unsafe.Pointer(q.newRing(q.cap))
+	q.head = r
+	q.tail = r
+}
+
+// resize 调整cap,max至2^n
+func (q *LRQueue[T]) resize() {
 	if q.cap < 1 {
 		q.cap = DefauleSize
 	}
-	q.deID = q.enID
-	q.mod = modUint32(q.cap)
-	q.cap = q.mod + 1
-	q.data = make([]baseNode, q.cap)
+	if q.cap > queueLimit {
+		q.cap = queueLimit
+	}
+	q.cap = modUint32(q.cap) + 1
+	if q.max > queueLimit {
+		q.max = queueLimit
+	}
+	if q.max < q.cap {
+		q.max = q.cap
+	}
+	q.max = modUint32(q.max) + 1
+}
+
+func (q *LRQueue[T]) newRing(cap uint32) *lrRing[T] {
+	return newLRRing[T](cap, q.bo, atomic.LoadUint32(&q.padded) != 0)
+}
+
+// PadSlots 设置之后新建的ring是否让每个slot独占缓存行。
+//
+// 相邻的slot在同一个缓存行，EnQueue写入一个slot时，
+// 正在读取相邻slot的DeQueue的缓存行也会失效(伪共享)。
+// 隔开后生产者和消费者不再互相干扰，代价是每个slot占用一个缓存行的空间。
+// 已经创建的ring不受影响，通常在InitWith之前调用：
+//
+//	var q LRQueue[int]
+//	q.PadSlots(true)
+//	q.InitWith(1 << 10)
+func (q *LRQueue[T]) PadSlots(pad bool) {
+	var v uint32
+	if pad {
+		v = 1
+	}
+	atomic.StoreUint32(&q.padded, v)
 }
 
 // Init初始化长度为: DefauleSize.
-func (q *LRQueue) Init() {
+func (q *LRQueue[T]) Init() {
 	q.InitWith()
 }
 
 // InitWith初始化长度为cap的queue,
 // 如果未提供，则使用默认值: DefauleSize.
-func (q *LRQueue) InitWith(caps ...int) {
-	q.onceInit()
-	var oldCap = atomic.LoadUint32(&q.cap)
-	var newCap = oldCap
+// caps[1]为最大容量，大于cap时，队列满了自动扩容，最大扩容至max。
+// 未提供时保留原来的设置。
+func (q *LRQueue[T]) InitWith(caps ...int) {
+	var newCap, newMax uint32
 	if len(caps) > 0 && caps[0] > 0 {
 		newCap = uint32(caps[0])
 	}
-	newMod := modUint32(newCap)
-	newCap = newMod + 1
+	if len(caps) > 1 && caps[1] > 0 {
+		newMax = uint32(caps[1])
+	}
+	var inited bool
+	q.once.Do(func() {
+		q.cap, q.max = newCap, newMax
+		q.init()
+		inited = true
+	})
+	if inited {
+		return
+	}
+	if newCap == 0 {
+		newCap = atomic.LoadUint32(&q.cap)
+	}
+	if newMax == 0 {
+		newMax = atomic.LoadUint32(&q.max)
+	}
+	var p LRQueue[T]
+	p.cap, p.max = newCap, newMax
+	p.resize()
+	atomic.StoreUint32(&q.cap, p.cap)
+	atomic.StoreUint32(&q.max, p.max)
+	q.reset(q.newRing(p.cap))
+	q.notFull.signal()
+}
+
+// InitAt 清空队列，enID,deID从id开始，容量不变。
+// ID是uint32，约40亿次操作后回绕，回绕前后行为相同；
+// InitAt从回绕点附近开始，用来验证长时间运行后的状态。不能和其他操作并发调用。
+func (q *LRQueue[T]) InitAt(id uint32) {
+	q.onceInit()
+	r := q.newRing(atomic.LoadUint32(&q.cap))
+	r.moveTo(id)
+	q.reset(r)
+	q.notFull.signal()
+}
+
+// reset 用ring替换队列中所有的ring。
+// 先关闭最后一个ring并连接上新ring,让运行中的EnQueue转到新ring，
+// 再让head指向新ring，丢弃旧的ring。
+func (q *LRQueue[T]) reset(ring *lrRing[T]) {
 	for {
-		cap := atomic.LoadUint32(&q.cap)
-		if cap == 0 {
-			// 其他InitWith正在执行，等待完成
+		tail := (*lrRing[T])(atomic.LoadPointer(&q.tail))
+		tail.close()
+		if cas(&tail.next, nil, unsafe.Pointer(ring)) {
+			atomic.StorePointer(&q.head, unsafe.Pointer(ring))
+			cas(&q.tail, unsafe.Pointer(tail), unsafe.Pointer(ring))
 			return
 		}
-		if casUint32(&q.cap, cap, 0) {
-			// 获得初始化权限
-			oldCap = cap
-			break
+		next := atomic.LoadPointer(&tail.next)
+		if next == closedNext {
+			// 队列已经关闭，新ring同样关闭，队列保持关闭状态。
+			ring.close()
+			ring.next = closedNext
+			atomic.StorePointer(&q.head, unsafe.Pointer(ring))
+			atomic.StorePointer(&q.tail, unsafe.Pointer(ring))
+			return
 		}
+		cas(&q.tail, unsafe.Pointer(tail), next)
 	}
-	// 让运行中的push,pop停止。
-	for {
-		enID := atomic.LoadUint32(&q.enID)
-		q.deID = q.enID
-		if casUint32(&q.enID, enID, enID+1) {
-			q.deID = q.enID
-			break
+}
+
+// grow 关闭满了的ring,并在后面连接一个两倍大小的ring。
+// ring已经达到最大容量，返回false。
+func (q *LRQueue[T]) grow(r *lrRing[T]) bool {
+	newCap := r.cap
+	if !r.closed() {
+		max := atomic.LoadUint32(&q.max)
+		if r.cap >= max {
+			// 不能再扩容
+			return false
 		}
+		newCap = r.cap << 1
+		if newCap > max {
+			newCap = max
+		}
+		// 先关闭，再连接新ring，
+		// 关闭后旧ring不会再写入，新ring的值都在旧ring的值之后。
+		r.close()
 	}
-	// 初始化,保证getSlot不panic
-	if oldCap > newCap {
-		q.mod = newMod
-		q.data = make([]baseNode, newCap)
-	} else {
-		q.data = make([]baseNode, newCap)
-		q.mod = newMod
+	// ring已经关闭，可能是其他EnQueue扩容或者InitWith,
+	// 帮助连接新ring，保持lock-free。
+	cas(&r.next, nil, unsafe.Pointer(q.newRing(newCap)))
+	next := atomic.LoadPointer(&r.next)
+	if next == closedNext {
+		// 队列已经关闭
+		return false
 	}
-	q.cap = newCap
+	cas(&q.tail, unsafe.Pointer(r), next)
+	return true
 }
 
-// 数量
-func (q *LRQueue) Size() int {
-	return int(q.enID - q.deID)
+func (q *LRQueue[T]) loadHead() *lrRing[T] {
+	return (*lrRing[T])(atomic.LoadPointer(&q.head))
 }
 
-// 根据enID,deID获取进队，出队对应的slot
-func (q *LRQueue) getSlot(id uint32) node {
-	return &q.data[id&q.mod]
+func (q *LRQueue[T]) loadTail() *lrRing[T] {
+	return (*lrRing[T])(atomic.LoadPointer(&q.tail))
 }
 
-func (q *LRQueue) EnQueue(val interface{}) bool {
+// 数量
+func (q *LRQueue[T]) Size() int {
 	q.onceInit()
-	if q.Full() {
-		return false
-	}
-	if val == nil {
-		val = empty
+	var size int
+	for r := q.loadHead(); r != nil; r = r.loadNext() {
+		size += int(r.size())
 	}
+	return size
+}
+
+func (q *LRQueue[T]) enQueue(val T) bool {
+	q.onceInit()
 	for {
-		enID := atomic.LoadUint32(&q.enID)
-		if q.Full() {
-			return false
+		tail := q.loadTail()
+		if tail.enQueue(val) {
+			q.notEmpty.signal()
+			return true
 		}
-		slot := q.getSlot(enID)
-		if slot.load() != nil {
-			// TODO 是否需要写入缓冲区,或者扩容
+		if next := tail.loadNext(); next != nil {
+			// ring已经关闭，提升tail到新ring
+			cas(&q.tail, unsafe.Pointer(tail), unsafe.Pointer(next))
+			continue
+		}
+		if !q.grow(tail) {
 			// queue full,
 			return false
 		}
-		if casUint32(&q.enID, enID, enID+1) {
-			// 成功获得slot
-			slot.store(val)
+	}
+}
+
+// enQueueMany 检查连续的空slot，一次cas预留enID，再逐个写入。
+// 预留不完时扩容，剩下的写入新ring。
+func (q *LRQueue[T]) enQueueMany(vals []T) (n int) {
+	q.onceInit()
+	for n < len(vals) {
+		tail := q.loadTail()
+		if m := tail.enQueueMany(vals[n:]); m > 0 {
+			n += m
+			continue
+		}
+		if next := tail.loadNext(); next != nil {
+			cas(&q.tail, unsafe.Pointer(tail), unsafe.Pointer(next))
+			continue
+		}
+		if !q.grow(tail) {
 			break
 		}
 	}
-	return true
+	if n > 0 {
+		q.notEmpty.signal()
+	}
+	return n
 }
 
-func (q *LRQueue) DeQueue() (val interface{}, ok bool) {
+func (q *LRQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
-	if q.Empty() {
-		return
+	for {
+		head := q.loadHead()
+		if val, ok = head.deQueue(); ok {
+			q.notFull.signal()
+			return
+		}
+		// 旧ring取完了，才能转到下一个ring
+		next := head.loadNext()
+		if next == nil || !head.drained() {
+			// queue empty,
+			return
+		}
+		cas(&q.head, unsafe.Pointer(head), unsafe.Pointer(next))
 	}
+}
+
+// Peek 返回队头的值，不取出。
+//
+// 不占用slot，不会让DeQueue等待：读取队头的值后确认slot和deID都没有变化，见lrRing.peek。
+// 返回的值在确认时还在队头，和那一刻DeQueue会取出的值一样。
+func (q *LRQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
 	for {
-		// 获取最新 DeQueuePID,
-		deID := atomic.LoadUint32(&q.deID)
-		if q.Empty() {
+		head := q.loadHead()
+		if val, ok = head.peek(); ok {
 			return
 		}
-		slot := q.getSlot(deID)
-		val = slot.load()
-		if val == nil {
+		next := head.loadNext()
+		if next == nil || !head.drained() {
 			// queue empty,
-			return nil, false
+			return
 		}
-		if casUint32(&q.deID, deID, deID+1) {
-			// 成功取出slot
-			if val == empty {
-				val = nil
-			}
-			slot.free()
+		cas(&q.head, unsafe.Pointer(head), unsafe.Pointer(next))
+	}
+}
+
+// DeQueueMany 读取连续已储存的slot，一次cas移动deID，再逐个清空。
+func (q *LRQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	for n < len(dst) {
+		head := q.loadHead()
+		if m := head.deQueueMany(dst[n:]); m > 0 {
+			n += m
+			continue
+		}
+		next := head.loadNext()
+		if next == nil || !head.drained() {
 			break
 		}
+		cas(&q.head, unsafe.Pointer(head), unsafe.Pointer(next))
 	}
-	return val, true
+	if n > 0 {
+		q.notFull.signal()
+	}
+	return n
 }
 
-// queue's cap
-func (q *LRQueue) Cap() int {
-	return int(q.cap)
+// queue's cap,当前EnQueue的ring的容量
+func (q *LRQueue[T]) Cap() int {
+	q.onceInit()
+	return int(q.loadTail().cap)
 }
 
-// 队列是否满
-func (q *LRQueue) Full() bool {
-	// InitWith时，将cap置为0.
-	return q.enID >= q.cap+q.deID
+// 队列是否满,扩容到最大容量才会满
+func (q *LRQueue[T]) Full() bool {
+	q.onceInit()
+	tail := q.loadTail()
+	return tail.full() && tail.cap >= atomic.LoadUint32(&q.max)
 }
 
 // 队列是否空
-func (q *LRQueue) Empty() bool {
-	return q.deID >= q.enID
-}
-
-var (
-	errTimeOut = errors.New("超时")
-)
-
-// 带超时EnQueue入队。
-func (q *LRQueue) PutWait(i interface{}, timeout time.Duration) (bool, error) {
-	t := time.NewTicker(timeout)
-	defer t.Stop()
-	for {
-		select {
-		case <-t.C:
-			return false, errTimeOut
-		default:
-			if q.EnQueue(i) {
-				return true, nil
-			}
+func (q *LRQueue[T]) Empty() bool {
+	q.onceInit()
+	for r := q.loadHead(); r != nil; r = r.loadNext() {
+		if !r.empty() {
+			return false
 		}
 	}
+	return true
 }
 
-// not use yet
-func (q *LRQueue) grow() bool {
-	// TODO grow queue data
-	return false
+// 带超时EnQueue入队，超时返回context.DeadlineExceeded。
+//
+// Deprecated: 使用EnQueueCtx。
+func (q *LRQueue[T]) PutWait(i T, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := q.EnQueueCtx(ctx, i); err != nil {
+		return false, err
+	}
+	return true, nil
 }
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// MPSCQueue is an unbounded multi-producer single-consumer linked list queue.
+//
+// Vyukov的MPSC链表队列。任意goroutine可以EnQueue，同一时刻只能有一个goroutine DeQueue。
+// 只实现了Queue接口，不是DataQueue：没有Close,Done,EnQueueMany,Range,Snapshot等方法，
+// 不能关闭，也不能用于Snapshot,Restore；Out只能通过ctx停止。
+//
+// EnQueue只有一次原子交换tail，再将原来的tail.next指向新node，没有CAS循环，
+// 不会因为其他生产者重试。node从sync.Pool获取，pool空时分配新的node，
+// 所以EnQueue的步数有上限，但会分配内存，不是严格意义的wait-free。
+// head只由消费者访问，DeQueue不需要CAS，也不需要危险指针：
+// 消费者越过head时，head.next已经写入，生产者不会再访问head，可以直接复用。
+//
+// 生产者交换tail后、写入next前被挂起，后面入队的值也要等它写入next才能取出，
+// 这期间DeQueue返回false。
+type MPSCQueue[T any] struct {
+	once sync.Once
+
+	// head 哨兵node,head.next才是第一个值。只由消费者访问。
+	head *ptrNode[T]
+	_    cacheLinePad
+	// tail 最后一个node,生产者原子交换。
+	tail unsafe.Pointer
+	_    cacheLinePad
+
+	pool sync.Pool
+}
+
+func (q *MPSCQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init()
+	})
+}
+
+func (q *MPSCQueue[T]) init() {
+	stub := newPrtNode[T]()
+	q.head = stub
+	atomic.StorePointer(&q.tail, unsafe.Pointer(stub))
+}
+
+// Init 丢弃队列中的值，只能由消费者调用。
+func (q *MPSCQueue[T]) Init() {
+	q.onceInit()
+	for {
+		if _, ok := q.DeQueue(); !ok {
+			return
+		}
+	}
+}
+
+func (q *MPSCQueue[T]) newNode() *ptrNode[T] {
+	if n, ok := q.pool.Get().(*ptrNode[T]); ok {
+		return n
+	}
+	return newPrtNode[T]()
+}
+
+// EnQueue 可以由多个goroutine并发调用，总是成功。
+func (q *MPSCQueue[T]) EnQueue(val T) bool {
+	q.onceInit()
+	n := q.newNode()
+	n.p = val
+	prev := (*ptrNode[T])(atomic.SwapPointer(&q.tail, unsafe.Pointer(n)))
+	// 写入next后，消费者才能看到n。
+	atomic.StorePointer(&prev.next, unsafe.Pointer(n))
+	return true
+}
+
+// DeQueue 只能由一个消费者调用。
+func (q *MPSCQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	head := q.head
+	next := (*ptrNode[T])(atomic.LoadPointer(&head.next))
+	if next == nil {
+		// 队列空，或者EnQueue还没写入next
+		return
+	}
+	// next成为新的哨兵
+	val = next.p
+	var zero T
+	next.p = zero
+	q.head = next
+	head.free()
+	q.pool.Put(head)
+	return val, true
+}
+
+// Peek 返回队头的值，不取出。只能由消费者调用。
+func (q *MPSCQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	next := (*ptrNode[T])(atomic.LoadPointer(&q.head.next))
+	if next == nil {
+		return
+	}
+	return next.p, true
+}
+
+// Empty 只能由消费者调用。
+func (q *MPSCQueue[T]) Empty() bool {
+	q.onceInit()
+	return atomic.LoadPointer(&q.head.next) == nil
+}
@@ -8,23 +8,31 @@ import (
 // ---------------------------		queue with slice	-----------------------------//
 
 // SAQueue is an unbounded queue which uses a slice as underlying.
-type SAQueue struct {
+type SAQueue[T any] struct {
 	once sync.Once
 	mu   sync.Mutex
-	data []interface{}
+	len  uint32 // 锁外读取的数量
+	data []T
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
 }
 
-func (q *SAQueue) onceInit() {
+func (q *SAQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *SAQueue) init() {
-	q.data = make([]interface{}, 0, DefauleSize)
+func (q *SAQueue[T]) init() {
+	q.data = make([]T, 0, DefauleSize)
+	atomic.StoreUint32(&q.len, 0)
 }
 
-func (q *SAQueue) Init() {
+func (q *SAQueue[T]) Init() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	q.onceInit()
@@ -32,34 +40,40 @@ func (q *SAQueue) Init() {
 	q.init()
 }
 
-func (q *SAQueue) Cap() int {
+func (q *SAQueue[T]) Cap() int {
 	return queueLimit
 }
 
-func (q *SAQueue) Full() bool {
+func (q *SAQueue[T]) Full() bool {
 	return false
 }
 
-func (q *SAQueue) Empty() bool {
-	return len(q.data) == 0
+func (q *SAQueue[T]) Empty() bool {
+	return atomic.LoadUint32(&q.len) == 0
 }
 
-func (q *SAQueue) Size() int {
-	return len(q.data)
+func (q *SAQueue[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
-func (q *SAQueue) EnQueue(i interface{}) bool {
+func (q *SAQueue[T]) EnQueue(i T) bool {
 	q.onceInit()
 	if q.Full() {
 		return false
 	}
 	q.mu.Lock()
+	if q.cl.isClosed() {
+		q.mu.Unlock()
+		return false
+	}
 	q.data = append(q.data, i)
+	atomic.AddUint32(&q.len, 1)
 	q.mu.Unlock()
+	q.notEmpty.signal()
 	return true
 }
 
-func (q *SAQueue) DeQueue() (val interface{}, ok bool) {
+func (q *SAQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
 	if q.Empty() {
 		return
@@ -69,11 +83,59 @@ func (q *SAQueue) DeQueue() (val interface{}, ok bool) {
 	if q.Empty() {
 		return
 	}
+	var zero T
 	val = q.data[0]
+	q.data[0] = zero
 	q.data = q.data[1:]
+	atomic.AddUint32(&q.len, negativeOne)
 	return val, true
 }
 
+// Peek 返回队头的值，不取出。
+func (q *SAQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.data) == 0 {
+		return
+	}
+	return q.data[0], true
+}
+
+func (q *SAQueue[T]) EnQueueMany(vals []T) (n int) {
+	q.onceInit()
+	q.mu.Lock()
+	if q.cl.isClosed() {
+		q.mu.Unlock()
+		return 0
+	}
+	q.data = append(q.data, vals...)
+	atomic.AddUint32(&q.len, uint32(len(vals)))
+	q.mu.Unlock()
+	q.notEmpty.signal()
+	return len(vals)
+}
+
+func (q *SAQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n = copy(dst, q.data)
+	var zero T
+	for i := 0; i < n; i++ {
+		q.data[i] = zero
+	}
+	q.data = q.data[n:]
+	atomic.AddUint32(&q.len, ^uint32(n)+1)
+	return n
+}
+
 // ---------------------------		single mutex ring queue		-----------------------------//
 
 // 单锁环形队列,有固定数组
@@ -81,10 +143,10 @@ func (q *SAQueue) DeQueue() (val interface{}, ok bool) {
 // EnQUeue,DeQueue操作时，先操作slot增改value
 // 操作完成后移动deID,enID.
 // 队列空条件为deID==enID
-// 满条件enID^cap==deID
+// 满条件enID-deID==cap
 //
 // SRQueue is an unbounded queue which uses a slice as underlying.
-type SRQueue struct {
+type SRQueue[T any] struct {
 	once sync.Once
 	mu   sync.Mutex
 
@@ -95,100 +157,188 @@ type SRQueue struct {
 	deID uint32
 	enID uint32
 
-	data []baseNode
+	data []baseNode[T]
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+	// 队列满时EnQueueCtx在notFull等待
+	notFull notifier
+
+	// of 队列满时EnQueue的处理方式，见SetOverflow
+	of overflow[T]
 }
 
-func (q *SRQueue) onceInit() {
+func (q *SRQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *SRQueue) init() {
-	if q.cap < 1 {
-		q.cap = DefauleSize
+// init 在onceInit中或者持有锁时调用。
+// cap,deID,len会被锁外的Cap,Full,Empty,Size读取，原子写入。
+func (q *SRQueue[T]) init() {
+	c := atomic.LoadUint32(&q.cap)
+	if c < 1 {
+		c = DefauleSize
 	}
-	q.mod = modUint32(q.cap)
-	q.cap = q.mod + 1
-	q.deID = q.enID
-	q.data = make([]baseNode, q.cap)
-	q.len = 0
+	q.mod = modUint32(c)
+	q.data = make([]baseNode[T], q.mod+1)
+	atomic.StoreUint32(&q.cap, q.mod+1)
+	atomic.StoreUint32(&q.deID, atomic.LoadUint32(&q.enID))
+	atomic.StoreUint32(&q.len, 0)
 }
 
-func (q *SRQueue) Init() {
+func (q *SRQueue[T]) Init() {
 	q.InitWith()
 }
 
 // InitWith 初始化长度为cap的queue,
 // 如果未提供，则使用默认值: DefaultSize
-func (q *SRQueue) InitWith(caps ...int) {
+func (q *SRQueue[T]) InitWith(caps ...int) {
 	q.onceInit()
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	if len(caps) > 0 && caps[0] > 0 {
-		q.cap = uint32(caps[0])
+		atomic.StoreUint32(&q.cap, uint32(caps[0]))
 	}
 	q.init()
-	for i := 0; i < cap(q.data); i++ {
-		q.data[i].free()
-	}
+	q.notFull.signal()
+}
+
+// InitAt 清空队列，enID,deID从id开始，容量不变。
+// ID回绕前后行为相同，InitAt从回绕点附近开始，用来验证长时间运行后的状态。
+func (q *SRQueue[T]) InitAt(id uint32) {
+	q.onceInit()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.init()
+	atomic.StoreUint32(&q.enID, id)
+	atomic.StoreUint32(&q.deID, id)
+	q.notFull.signal()
 }
 
-func (q *SRQueue) Cap() int {
-	return int(q.cap)
+func (q *SRQueue[T]) Cap() int {
+	return int(atomic.LoadUint32(&q.cap))
 }
 
-func (q *SRQueue) Full() bool {
-	return q.enID^q.cap == q.deID
+// Full,Empty,Size可能在锁外调用，原子读取ID
+func (q *SRQueue[T]) Full() bool {
+	// 先读deID,保证enID-deID不会溢出
+	deID := atomic.LoadUint32(&q.deID)
+	return atomic.LoadUint32(&q.enID)-deID >= atomic.LoadUint32(&q.cap)
 }
 
-func (q *SRQueue) Empty() bool {
-	return q.deID == q.enID
+func (q *SRQueue[T]) Empty() bool {
+	return atomic.LoadUint32(&q.deID) == atomic.LoadUint32(&q.enID)
 }
 
-func (q *SRQueue) Size() int {
-	return int(q.len)
+func (q *SRQueue[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
 // 根据enID,deID获取进队，出队对应的slot
-func (q *SRQueue) getSlot(id uint32) node {
+func (q *SRQueue[T]) getSlot(id uint32) node[T] {
 	return &q.data[id&q.mod]
 }
 
-func (q *SRQueue) EnQueue(val interface{}) bool {
+func (q *SRQueue[T]) enQueue(val T) bool {
 	q.mu.Lock()
-	if q.Full() {
-		return false
-	}
 	defer q.mu.Unlock()
 	q.onceInit()
-	if q.Full() {
+	if q.cl.isClosed() || q.Full() {
 		return false
 	}
-	q.getSlot(q.enID).store(val)
-	q.enID += 1
-	q.len += 1
+	slot := q.getSlot(q.enID)
+	if slot.stored() {
+		// 队列满了
+		return false
+	}
+	slot.store(val)
+	atomic.AddUint32(&q.enID, 1)
+	atomic.AddUint32(&q.len, 1)
+	q.notEmpty.signal()
 	return true
 }
 
-func (q *SRQueue) DeQueue() (val interface{}, ok bool) {
+func (q *SRQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
 	if q.Empty() {
-		return nil, false
+		return
 	}
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	if q.Empty() {
-		return nil, false
+		return
 	}
 	slot := q.getSlot(q.deID)
-	val = slot.load()
-	q.deID += 1
-	q.len -= 1
+	val, _ = slot.load()
+	atomic.AddUint32(&q.deID, 1)
+	atomic.AddUint32(&q.len, negativeOne)
 	slot.free()
+	q.notFull.signal()
 	return val, true
 }
 
+// Peek 返回队头的值，不取出。
+func (q *SRQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Empty() {
+		return
+	}
+	return q.getSlot(q.deID).load()
+}
+
+func (q *SRQueue[T]) enQueueMany(vals []T) (n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onceInit()
+	if q.cl.isClosed() {
+		return 0
+	}
+	for ; n < len(vals) && !q.Full(); n++ {
+		slot := q.getSlot(q.enID)
+		if slot.stored() {
+			// 队列满了
+			break
+		}
+		slot.store(vals[n])
+		atomic.AddUint32(&q.enID, 1)
+	}
+	atomic.AddUint32(&q.len, uint32(n))
+	if n > 0 {
+		q.notEmpty.signal()
+	}
+	return n
+}
+
+func (q *SRQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ; n < len(dst) && !q.Empty(); n++ {
+		slot := q.getSlot(q.deID)
+		dst[n], _ = slot.load()
+		atomic.AddUint32(&q.deID, 1)
+		slot.free()
+	}
+	atomic.AddUint32(&q.len, ^uint32(n)+1)
+	if n > 0 {
+		q.notFull.signal()
+	}
+	return n
+}
+
 // ---------------------------		dobul mutex ring queue		-----------------------------//
 
 // 双锁环形队列,有固定数组
@@ -196,10 +346,10 @@ func (q *SRQueue) DeQueue() (val interface{}, ok bool) {
 // EnQUeue,DeQueue操作时，先操作slot增改value
 // 操作完成后移动deID,enID.
 // 队列空条件为deID==enID
-// 满条件enID^cap==deID
+// 满条件enID-deID==cap
 //
 // DRQueue is an unbounded queue which uses a slice as underlying.
-type DRQueue struct {
+type DRQueue[T any] struct {
 	once sync.Once
 	deMu sync.Mutex
 	enMu sync.Mutex
@@ -215,33 +365,47 @@ type DRQueue struct {
 	// val不为空，表所可以DeQueue,如果是EnQUeue操作，表示队列满了。
 	// 并且只能由EnQUeue将val从nil变成非nil,
 	// 只能由DeQueue将val从非niu变成nil.
-	data []baseNode
+	data []baseNode[T]
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+	// 队列满时EnQueueCtx在notFull等待
+	notFull notifier
+
+	// of 队列满时EnQueue的处理方式，见SetOverflow
+	of overflow[T]
 }
 
-func (q *DRQueue) onceInit() {
+func (q *DRQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *DRQueue) init() {
-	if q.cap < 1 {
-		q.cap = DefauleSize
+// init 在onceInit中或者持有锁时调用。
+// cap,deID,len会被锁外的Cap,Full,Empty,Size读取，原子写入。
+func (q *DRQueue[T]) init() {
+	c := atomic.LoadUint32(&q.cap)
+	if c < 1 {
+		c = DefauleSize
 	}
-	q.mod = modUint32(q.cap)
-	q.cap = q.mod + 1
-	q.deID = q.enID
-	q.data = make([]baseNode, q.cap)
-	q.len = 0
+	q.mod = modUint32(c)
+	q.data = make([]baseNode[T], q.mod+1)
+	atomic.StoreUint32(&q.cap, q.mod+1)
+	atomic.StoreUint32(&q.deID, atomic.LoadUint32(&q.enID))
+	atomic.StoreUint32(&q.len, 0)
 }
 
-func (q *DRQueue) Init() {
+func (q *DRQueue[T]) Init() {
 	q.InitWith()
 }
 
 // InitWith 初始化长度为cap的queue,
 // 如果未提供，则使用默认值: DefaultSize
-func (q *DRQueue) InitWith(cap ...int) {
+func (q *DRQueue[T]) InitWith(cap ...int) {
 	q.onceInit()
 	q.enMu.Lock()
 	defer q.enMu.Unlock()
@@ -249,33 +413,50 @@ func (q *DRQueue) InitWith(cap ...int) {
 	defer q.deMu.Unlock()
 
 	if len(cap) > 0 && cap[0] > 0 {
-		q.cap = uint32(cap[0])
+		atomic.StoreUint32(&q.cap, uint32(cap[0]))
 	}
 	q.init()
+	q.notFull.signal()
+}
+
+// InitAt 清空队列，enID,deID从id开始，容量不变。
+// ID回绕前后行为相同，InitAt从回绕点附近开始，用来验证长时间运行后的状态。
+func (q *DRQueue[T]) InitAt(id uint32) {
+	q.onceInit()
+	q.enMu.Lock()
+	defer q.enMu.Unlock()
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+	q.init()
+	atomic.StoreUint32(&q.enID, id)
+	atomic.StoreUint32(&q.deID, id)
+	q.notFull.signal()
 }
 
-func (q *DRQueue) Cap() int {
-	return int(q.cap)
+func (q *DRQueue[T]) Cap() int {
+	return int(atomic.LoadUint32(&q.cap))
 }
 
-func (q *DRQueue) Full() bool {
-	return q.enID^q.cap == q.deID
+func (q *DRQueue[T]) Full() bool {
+	// 先读deID,保证enID-deID不会溢出
+	deID := atomic.LoadUint32(&q.deID)
+	return atomic.LoadUint32(&q.enID)-deID >= atomic.LoadUint32(&q.cap)
 }
 
-func (q *DRQueue) Empty() bool {
-	return q.deID == q.enID
+func (q *DRQueue[T]) Empty() bool {
+	return atomic.LoadUint32(&q.deID) == atomic.LoadUint32(&q.enID)
 }
 
-func (q *DRQueue) Size() int {
-	return int(q.len)
+func (q *DRQueue[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
 // 根据enID,deID获取进队，出队对应的slot
-func (q *DRQueue) getSlot(id uint32) node {
+func (q *DRQueue[T]) getSlot(id uint32) node[T] {
 	return &q.data[int(id&q.mod)]
 }
 
-func (q *DRQueue) EnQueue(val interface{}) bool {
+func (q *DRQueue[T]) enQueue(val T) bool {
 	q.onceInit()
 	if q.Full() {
 		return false
@@ -283,74 +464,143 @@ func (q *DRQueue) EnQueue(val interface{}) bool {
 	q.enMu.Lock()
 	defer q.enMu.Unlock()
 
-	if q.Full() {
+	if q.cl.isClosed() || q.Full() {
 		return false
 	}
 	slot := q.getSlot(q.enID)
-	if slot.load() != nil {
+	if slot.stored() {
 		// 队列满了
 		return false
 	}
 	atomic.AddUint32(&q.enID, 1)
 	atomic.AddUint32(&q.len, 1)
-	if val == nil {
-		val = empty
-	}
 	slot.store(val)
+	q.notEmpty.signal()
 	return true
 }
 
-func (q *DRQueue) DeQueue() (val interface{}, ok bool) {
+func (q *DRQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
 	if q.Empty() {
-		return nil, false
+		return
 	}
 	q.deMu.Lock()
 	defer q.deMu.Unlock()
 
 	if q.Empty() {
-		return nil, false
+		return
 	}
 	slot := q.getSlot(q.deID)
-	if slot.load() == nil {
+	val, ok = slot.load()
+	if !ok {
 		// EnQueue正在写入
-		return nil, false
-	}
-	val = slot.load()
-	if val == empty {
-		val = nil
+		return
 	}
 	atomic.AddUint32(&q.len, ^uint32(0))
 	atomic.AddUint32(&q.deID, 1)
 	slot.free()
+	q.notFull.signal()
 	return val, true
 }
 
+// Peek 返回队头的值，不取出。和DeQueue一样，EnQueue正在写入时返回false。
+func (q *DRQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+	if q.Empty() {
+		return
+	}
+	return q.getSlot(q.deID).load()
+}
+
+func (q *DRQueue[T]) enQueueMany(vals []T) (n int) {
+	q.onceInit()
+	if q.Full() {
+		return
+	}
+	q.enMu.Lock()
+	defer q.enMu.Unlock()
+
+	if q.cl.isClosed() {
+		return 0
+	}
+	for ; n < len(vals) && !q.Full(); n++ {
+		slot := q.getSlot(q.enID)
+		if slot.stored() {
+			// 队列满了
+			break
+		}
+		atomic.AddUint32(&q.enID, 1)
+		atomic.AddUint32(&q.len, 1)
+		slot.store(vals[n])
+	}
+	if n > 0 {
+		q.notEmpty.signal()
+	}
+	return n
+}
+
+func (q *DRQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+
+	for ; n < len(dst) && !q.Empty(); n++ {
+		slot := q.getSlot(q.deID)
+		val, ok := slot.load()
+		if !ok {
+			// EnQueue正在写入
+			break
+		}
+		dst[n] = val
+		atomic.AddUint32(&q.len, ^uint32(0))
+		atomic.AddUint32(&q.deID, 1)
+		slot.free()
+	}
+	if n > 0 {
+		q.notFull.signal()
+	}
+	return n
+}
+
 // ---------------------------		single mutex list queue		-----------------------------//
 
 // SLQueue unbounded list queue with one mutex
-type SLQueue struct {
+type SLQueue[T any] struct {
 	once sync.Once
 	mu   sync.Mutex
 
-	len  int
-	head *listNode
-	tail *listNode
+	len  uint32
+	head *listNode[T]
+	tail *listNode[T]
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
 }
 
-func (q *SLQueue) onceInit() {
+func (q *SLQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *SLQueue) init() {
-	q.head = newListNode(nil)
+func (q *SLQueue[T]) init() {
+	q.head = newListNode[T]()
 	q.tail = q.head
-	q.len = 0
+	atomic.StoreUint32(&q.len, 0)
 }
 
-func (q *SLQueue) Init() {
+func (q *SLQueue[T]) Init() {
 	q.onceInit()
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -361,7 +611,7 @@ func (q *SLQueue) Init() {
 		return
 	}
 	q.head = q.tail
-	q.len = 0
+	atomic.StoreUint32(&q.len, 0)
 	for head != tail && head != nil {
 		freeNode := head
 		head = freeNode.next
@@ -370,30 +620,30 @@ func (q *SLQueue) Init() {
 	return
 }
 
-func (q *SLQueue) Cap() int {
+func (q *SLQueue[T]) Cap() int {
 	return queueLimit
 }
 
-func (q *SLQueue) Full() bool {
+func (q *SLQueue[T]) Full() bool {
 	return false
 }
 
-func (q *SLQueue) Empty() bool {
-	return q.head == q.tail
+func (q *SLQueue[T]) Empty() bool {
+	return atomic.LoadUint32(&q.len) == 0
 }
 
-func (q *SLQueue) Size() int {
-	return q.len
+func (q *SLQueue[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
-func (q *SLQueue) EnQueue(val interface{}) bool {
+func (q *SLQueue[T]) EnQueue(val T) bool {
 	q.onceInit()
 	q.mu.Lock()
 	defer q.mu.Unlock()
-
-	if val == nil {
-		val = empty
+	if q.cl.isClosed() {
+		return false
 	}
+
 	// 方案1：tail指向最后一个有效node
 	// slot := newListNode(i)
 	// q.tail.next = slot
@@ -401,16 +651,17 @@ func (q *SLQueue) EnQueue(val interface{}) bool {
 
 	// 方案2：tail指向下一个存入的空node
 	slot := q.tail
-	nilNode := newListNode(nil)
+	nilNode := newListNode[T]()
 	slot.next = nilNode
 	q.tail = nilNode
 	slot.store(val)
 
-	q.len++
+	atomic.AddUint32(&q.len, 1)
+	q.notEmpty.signal()
 	return true
 }
 
-func (q *SLQueue) DeQueue() (val interface{}, ok bool) {
+func (q *SLQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
 	if q.Empty() {
 		return
@@ -428,48 +679,106 @@ func (q *SLQueue) DeQueue() (val interface{}, ok bool) {
 
 	// 方案2：head指向下一个取出的有效node
 	slot := q.head
-	val = slot.load()
-	if val == nil {
+	val, ok = slot.load()
+	if !ok {
 		return
 	}
 	q.head = slot.next
-
-	if val == empty {
-		val = nil
-	}
-	q.len--
+	atomic.AddUint32(&q.len, negativeOne)
 	slot.free()
 	return val, true
 }
 
+// Peek 返回队头的值，不取出。
+func (q *SLQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.head.load()
+}
+
+func (q *SLQueue[T]) EnQueueMany(vals []T) (n int) {
+	if len(vals) == 0 {
+		return
+	}
+	q.onceInit()
+	// 锁外先连好node，第一个val存入当前tail。
+	head, tail := newListChain(vals[1:])
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cl.isClosed() {
+		return 0
+	}
+
+	slot := q.tail
+	slot.next = head
+	q.tail = tail
+	slot.store(vals[0])
+
+	atomic.AddUint32(&q.len, uint32(len(vals)))
+	q.notEmpty.signal()
+	return len(vals)
+}
+
+func (q *SLQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ; n < len(dst) && !q.Empty(); n++ {
+		slot := q.head
+		val, ok := slot.load()
+		if !ok {
+			break
+		}
+		dst[n] = val
+		q.head = slot.next
+		slot.free()
+	}
+	atomic.AddUint32(&q.len, ^uint32(n)+1)
+	return n
+}
+
 // 双锁链表队列
 //
 // DLQueue is a concurrent unbounded queue which uses two-Lock concurrent queue qlgorithm.
 
 // DLQueue unbounded list queue with one mutex
-type DLQueue struct {
+type DLQueue[T any] struct {
 	once sync.Once
 	deMu sync.Mutex // DeQueue操作锁
 	enMu sync.Mutex // EnQUeue操作锁
 
 	len  uint32
-	head *listNode // 只能由DeQueue操作更改，其他操作只读
-	tail *listNode // 只能由EnQUeue操作更改，其他操作只读
+	head *listNode[T] // 只能由DeQueue操作更改，其他操作只读
+	tail *listNode[T] // 只能由EnQUeue操作更改，其他操作只读
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
 }
 
-func (q *DLQueue) onceInit() {
+func (q *DLQueue[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *DLQueue) init() {
-	q.head = newListNode(nil)
+func (q *DLQueue[T]) init() {
+	q.head = newListNode[T]()
 	q.tail = q.head
-	q.len = 0
+	atomic.StoreUint32(&q.len, 0)
 }
 
-func (q *DLQueue) Init() {
+func (q *DLQueue[T]) Init() {
 	q.onceInit()
 	q.enMu.Lock()
 	defer q.enMu.Unlock()
@@ -482,7 +791,7 @@ func (q *DLQueue) Init() {
 		return
 	}
 	q.head = q.tail
-	q.len = 0
+	atomic.StoreUint32(&q.len, 0)
 	for head != tail && head != nil {
 		freeNode := head
 		head = freeNode.next
@@ -491,40 +800,42 @@ func (q *DLQueue) Init() {
 	return
 }
 
-func (q *DLQueue) Cap() int {
+func (q *DLQueue[T]) Cap() int {
 	return queueLimit
 }
 
-func (q *DLQueue) Full() bool {
+func (q *DLQueue[T]) Full() bool {
 	return false
 }
 
-func (q *DLQueue) Empty() bool {
-	return q.head == q.tail
+func (q *DLQueue[T]) Empty() bool {
+	// len在node储存完成后才增加，len不为0时head一定可以取出
+	return atomic.LoadUint32(&q.len) == 0
 }
 
-func (q *DLQueue) Size() int {
+func (q *DLQueue[T]) Size() int {
 	return int(q.len)
 }
 
-func (q *DLQueue) EnQueue(val interface{}) bool {
+func (q *DLQueue[T]) EnQueue(val T) bool {
 	q.onceInit()
 	q.enMu.Lock()
 	defer q.enMu.Unlock()
-	if val == nil {
-		val = empty
+	if q.cl.isClosed() {
+		return false
 	}
 	// tail指向下一个存入的位置
 	slot := q.tail
-	nilNode := newListNode(nil)
+	nilNode := newListNode[T]()
 	slot.next = nilNode
 	q.tail = nilNode
 	slot.store(val)
 	atomic.AddUint32(&q.len, 1)
+	q.notEmpty.signal()
 	return true
 }
 
-func (q *DLQueue) DeQueue() (val interface{}, ok bool) {
+func (q *DLQueue[T]) DeQueue() (val T, ok bool) {
 	q.onceInit()
 	if q.Empty() {
 		return
@@ -536,15 +847,67 @@ func (q *DLQueue) DeQueue() (val interface{}, ok bool) {
 		return
 	}
 	slot := q.head
-	val = slot.load()
-	if val == nil {
+	val, ok = slot.load()
+	if !ok {
 		return
 	}
 	q.head = slot.next
-	if val == empty {
-		val = nil
-	}
 	atomic.AddUint32(&q.len, negativeOne)
 	slot.free()
 	return val, true
 }
+
+// Peek 返回队头的值，不取出。
+func (q *DLQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+	return q.head.load()
+}
+
+func (q *DLQueue[T]) EnQueueMany(vals []T) (n int) {
+	if len(vals) == 0 {
+		return
+	}
+	q.onceInit()
+	// 锁外先连好node，第一个val存入当前tail。
+	head, tail := newListChain(vals[1:])
+	q.enMu.Lock()
+	defer q.enMu.Unlock()
+	if q.cl.isClosed() {
+		return 0
+	}
+
+	slot := q.tail
+	slot.next = head
+	q.tail = tail
+	slot.store(vals[0])
+	atomic.AddUint32(&q.len, uint32(len(vals)))
+	q.notEmpty.signal()
+	return len(vals)
+}
+
+func (q *DLQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+
+	for ; n < len(dst) && !q.Empty(); n++ {
+		slot := q.head
+		val, ok := slot.load()
+		if !ok {
+			break
+		}
+		dst[n] = val
+		q.head = slot.next
+		slot.free()
+	}
+	atomic.AddUint32(&q.len, ^uint32(n)+1)
+	return n
+}
@@ -9,125 +9,168 @@ import (
 )
 
 // node接口
-type node interface {
-	load() interface{}
-	store(interface{})
+//
+// load返回slot储存的值，ok为false表示slot为空。
+type node[T any] interface {
+	load() (val T, ok bool)
+	// stored 只检查是否储存了值，不读取值。
+	stored() bool
+	store(T)
 	free()
 }
 
-func newNode() node {
-	return &baseNode{}
+func newNode[T any]() node[T] {
+	return &baseNode[T]{}
 }
 
-// interface node
-type baseNode struct {
-	p interface{}
+// 泛型 node
+//
+// state标记slot是否储存了值，代替原来用nil表示空slot。
+type baseNode[T any] struct {
+	p     T
+	state uint32
 }
 
-func newBaseNode(i interface{}) *baseNode {
-	return &baseNode{p: i}
+func newBaseNode[T any](i T) *baseNode[T] {
+	n := &baseNode[T]{}
+	n.store(i)
+	return n
 }
 
-func (n *baseNode) load() interface{} {
-	return n.p
+func (n *baseNode[T]) load() (val T, ok bool) {
+	if atomic.LoadUint32(&n.state) == 0 {
+		return
+	}
+	return n.p, true
+}
+
+func (n *baseNode[T]) stored() bool {
+	return atomic.LoadUint32(&n.state) != 0
 }
 
-func (n *baseNode) store(i interface{}) {
+func (n *baseNode[T]) store(i T) {
 	n.p = i
+	atomic.StoreUint32(&n.state, 1)
 }
 
-func (n *baseNode) free() {
-	n.p = nil
+func (n *baseNode[T]) free() {
+	// 先清空p，再清除state。
+	// state为0后slot可能马上被store，不能再写p。
+	var zero T
+	n.p = zero
+	atomic.StoreUint32(&n.state, 0)
 }
 
 // unsafe.Pointer node
-type unNode struct {
+type unNode[T any] struct {
 	p unsafe.Pointer
 }
 
-func newUnNode(i interface{}) *unNode {
-	return &unNode{p: unsafe.Pointer(&i)}
+func newUnNode[T any](i T) *unNode[T] {
+	return &unNode[T]{p: unsafe.Pointer(&i)}
 }
 
-func (n *unNode) load() interface{} {
+func (n *unNode[T]) stored() bool {
+	return atomic.LoadPointer(&n.p) != nil
+}
+
+func (n *unNode[T]) load() (val T, ok bool) {
 	p := atomic.LoadPointer(&n.p)
 	if p == nil {
-		return nil
+		return
 	}
-	return *(*interface{})(p)
+	return *(*T)(p), true
 }
 
-func (n *unNode) store(i interface{}) {
+func (n *unNode[T]) store(i T) {
 	atomic.StorePointer(&n.p, unsafe.Pointer(&i))
 }
 
-func (n *unNode) free() {
+func (n *unNode[T]) free() {
 	atomic.StorePointer(&n.p, nil)
 }
 
 // 链表节点
-type listNode struct {
-	baseNode
-	next *listNode
+type listNode[T any] struct {
+	baseNode[T]
+	next *listNode[T]
 }
 
-func newListNode(i interface{}) *listNode {
-	ln := listNode{}
-	ln.store(i)
-	return &ln
+func newListNode[T any]() *listNode[T] {
+	return &listNode[T]{}
 }
 
-func (n *listNode) free() {
+func (n *listNode[T]) free() {
 	n.baseNode.free()
 	n.next = nil
 }
 
+// newListChain 连好存有vals的node，最后一个是空node,
+// 返回第一个和最后一个node。
+func newListChain[T any](vals []T) (head, tail *listNode[T]) {
+	tail = newListNode[T]()
+	head = tail
+	for i := len(vals) - 1; i >= 0; i-- {
+		n := newListNode[T]()
+		n.store(vals[i])
+		n.next = head
+		head = n
+	}
+	return head, tail
+}
+
 // node next->unsafe.Pointer
-type ptrNode struct {
-	p    interface{}
+type ptrNode[T any] struct {
+	baseNode[T]
 	next unsafe.Pointer
 }
 
-func newPrtNode(i interface{}) *ptrNode {
-	return &ptrNode{p: i}
+func newPrtNode[T any]() *ptrNode[T] {
+	return &ptrNode[T]{}
 }
 
-func (n *ptrNode) load() interface{} {
-	return n.p
+// NextAddr 返回next的地址，供空闲链表使用。
+func (n *ptrNode[T]) NextAddr() *unsafe.Pointer {
+	return &n.next
 }
 
-func (n *ptrNode) store(i interface{}) {
-	n.p = i
+func (n *ptrNode[T]) free() {
+	n.baseNode.free()
+	atomic.StorePointer(&n.next, nil)
 }
 
-func (n *ptrNode) free() {
-	// n.p = nil
-	n.next = nil
+// loadNext 读取ptrNode的next
+func loadNext[T any](p unsafe.Pointer) unsafe.Pointer {
+	return atomic.LoadPointer(&(*ptrNode[T])(p).next)
 }
 
 // node next->unsafe.Pointer
-type unListNode struct {
+type unListNode[T any] struct {
 	p    unsafe.Pointer
 	next unsafe.Pointer
 }
 
-func newUnListNode(i interface{}) *unListNode {
-	return &unListNode{p: unsafe.Pointer(&i)}
+func newUnListNode[T any](i T) *unListNode[T] {
+	return &unListNode[T]{p: unsafe.Pointer(&i)}
+}
+
+func (n *unListNode[T]) stored() bool {
+	return atomic.LoadPointer(&n.p) != nil
 }
 
-func (n *unListNode) load() interface{} {
+func (n *unListNode[T]) load() (val T, ok bool) {
 	p := atomic.LoadPointer(&n.p)
 	if p == nil {
-		return nil
+		return
 	}
-	return *(*interface{})(p)
+	return *(*T)(p), true
 }
 
-func (n *unListNode) store(i interface{}) {
+func (n *unListNode[T]) store(i T) {
 	atomic.StorePointer(&n.p, unsafe.Pointer(&i))
 }
 
-func (n *unListNode) free() {
+func (n *unListNode[T]) free() {
 	atomic.StorePointer(&n.p, nil)
 	atomic.StorePointer(&n.next, nil)
 }
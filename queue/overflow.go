@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+)
+
+// Overflow 有界队列满了时EnQueue的处理方式，见SetOverflow。
+type Overflow uint32
+
+const (
+	// Reject 拒绝新值，EnQueue返回false，默认的处理方式。
+	Reject Overflow = iota
+
+	// DropOldest 取出并丢弃队头最旧的值，腾出位置加入新值。
+	// 适合只关心最近数据的场景，例如监控数据的缓冲。
+	DropOldest
+
+	// DropNewest 丢弃要加入的新值，队列不变，EnQueue返回true。
+	// 和Reject的区别是调用者不需要处理失败，丢弃的值交给回调统计。
+	DropNewest
+
+	// Block 阻塞等待，直到有空位加入新值，队列关闭时返回false。
+	// 和EnQueueCtx(context.Background(), val)一样。
+	Block
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Reject:
+		return "Reject"
+	case DropOldest:
+		return "DropOldest"
+	case DropNewest:
+		return "DropNewest"
+	case Block:
+		return "Block"
+	}
+	return "Overflow(?)"
+}
+
+// overflow 队列满时的处理方式，和接收丢弃值的回调。
+// 零值为Reject，没有回调。
+type overflow[T any] struct {
+	policy  Overflow
+	dropped func(val T)
+}
+
+// drop 把丢弃的值交给回调
+func (o *overflow[T]) drop(val T) {
+	if o.dropped != nil {
+		o.dropped(val)
+	}
+}
+
+// enQueue enQueue失败(队列满)后，按policy处理val。
+// evict取出队头的值，enQueue,evict都会唤醒等待的EnQueueCtx,DeQueueCtx。
+//
+// DropOldest腾出位置后，其他EnQueue可能抢先占用，这时继续丢弃队头，
+// 所以并发时丢弃的值可能多于一个，但每个都是丢弃时队头最旧的值。
+// 回调在锁外调用，可以在回调中操作队列。
+func (o *overflow[T]) enQueue(val T, enQueue func(val T) bool, evict func() (T, bool), notFull *notifier, cl *closer) bool {
+	switch o.policy {
+	case DropOldest:
+		for !cl.isClosed() {
+			if old, ok := evict(); ok {
+				o.drop(old)
+			} else {
+				// 队头正在写入或者被取走，很快完成。
+				runtime.Gosched()
+			}
+			if enQueue(val) {
+				return true
+			}
+		}
+	case DropNewest:
+		if !cl.isClosed() {
+			o.drop(val)
+			return true
+		}
+	case Block:
+		return enQueueCtx[T](context.Background(), enQueue, notFull, cl, val) == nil
+	}
+	return false
+}
+
+// enQueueMany enQueueMany加入前n个后，剩下的逐个按policy处理。
+func (o *overflow[T]) enQueueMany(vals []T, n int, enQueue func(val T) bool, evict func() (T, bool), notFull *notifier, cl *closer) int {
+	if o.policy == Reject {
+		return n
+	}
+	for ; n < len(vals); n++ {
+		if !enQueue(vals[n]) && !o.enQueue(vals[n], enQueue, evict, notFull, cl) {
+			break
+		}
+	}
+	return n
+}
+
+// SetOverflow 设置队列满时EnQueue,EnQueueMany的处理方式，
+// dropped不为nil时，接收DropOldest,DropNewest丢弃的值，可以用来统计丢弃的数量。
+// EnQueueCtx不受影响，依旧阻塞等待。
+// 应该在使用队列前设置，不能和其他操作并发调用。
+func (q *SRQueue[T]) SetOverflow(policy Overflow, dropped func(val T)) {
+	q.of = overflow[T]{policy: policy, dropped: dropped}
+}
+
+// EnQueue 加入val，队列满时按SetOverflow设置的方式处理，默认返回false。
+func (q *SRQueue[T]) EnQueue(val T) bool {
+	if q.enQueue(val) {
+		return true
+	}
+	return q.of.enQueue(val, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
+
+// EnQueueMany 按顺序加入vals，返回加入的数量，DropNewest丢弃的值也算在内。
+// 队列满时按SetOverflow设置的方式处理剩下的值。
+func (q *SRQueue[T]) EnQueueMany(vals []T) int {
+	n := q.enQueueMany(vals)
+	if n == len(vals) {
+		return n
+	}
+	return q.of.enQueueMany(vals, n, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
+
+// SetOverflow 设置队列满时EnQueue,EnQueueMany的处理方式，
+// dropped不为nil时，接收DropOldest,DropNewest丢弃的值，可以用来统计丢弃的数量。
+// EnQueueCtx不受影响，依旧阻塞等待。
+// 应该在使用队列前设置，不能和其他操作并发调用。
+func (q *DRQueue[T]) SetOverflow(policy Overflow, dropped func(val T)) {
+	q.of = overflow[T]{policy: policy, dropped: dropped}
+}
+
+// EnQueue 加入val，队列满时按SetOverflow设置的方式处理，默认返回false。
+// DropOldest由EnQueue调用DeQueue丢弃队头，和DeQueue一样要获取deMu。
+func (q *DRQueue[T]) EnQueue(val T) bool {
+	if q.enQueue(val) {
+		return true
+	}
+	return q.of.enQueue(val, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
+
+// EnQueueMany 按顺序加入vals，返回加入的数量，DropNewest丢弃的值也算在内。
+// 队列满时按SetOverflow设置的方式处理剩下的值。
+func (q *DRQueue[T]) EnQueueMany(vals []T) int {
+	n := q.enQueueMany(vals)
+	if n == len(vals) {
+		return n
+	}
+	return q.of.enQueueMany(vals, n, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
+
+// SetOverflow 设置队列满时EnQueue,EnQueueMany的处理方式，
+// dropped不为nil时，接收DropOldest,DropNewest丢弃的值，可以用来统计丢弃的数量。
+// 设置了最大容量时，扩容到最大容量后才算满。EnQueueCtx不受影响，依旧阻塞等待。
+// 应该在使用队列前设置，不能和其他操作并发调用。
+func (q *LRQueue[T]) SetOverflow(policy Overflow, dropped func(val T)) {
+	q.of = overflow[T]{policy: policy, dropped: dropped}
+}
+
+// EnQueue 加入val，队列满时按SetOverflow设置的方式处理，默认返回false。
+// DropOldest由EnQueue调用DeQueue丢弃队头，依旧lock-free。
+func (q *LRQueue[T]) EnQueue(val T) bool {
+	if q.enQueue(val) {
+		return true
+	}
+	return q.of.enQueue(val, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
+
+// EnQueueMany 按顺序加入vals，返回加入的数量，DropNewest丢弃的值也算在内。
+// 队列满时按SetOverflow设置的方式处理剩下的值。
+func (q *LRQueue[T]) EnQueueMany(vals []T) int {
+	n := q.enQueueMany(vals)
+	if n == len(vals) {
+		return n
+	}
+	return q.of.enQueueMany(vals, n, q.enQueue, q.DeQueue, &q.notFull, &q.cl)
+}
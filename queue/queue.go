@@ -1,19 +1,38 @@
 package queue
 
 import (
+	"context"
 	"sync/atomic"
 	"unsafe"
+
+	"github.com/min1324/data/backoff"
 )
 
 // Queue interface of queue
-type Queue interface {
-	EnQueue(interface{}) bool
-	DeQueue() (val interface{}, ok bool)
+type Queue[T any] interface {
+	EnQueue(T) bool
+	DeQueue() (val T, ok bool)
 }
 
-type DataQueue interface {
-	Queue
+type DataQueue[T any] interface {
+	Queue[T]
+	// EnQueueMany 按顺序批量入队，返回成功入队的数量。
+	EnQueueMany(vals []T) (n int)
+	// DeQueueMany 批量出队到dst，返回取出的数量。
+	DeQueueMany(dst []T) (n int)
+	// Peek 返回下一个出队的值，不取出。并发时的语义见各个队列。
+	Peek() (val T, ok bool)
+	// Close 关闭队列，之后EnQueue失败，DeQueue继续取出剩下的值。
+	Close()
+	// Done 队列关闭后，返回的channel被关闭。
+	Done() <-chan struct{}
+	// Range 按出队顺序遍历，不取出，f返回false时停止。一致性见各个队列。
+	Range(f func(v T) bool)
+	// Snapshot 按出队顺序返回所有值，不取出，见包函数Snapshot。
+	Snapshot() []T
 	onceInit()
+	// snapshot 按出队顺序返回所有值，见Snapshot
+	snapshot() []T
 	Init()
 	Cap() int
 	Size() int
@@ -21,72 +40,168 @@ type DataQueue interface {
 	Empty() bool
 }
 
+// BlockingQueue 有界队列，满或者空时可以阻塞等待。
+type BlockingQueue[T any] interface {
+	DataQueue[T]
+	EnQueueCtx(ctx context.Context, val T) error
+	DeQueueCtx(ctx context.Context) (T, error)
+}
+
+// AnyQueue 兼容旧的interface{}接口。
+type AnyQueue = Queue[interface{}]
+
+// AnyDataQueue 兼容旧的interface{}接口。
+type AnyDataQueue = DataQueue[interface{}]
+
+var (
+	_ DataQueue[int] = (*SAQueue[int])(nil)
+	_ DataQueue[int] = (*SRQueue[int])(nil)
+	_ DataQueue[int] = (*DRQueue[int])(nil)
+	_ DataQueue[int] = (*SLQueue[int])(nil)
+	_ DataQueue[int] = (*DLQueue[int])(nil)
+	_ DataQueue[int] = (*LLQueue[int])(nil)
+	_ DataQueue[int] = (*LRQueue[int])(nil)
+	_ DataQueue[int] = (*SPRQueue[int])(nil)
+	_ DataQueue[int] = (*LCRQueue[int])(nil)
+	_ DataQueue[int] = (*WLQueue[int])(nil)
+	_ DataQueue[int] = (*ShardQueue[int])(nil)
+
+	_ Queue[int] = (*MPSCQueue[int])(nil)
+	_ Shard[int] = (*Chain[int])(nil)
+
+	_ BlockingQueue[int] = (*SRQueue[int])(nil)
+	_ BlockingQueue[int] = (*DRQueue[int])(nil)
+	_ BlockingQueue[int] = (*LRQueue[int])(nil)
+	_ BlockingQueue[int] = (*SPRQueue[int])(nil)
+	_ BlockingQueue[int] = (*ShardQueue[int])(nil)
+)
+
 const (
 	DefauleSize = 1 << 10
 	negativeOne = ^uint32(0) // -1
-)
 
-// queueNil is used in queue to represent interface{}(nil).
-// Since we use nil to represent empty slots, we need a sentinel value
-// to represent nil.
-//
-// 包装空值
-// type queueNil *struct{}
+	cacheLineSize = 64
+)
 
-// 用一个包装nil值。
-var empty = unsafe.Pointer(new(interface{}))
+// cacheLinePad 隔开不同goroutine频繁写入的字段，避免伪共享。
+type cacheLinePad [cacheLineSize]byte
 
-// New return an empty lock-free unbound list Queue
-func New() Queue {
-	var q LLQueue
-	q.onceInit()
-	return &q
+// New return an empty lock-free unbound list Queue of interface{},
+// 兼容旧的interface{}接口。
+func New() AnyQueue {
+	return NewLLQueue[interface{}]()
 }
 
 // 双锁链表队列
-func NewDLQueue() Queue {
-	var q LLQueue
+func NewDLQueue[T any]() Queue[T] {
+	var q DLQueue[T]
 	q.onceInit()
 	return &q
 }
 
 // 双锁环形队列
-func NewDRQueue() Queue {
-	var q DRQueue
+func NewDRQueue[T any]() Queue[T] {
+	var q DRQueue[T]
 	q.onceInit()
 	return &q
 }
 
 // lock-free 链表队列
-func NewLLQueue() Queue {
-	var q LLQueue
+func NewLLQueue[T any]() Queue[T] {
+	var q LLQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// lock-free 链表队列,使用reclaim方式回收node。
+// 可选的b为cas失败后的退避策略，未提供时立即重试。
+func NewLLQueueWith[T any](reclaim Reclaim, b ...backoff.Backoff) Queue[T] {
+	var q LLQueue[T]
+	q.rc.Mode = reclaim
+	if len(b) > 0 {
+		q.bo = b[0]
+	}
+	q.onceInit()
+	return &q
+}
+
+// lock-free 环形队列
+func NewLRQueue[T any]() Queue[T] {
+	var q LRQueue[T]
 	q.onceInit()
 	return &q
 }
 
-func NewLRQueue() Queue {
-	var q LRQueue
+// lock-free 环形队列,cas失败后按b退避，caps同InitWith。
+func NewLRQueueWith[T any](b backoff.Backoff, caps ...int) Queue[T] {
+	var q LRQueue[T]
+	q.bo = b
+	q.InitWith(caps...)
+	return &q
+}
+
+// lock-free FAA环形队列链(LCRQ)
+func NewLCRQueue[T any]() Queue[T] {
+	var q LCRQueue[T]
 	q.onceInit()
 	return &q
 }
 
+// wait-free 链表队列
+func NewWLQueue[T any]() Queue[T] {
+	var q WLQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// n路队列，每一路由newQueue创建，shards<1时使用DefaultShards,newQueue为nil时使用LLQueue。
+// 每一路可以是任意DataQueue或者Chain，见Shard。
+func NewShardQueue[T any](shards int, newQueue func() Shard[T]) Queue[T] {
+	var q ShardQueue[T]
+	q.once.Do(func() {
+		q.init(shards, newQueue)
+	})
+	return &q
+}
+
 // 单锁数组队列
-func NewSAQueue() Queue {
-	var q SAQueue
+func NewSAQueue[T any]() Queue[T] {
+	var q SAQueue[T]
 	q.onceInit()
 	return &q
 }
 
 // 单锁链表队列
-func NewSLQueue() Queue {
-	var q SLQueue
+func NewSLQueue[T any]() Queue[T] {
+	var q SLQueue[T]
 	q.onceInit()
 	return &q
 }
 
 // 单锁环形队列
-func NewSRQueue() Queue {
-	var q SRQueue
+func NewSRQueue[T any]() Queue[T] {
+	var q SRQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// 单生产者单消费者wait-free环形队列
+func NewSPRQueue[T any]() Queue[T] {
+	var q SPRQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// 多生产者单消费者链表队列
+func NewMPSCQueue[T any]() Queue[T] {
+	var q MPSCQueue[T]
+	q.onceInit()
+	return &q
+}
+
+// 动态扩容的lock-free环形队列链
+func NewChain[T any]() Queue[T] {
+	var q Chain[T]
 	q.onceInit()
 	return &q
 }
@@ -0,0 +1,351 @@
+package queue
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/min1324/data/backoff"
+)
+
+// ringClosed enID的关闭标记，ring关闭后不能再EnQueue。
+const ringClosed = 1 << 32
+
+// lrRing LRQueue使用的lock-free环形数组。
+//
+// 每个slot有一个序号seq(Vyukov bounded MPMC queue)：
+// seq==ID，slot空，可以EnQueue第ID个值；
+// seq==ID+1，第ID个值已经写入，可以DeQueue；
+// DeQueue取出后seq=ID+cap，等待下一圈的EnQueue。
+// DeQueue清空值前，先把seq从ID+1换回ID，表示值正在取出；
+// 这时enID已经移过slot，EnQueue不会使用它。
+// 先cas移动enID(deID)独占slot，再读写值，最后原子写入seq发布，
+// EnQueue,DeQueue读写值都在seq的原子操作之间。
+// 值储存在单独分配的box中，slot原子保存指向box的指针，box写入后不再修改。
+// Peek和遍历不占用slot，不会让EnQueue,DeQueue等待：
+// 确认seq==ID+1后读取指针，再确认seq没有变化，读到的box就是第ID个值，见read。
+//
+// LRQueue扩容时，关闭当前ring，并在后面连接一个更大的ring。
+// 关闭和预留slot都是cas enID，所以关闭后不会再有新的值写入旧ring，
+// 旧ring的值都比新ring的值先入队，取完旧ring再取新ring，保持FIFO。
+//
+// enID只由EnQueue修改，deID只由DeQueue修改，两者和只读字段用cacheLinePad隔开，
+// 生产者和消费者修改各自的ID时，不会让对方缓存的ID和cap,mod,data失效。
+// 相邻的slot同样在一个缓存行，EnQueue写slot i时，DeQueue读slot i-1也会失效，
+// 设置padded后每个slot独占缓存行，见LRQueue.PadSlots。
+type lrRing[T any] struct {
+	// 低32位指向下次写入数据的位置:enID&mod
+	// ringClosed位表示ring已经关闭。
+	// 放在第一个，保证32位平台原子操作8字节对齐。
+	enID uint64
+	_    cacheLinePad
+	deID uint32 // 指向下次取出数据的位置:deID&mod
+	_    cacheLinePad
+
+	// 以下字段创建后只读，next只改变一次。
+	cap uint32 // ring容量，2^n
+	mod uint32 // cap-1,即2^n-1,用作取slot: data[(ID&mod)<<shift]
+
+	// shift slot的间隔，padded时相邻的slot至少相隔一个缓存行，否则为0。
+	shift uint32
+
+	// 环形队列，大小必须是2的倍数。
+	data []lrSlot[T]
+
+	// next 扩容后的ring,只能由nil变成非nil。
+	// 队列关闭时，最后一个ring的next变成closedNext。
+	next unsafe.Pointer
+
+	// bo 预留和占用slot的cas失败后的退避策略，来自LRQueue
+	bo backoff.Backoff
+}
+
+type lrSlot[T any] struct {
+	seq uint32
+	// val 指向储存值的*T，原子读写，空slot为nil。
+	val unsafe.Pointer
+}
+
+func newLRRing[T any](cap uint32, bo backoff.Backoff, padded bool) *lrRing[T] {
+	mod := modUint32(cap)
+	r := &lrRing[T]{
+		cap: mod + 1,
+		mod: mod,
+		bo:  bo,
+	}
+	if padded {
+		r.shift = slotShift[T]()
+	}
+	// 间隔中的slot不会使用，只占用空间。
+	r.data = make([]lrSlot[T], int(r.cap)<<r.shift)
+	for i := uint32(0); i < r.cap; i++ {
+		r.getSlot(i).seq = i
+	}
+	return r
+}
+
+// moveTo 将新建的空ring的enID,deID移到start，只能在ring发布前调用。
+func (r *lrRing[T]) moveTo(start uint32) {
+	r.enID = uint64(start)
+	r.deID = start
+	for i := uint32(0); i < r.cap; i++ {
+		r.getSlot(start + i).seq = start + i
+	}
+}
+
+// slotShift 让相邻的slot至少相隔一个缓存行的位移。
+func slotShift[T any]() uint32 {
+	size := unsafe.Sizeof(lrSlot[T]{})
+	var shift uint32
+	for size<<shift < cacheLineSize {
+		shift++
+	}
+	return shift
+}
+
+// 根据enID,deID获取进队，出队对应的slot
+func (r *lrRing[T]) getSlot(id uint32) *lrSlot[T] {
+	return &r.data[(id&r.mod)<<r.shift]
+}
+
+// loadNext 下一个ring,队列关闭后返回nil。
+func (r *lrRing[T]) loadNext() *lrRing[T] {
+	next := atomic.LoadPointer(&r.next)
+	if next == closedNext {
+		return nil
+	}
+	return (*lrRing[T])(next)
+}
+
+// 数量
+func (r *lrRing[T]) size() uint32 {
+	// 先读deID,保证enID-deID不会溢出
+	deID := atomic.LoadUint32(&r.deID)
+	return uint32(atomic.LoadUint64(&r.enID)) - deID
+}
+
+func (r *lrRing[T]) full() bool {
+	return r.size() >= r.cap
+}
+
+func (r *lrRing[T]) empty() bool {
+	return r.size() == 0
+}
+
+func (r *lrRing[T]) closed() bool {
+	return atomic.LoadUint64(&r.enID)&ringClosed != 0
+}
+
+// close 关闭ring，之后的EnQueue都失败。
+func (r *lrRing[T]) close() {
+	for {
+		enID := atomic.LoadUint64(&r.enID)
+		if enID&ringClosed != 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&r.enID, enID, enID|ringClosed) {
+			return
+		}
+	}
+}
+
+// drained ring已经关闭，并且关闭前写入的值都已经取出。
+func (r *lrRing[T]) drained() bool {
+	enID := atomic.LoadUint64(&r.enID)
+	return enID&ringClosed != 0 && uint32(enID) == atomic.LoadUint32(&r.deID)
+}
+
+// reserve 预留最多n个连续的空slot,返回起始enID和数量。
+// ring满了或者已经关闭，返回0。
+func (r *lrRing[T]) reserve(n int) (uint32, int) {
+	for i := 0; ; i++ {
+		word := atomic.LoadUint64(&r.enID)
+		if word&ringClosed != 0 {
+			return 0, 0
+		}
+		enID := uint32(word)
+		deID := atomic.LoadUint32(&r.deID)
+		want := n
+		if free := r.cap - (enID - deID); int(free) < want {
+			want = int(free)
+		}
+		if want <= 0 {
+			// queue full,
+			return 0, 0
+		}
+		// 只预留连续的空slot
+		m := 0
+		for ; m < want; m++ {
+			if atomic.LoadUint32(&r.getSlot(enID+uint32(m)).seq) != enID+uint32(m) {
+				break
+			}
+		}
+		if m == 0 {
+			if atomic.LoadUint64(&r.enID) == word {
+				// deID已经移过slot，DeQueue还没释放，很快完成。
+				runtime.Gosched()
+			}
+			continue
+		}
+		if atomic.CompareAndSwapUint64(&r.enID, word, uint64(enID+uint32(m))) {
+			// 成功获得[enID,enID+m)的slot
+			return enID, m
+		}
+		backoff.Wait(r.bo, i)
+	}
+}
+
+// publish 写入第id个值，并发布给DeQueue
+func (r *lrRing[T]) publish(id uint32, val T) {
+	slot := r.getSlot(id)
+	atomic.StorePointer(&slot.val, unsafe.Pointer(&val))
+	atomic.StoreUint32(&slot.seq, id+1)
+}
+
+func (r *lrRing[T]) enQueue(val T) bool {
+	enID, n := r.reserve(1)
+	if n == 0 {
+		return false
+	}
+	r.publish(enID, val)
+	return true
+}
+
+func (r *lrRing[T]) enQueueMany(vals []T) int {
+	enID, n := r.reserve(len(vals))
+	for i := 0; i < n; i++ {
+		r.publish(enID+uint32(i), vals[i])
+	}
+	return n
+}
+
+// acquire 独占最多n个连续已发布的slot，返回起始deID和数量。
+// ring空返回0。已经预留但还没发布的slot，等待EnQueue发布，
+// 保证Size()>0时DeQueue一定能取出。
+func (r *lrRing[T]) acquire(n int) (uint32, int) {
+	for i := 0; ; i++ {
+		deID := atomic.LoadUint32(&r.deID)
+		want := n
+		if size := uint32(atomic.LoadUint64(&r.enID)) - deID; int(size) < want {
+			want = int(size)
+		}
+		if want <= 0 {
+			// queue empty,
+			return 0, 0
+		}
+		m := 0
+		for ; m < want; m++ {
+			if atomic.LoadUint32(&r.getSlot(deID+uint32(m)).seq) != deID+uint32(m)+1 {
+				break
+			}
+		}
+		if m == 0 {
+			if atomic.LoadUint32(&r.deID) == deID {
+				// enID已经移过slot，EnQueue还没发布，很快完成。
+				runtime.Gosched()
+			}
+			continue
+		}
+		if casUint32(&r.deID, deID, deID+uint32(m)) {
+			// 成功获得[deID,deID+m)的slot
+			return deID, m
+		}
+		backoff.Wait(r.bo, i)
+	}
+}
+
+// release 取出第id个值，并释放slot给下一圈的EnQueue。
+// 先把seq换成id，正在read的Peek和遍历会作废读到的指针。
+// 只清空slot的指针，box不修改，已经读到它的Peek和遍历可以继续使用。
+func (r *lrRing[T]) release(id uint32) T {
+	slot := r.getSlot(id)
+	atomic.StoreUint32(&slot.seq, id)
+	p := atomic.SwapPointer(&slot.val, nil)
+	atomic.StoreUint32(&slot.seq, id+r.cap)
+	return *(*T)(p)
+}
+
+func (r *lrRing[T]) deQueue() (val T, ok bool) {
+	deID, n := r.acquire(1)
+	if n == 0 {
+		return
+	}
+	return r.release(deID), true
+}
+
+// peek 读取deID指向的值，不取出，也不占用slot。
+// 用read读取值后，deID没有变化才返回；否则值已经被DeQueue取走，重试。
+func (r *lrRing[T]) peek() (val T, ok bool) {
+	for {
+		deID := atomic.LoadUint32(&r.deID)
+		if uint32(atomic.LoadUint64(&r.enID)) == deID {
+			// queue empty,
+			return
+		}
+		if val, ok = r.read(deID); ok && atomic.LoadUint32(&r.deID) == deID {
+			return val, true
+		}
+		if atomic.LoadUint32(&r.deID) == deID {
+			// enID已经移过slot，EnQueue还没发布，很快完成。
+			runtime.Gosched()
+		}
+	}
+}
+
+// read 读取第id个值，不占用slot，不会让EnQueue,DeQueue等待。
+// 先确认seq==id+1，即值已经发布还没取出，读取指针后再确认seq没有变化：
+// DeQueue清空指针之前先把seq换成id，下一圈的EnQueue写入前seq已经是id+cap，
+// seq没有变化，说明读到的是第id个值的box。box不会修改，读取不需要同步。
+// 还没发布、正在取出或者已经取出，返回false。
+func (r *lrRing[T]) read(id uint32) (val T, ok bool) {
+	slot := r.getSlot(id)
+	if atomic.LoadUint32(&slot.seq) != id+1 {
+		return
+	}
+	p := atomic.LoadPointer(&slot.val)
+	if p == nil || atomic.LoadUint32(&slot.seq) != id+1 {
+		return
+	}
+	return *(*T)(p), true
+}
+
+// walk 按出队顺序读取[from,end)中还没出队的值，f返回false时停止并返回false。
+// end不能超过读取时的enID。每读一个slot都重新读取deID，跳过已经出队的slot，
+// 正在取出或者已经进入下一圈的slot也跳过。
+// 已经预留还没发布的slot(seq==id，deID没有移过)等待EnQueue发布，
+// 保证同一个生产者先入队的值不会被漏掉；这个状态很快结束，不会一直等待。
+func (r *lrRing[T]) walk(from, end uint32, f func(v T) bool) bool {
+	for id := from; int32(end-id) > 0; id++ {
+		for {
+			if deID := atomic.LoadUint32(&r.deID); int32(deID-id) > 0 {
+				// 已经出队
+				break
+			}
+			if val, ok := r.read(id); ok {
+				if !f(val) {
+					return false
+				}
+				break
+			}
+			if atomic.LoadUint32(&r.getSlot(id).seq) != id {
+				// 正在取出，或者已经进入下一圈
+				break
+			}
+			// enID已经移过slot，EnQueue还没发布，很快完成。
+			runtime.Gosched()
+		}
+		if deID := atomic.LoadUint32(&r.deID); int32(deID-id) > 1 {
+			// 跳到deID
+			id = deID - 1
+		}
+	}
+	return true
+}
+
+func (r *lrRing[T]) deQueueMany(dst []T) int {
+	deID, n := r.acquire(len(dst))
+	for i := 0; i < n; i++ {
+		dst[i] = r.release(deID + uint32(i))
+	}
+	return n
+}
@@ -0,0 +1,309 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultShards ShardQueue默认路数
+const DefaultShards = 1 << 3
+
+// Shard ShardQueue每一路需要的方法，DataQueue和Chain都满足。
+//
+// 还有Cap() int,Full() bool,Empty() bool,Close()时使用这些方法；
+// 没有Cap时当作无界队列，没有Full时不会满，没有Empty时用Size()==0判断。
+// 有一路没有Close时，ShardQueue自己拦截关闭后的EnQueue。
+type Shard[T any] interface {
+	Queue[T]
+	Peek() (val T, ok bool)
+	// Range 按出队顺序遍历，不取出，f返回false时停止。
+	Range(f func(v T) bool)
+	Init()
+	Size() int
+}
+
+// ShardQueue is an n-way sharded queue with relaxed FIFO order.
+//
+// n路队列，每一路都是一个独立的Shard，由newQueue创建，
+// 可以是LRQueue,SLQueue,Chain等任意队列，默认使用LLQueue。
+// EnQueue,DeQueue通过enID,deID两个游标轮询各路，每次FAA移动游标，
+// 不同goroutine分散到不同的队列，降低了每一路队列的并发粒度。
+//
+// 顺序是宽松的FIFO：同一路内保持FIFO；
+// 单个goroutine顺序操作，并且没有一路满了或者空了时，整体也是FIFO。
+// 并发时，或者某一路满(空)了跳到下一路后，游标不再对齐，
+// 后加入的值可能先取出，但不会丢失。
+type ShardQueue[T any] struct {
+	once sync.Once
+
+	mod uint32
+
+	// enID,deID 轮询游标，指向下次操作的队列:ID&mod
+	enID uint32
+	_    cacheLinePad
+	deID uint32
+	_    cacheLinePad
+
+	shards   []Shard[T]
+	newQueue func() Shard[T]
+
+	// closable 每一路都有Close，关闭时关闭每一路；
+	// 否则EnQueue期间entering不为0，seal等待它们完成。
+	closable bool
+	entering int32
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待,满时EnQueueCtx在notFull等待
+	notEmpty notifier
+	notFull  notifier
+}
+
+func (q *ShardQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init(DefaultShards, nil)
+	})
+}
+
+// init 创建shards路队列，shards向上调整至2^n。
+func (q *ShardQueue[T]) init(shards int, newQueue func() Shard[T]) {
+	if shards < 1 {
+		shards = DefaultShards
+	}
+	if newQueue == nil {
+		newQueue = func() Shard[T] { return &LLQueue[T]{} }
+	}
+	q.mod = modUint32(uint32(shards))
+	q.shards = make([]Shard[T], q.mod+1)
+	q.closable = true
+	for i := range q.shards {
+		q.shards[i] = newQueue()
+		if _, ok := q.shards[i].(interface{ Close() }); !ok {
+			q.closable = false
+		}
+	}
+	q.newQueue = newQueue
+	atomic.StoreUint32(&q.deID, atomic.LoadUint32(&q.enID))
+}
+
+// Init 清空每一路队列，并且对齐游标。
+func (q *ShardQueue[T]) Init() {
+	q.onceInit()
+	for _, s := range q.shards {
+		s.Init()
+	}
+	atomic.StoreUint32(&q.deID, atomic.LoadUint32(&q.enID))
+}
+
+// InitWith 重新创建shards路由newQueue生成的队列，原来的值被丢弃。
+// shards<1时使用DefaultShards,newQueue为nil时使用原来的设置，默认LLQueue。
+// 不能和其他操作并发调用。
+func (q *ShardQueue[T]) InitWith(shards int, newQueue func() Shard[T]) {
+	q.onceInit()
+	if newQueue == nil {
+		newQueue = q.newQueue
+	}
+	q.init(shards, newQueue)
+	if q.cl.isClosed() {
+		// Init不会重新打开队列
+		q.seal()
+	}
+}
+
+func (q *ShardQueue[T]) shard(id uint32) Shard[T] {
+	return q.shards[id&q.mod]
+}
+
+// EnQueue 加入enID指向的队列，满了或者失败时依次尝试下一路。
+func (q *ShardQueue[T]) EnQueue(val T) bool {
+	q.onceInit()
+	if !q.closable {
+		// 先登记再检查closed，seal标记closed后等待entering为0，
+		// 两边都是原子操作，至少有一边看到对方。
+		atomic.AddInt32(&q.entering, 1)
+		defer atomic.AddInt32(&q.entering, -1)
+		if q.cl.isClosed() {
+			return false
+		}
+	}
+	for i := uint32(0); i <= q.mod; i++ {
+		id := atomic.AddUint32(&q.enID, 1) - 1
+		if q.shard(id).EnQueue(val) {
+			q.notEmpty.signal()
+			return true
+		}
+	}
+	return false
+}
+
+// DeQueue 从deID指向的队列取出，空了时依次尝试下一路。
+// 所有队列都空时，游标移动了一整圈，依旧对齐。
+func (q *ShardQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	for i := uint32(0); i <= q.mod; i++ {
+		id := atomic.AddUint32(&q.deID, 1) - 1
+		if val, ok = q.shard(id).DeQueue(); ok {
+			q.notFull.signal()
+			return
+		}
+	}
+	return
+}
+
+// Peek 按DeQueue的轮询顺序，返回第一个不空的队列的队头，不移动游标。
+// 并发时每一路各自Peek，返回的值在读取时还在队列中，但不一定是下一个DeQueue取出的值。
+func (q *ShardQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	deID := atomic.LoadUint32(&q.deID)
+	for i := uint32(0); i <= q.mod; i++ {
+		if val, ok = q.shard(deID + i).Peek(); ok {
+			return
+		}
+	}
+	return
+}
+
+// EnQueueMany 按轮询顺序逐个加入，不会把一批值放进同一路。
+func (q *ShardQueue[T]) EnQueueMany(vals []T) (n int) {
+	for ; n < len(vals); n++ {
+		if !q.EnQueue(vals[n]) {
+			break
+		}
+	}
+	return n
+}
+
+func (q *ShardQueue[T]) DeQueueMany(dst []T) (n int) {
+	for ; n < len(dst); n++ {
+		val, ok := q.DeQueue()
+		if !ok {
+			break
+		}
+		dst[n] = val
+	}
+	return n
+}
+
+// Cap 所有队列容量的和，不超过queueLimit，没有Cap的队列当作queueLimit。
+func (q *ShardQueue[T]) Cap() int {
+	q.onceInit()
+	var c int
+	for _, s := range q.shards {
+		cs, ok := s.(interface{ Cap() int })
+		if !ok {
+			return queueLimit
+		}
+		c += cs.Cap()
+		if c >= queueLimit {
+			return queueLimit
+		}
+	}
+	return c
+}
+
+// 数量，并发时只是近似值。
+func (q *ShardQueue[T]) Size() int {
+	q.onceInit()
+	var n int
+	for _, s := range q.shards {
+		n += s.Size()
+	}
+	return n
+}
+
+func (q *ShardQueue[T]) Full() bool {
+	q.onceInit()
+	for _, s := range q.shards {
+		fs, ok := s.(interface{ Full() bool })
+		if !ok || !fs.Full() {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ShardQueue[T]) Empty() bool {
+	q.onceInit()
+	for _, s := range q.shards {
+		if !shardEmpty(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func shardEmpty[T any](s Shard[T]) bool {
+	if es, ok := s.(interface{ Empty() bool }); ok {
+		return es.Empty()
+	}
+	return s.Size() == 0
+}
+
+// snapshot 按DeQueue的轮询顺序合并每一路的快照：
+// 从deID指向的队列开始，每一路轮流取一个，空了的跳过。
+func (q *ShardQueue[T]) snapshot() []T {
+	parts := make([][]T, len(q.shards))
+	var n int
+	for i, s := range q.shards {
+		s.Range(func(v T) bool {
+			parts[i] = append(parts[i], v)
+			return true
+		})
+		n += len(parts[i])
+	}
+	vals := make([]T, 0, n)
+	for id := atomic.LoadUint32(&q.deID); len(vals) < n; id++ {
+		if p := &parts[id&q.mod]; len(*p) > 0 {
+			vals = append(vals, (*p)[0])
+			*p = (*p)[1:]
+		}
+	}
+	return vals
+}
+
+// Close 关闭每一路队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+func (q *ShardQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(q.seal) {
+		q.notEmpty.signal()
+		q.notFull.signal()
+	}
+}
+
+// seal 关闭每一路队列；有一路不能关闭时，等待标记closed前开始的EnQueue完成。
+func (q *ShardQueue[T]) seal() {
+	if !q.closable {
+		for atomic.LoadInt32(&q.entering) != 0 {
+			runtime.Gosched()
+		}
+		return
+	}
+	for _, s := range q.shards {
+		s.(interface{ Close() }).Close()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *ShardQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *ShardQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
+
+// EnQueueCtx 所有队列都满时阻塞等待，直到入队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭返回ErrClosed。
+func (q *ShardQueue[T]) EnQueueCtx(ctx context.Context, val T) error {
+	return enQueueCtx[T](ctx, q.EnQueue, &q.notFull, &q.cl, val)
+}
+
+// DeQueueCtx 所有队列都空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *ShardQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
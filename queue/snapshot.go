@@ -0,0 +1,311 @@
+package queue
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/min1324/data/codec"
+)
+
+// ErrFull 恢复快照时队列容量不够。
+var ErrFull = errors.New("queue: full")
+
+// Snapshot 按出队顺序返回q中所有值，不会取出。
+//
+// 只有锁队列(SAQueue,SRQueue,DRQueue,SLQueue,DLQueue)的快照是某一时刻的完整状态，
+// 快照持有锁，和其他操作是线性一致的。
+//
+// 其他队列不能给出某一时刻的快照，并发修改时得到的序列队列可能从来没有处于过：
+// LLQueue,LRQueue,LCRQueue的快照是弱一致的遍历，见各自的Range，
+// 可以和任意操作并发，不会等待DeQueue，读取期间出队的值跳过，入队的值可能包含也可能不包含。
+// WLQueue沿着next读取哨兵之后的node，node的值写入后不再修改，一致性和LLQueue相同。
+// ShardQueue分别读取每一路，多路之间不是同一时刻。
+// SPRQueue只能由消费者调用，和生产者并发时只缺少读取期间入队的值。
+// 需要某一时刻的状态时，先停止这些队列的所有操作，或者使用锁队列。
+func Snapshot[T any](q DataQueue[T]) []T {
+	q.onceInit()
+	return q.snapshot()
+}
+
+// Restore 清空q，再按顺序加入vals，可以恢复到任意DataQueue。
+// q容量不够时只加入前面的一部分，返回ErrFull；q已经关闭返回ErrClosed。
+// 恢复不是原子操作，不应该和其他操作并发调用。
+func Restore[T any](q DataQueue[T], vals []T) error {
+	q.Init()
+	if n := q.EnQueueMany(vals); n < len(vals) {
+		if isClosed[T](q) {
+			return ErrClosed
+		}
+		return ErrFull
+	}
+	return nil
+}
+
+// Marshal 将q的快照按出队顺序编码，每个值由c编码，格式见codec包。
+// 快照的一致性见Snapshot。
+func Marshal[T any](q DataQueue[T], c codec.Codec[T]) ([]byte, error) {
+	return codec.EncodeSlice(Snapshot(q), c)
+}
+
+// Unmarshal 解码Marshal生成的数据，恢复到q，见Restore。
+func Unmarshal[T any](q DataQueue[T], data []byte, c codec.Codec[T]) error {
+	vals, err := codec.DecodeSlice(data, c)
+	if err != nil {
+		return err
+	}
+	return Restore(q, vals)
+}
+
+// MarshalJSON 将q的快照按出队顺序编码成json数组，每个元素由c编码，c的输出必须是json，见codec.EncodeJSON。
+// 快照的一致性见Snapshot。
+func MarshalJSON[T any](q DataQueue[T], c codec.Codec[T]) ([]byte, error) {
+	return codec.EncodeJSON(Snapshot(q), c)
+}
+
+// UnmarshalJSON 解码MarshalJSON生成的json数组，每个元素由c解码，恢复到q，见Restore。
+func UnmarshalJSON[T any](q DataQueue[T], data []byte, c codec.Codec[T]) error {
+	vals, err := codec.DecodeJSON(data, c)
+	if err != nil {
+		return err
+	}
+	return Restore(q, vals)
+}
+
+// 各个队列的encoding.BinaryMarshaler和json.Marshaler使用下面的默认codec：
+// 二进制格式的值由codec.Gob编码，json格式的值由codec.JSON编码，是按出队顺序的数组。
+// 其他codec使用Marshal,Unmarshal和MarshalJSON,UnmarshalJSON。
+// 快照的一致性见Snapshot，不是锁队列时，并发修改中编码的可能是队列从来没有处于过的状态。
+
+func marshalBinary[T any](q DataQueue[T]) ([]byte, error) {
+	return Marshal[T](q, codec.Gob[T]{})
+}
+
+func unmarshalBinary[T any](q DataQueue[T], data []byte) error {
+	return Unmarshal[T](q, data, codec.Gob[T]{})
+}
+
+func marshalJSON[T any](q DataQueue[T]) ([]byte, error) {
+	return MarshalJSON[T](q, codec.JSON[T]{})
+}
+
+func unmarshalJSON[T any](q DataQueue[T], data []byte) error {
+	return UnmarshalJSON[T](q, data, codec.JSON[T]{})
+}
+
+// ---------------------------		mutex queue		-----------------------------//
+
+func (q *SAQueue[T]) snapshot() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append(make([]T, 0, len(q.data)), q.data...)
+}
+
+func (q *SRQueue[T]) snapshot() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vals := make([]T, 0, q.enID-q.deID)
+	for id := q.deID; id != q.enID; id++ {
+		val, _ := q.getSlot(id).load()
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// snapshot 持有两个锁，enID之前的slot都已经写入。
+func (q *DRQueue[T]) snapshot() []T {
+	q.enMu.Lock()
+	defer q.enMu.Unlock()
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+	vals := make([]T, 0, q.enID-q.deID)
+	for id := q.deID; id != q.enID; id++ {
+		val, _ := q.getSlot(id).load()
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+func (q *SLQueue[T]) snapshot() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vals := make([]T, 0, q.len)
+	for n := q.head; n != q.tail; n = n.next {
+		val, _ := n.load()
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+func (q *DLQueue[T]) snapshot() []T {
+	q.enMu.Lock()
+	defer q.enMu.Unlock()
+	q.deMu.Lock()
+	defer q.deMu.Unlock()
+	vals := make([]T, 0, q.len)
+	for n := q.head; n != q.tail; n = n.next {
+		val, _ := n.load()
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// ---------------------------		lock-free queue		-----------------------------//
+
+func (q *LLQueue[T]) snapshot() []T {
+	vals := make([]T, 0, q.Size())
+	q.Range(func(v T) bool {
+		vals = append(vals, v)
+		return true
+	})
+	return vals
+}
+
+// snapshot 收集弱一致遍历的值，见LRQueue.Range。
+func (q *LRQueue[T]) snapshot() []T {
+	vals := make([]T, 0, q.Size())
+	q.Range(func(v T) bool {
+		vals = append(vals, v)
+		return true
+	})
+	return vals
+}
+
+// walk 从head开始，依次按出队顺序读取每个ring的值，f返回false时停止。
+//
+// enID在开始读取ring时确定，读完后如果已经连接了下一个ring，
+// 旧ring已经关闭，enID不会再变化，再读取期间新写入的值，
+// 否则可能漏掉旧ring的值，却读到之后写入新ring的值。
+func (q *LRQueue[T]) walk(f func(v T) bool) {
+	for r := q.loadHead(); r != nil; {
+		from, end := atomic.LoadUint32(&r.deID), uint32(atomic.LoadUint64(&r.enID))
+		if !r.walk(from, end, f) {
+			return
+		}
+		next := r.loadNext()
+		if next == nil {
+			return
+		}
+		if !r.walk(end, uint32(atomic.LoadUint64(&r.enID)), f) {
+			return
+		}
+		r = next
+	}
+}
+
+// ---------------------------		Range		-----------------------------//
+
+// Range 从head开始按出队顺序遍历，f返回false时停止。
+//
+// 弱一致的遍历：不加锁也不复制，可以和任意操作并发。
+// 和DeQueue一样，遇到EnQueue还没储存完成的node停止。
+// 遍历开始时已经在队列中，并且直到遍历结束都没有出队的值，按顺序恰好访问一次；
+// 遍历期间入队或者出队的值，可能访问也可能不访问，但不会重复。
+// 遍历期间退休的node不会回收复用，由GC回收，所以f不宜执行太久。
+func (q *LLQueue[T]) Range(f func(v T) bool) {
+	q.onceInit()
+	q.rc.Hold()
+	defer q.rc.Release()
+	// node只会在尾部连接next，退休后在release前也不会清空，
+	// 所以从某一时刻的head出发，沿着next读到的都是按入队顺序的值。
+	for p := atomic.LoadPointer(&q.head); p != nil && p != closedNext; p = loadNext[T](p) {
+		val, ok := (*ptrNode[T])(p).load()
+		if !ok || !f(val) {
+			return
+		}
+	}
+}
+
+// Range 从head开始按出队顺序遍历，f返回false时停止。
+//
+// 弱一致的遍历：不加锁也不复制，不占用slot，可以和任意操作并发。
+// 遍历开始时已经在队列中，并且直到遍历结束都没有出队的值，按顺序恰好访问一次；
+// 遍历期间入队或者出队的值，可能访问也可能不访问，但不会重复。
+// 已经预留还没发布的slot等待EnQueue发布，见lrRing.walk。
+func (q *LRQueue[T]) Range(f func(v T) bool) {
+	q.onceInit()
+	q.walk(f)
+}
+
+// Range 从head开始按ID顺序遍历，f返回false时停止。
+//
+// 弱一致的遍历，和LRQueue.Range相同，见crq.walk。
+// FAA之后还没写入的EnQueue可能在遍历过的较小ID上写入，这些值不一定访问到。
+func (q *LCRQueue[T]) Range(f func(v T) bool) {
+	q.onceInit()
+	q.walk(f)
+}
+
+// 其他队列的Range遍历Snapshot返回的副本，一致性和Snapshot相同：
+// 锁队列是持有锁复制的某一时刻状态，遍历时不再持有锁，f可以操作队列。
+
+func rangeSlice[T any](vals []T, f func(v T) bool) {
+	for _, v := range vals {
+		if !f(v) {
+			return
+		}
+	}
+}
+
+func (q *SAQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *SRQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *DRQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *SLQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *DLQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *WLQueue[T]) Range(f func(v T) bool)    { rangeSlice(q.Snapshot(), f) }
+func (q *ShardQueue[T]) Range(f func(v T) bool) { rangeSlice(q.Snapshot(), f) }
+
+// Snapshot 按出队顺序返回所有值，见包函数Snapshot。
+
+func (q *SAQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *SRQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *DRQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *SLQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *DLQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *LLQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *LRQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *LCRQueue[T]) Snapshot() []T   { return Snapshot[T](q) }
+func (q *WLQueue[T]) Snapshot() []T    { return Snapshot[T](q) }
+func (q *ShardQueue[T]) Snapshot() []T { return Snapshot[T](q) }
+
+// ---------------------------		encoding		-----------------------------//
+
+func (q *SAQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *SAQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *SAQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *SAQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *SRQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *SRQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *SRQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *SRQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *DRQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *DRQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *DRQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *DRQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *SLQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *SLQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *SLQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *SLQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *DLQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *DLQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *DLQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *DLQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *LLQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *LLQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *LLQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *LLQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *LRQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *LRQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *LRQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *LRQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *LCRQueue[T]) MarshalBinary() ([]byte, error)      { return marshalBinary[T](q) }
+func (q *LCRQueue[T]) UnmarshalBinary(data []byte) error   { return unmarshalBinary[T](q, data) }
+func (q *LCRQueue[T]) MarshalJSON() ([]byte, error)        { return marshalJSON[T](q) }
+func (q *LCRQueue[T]) UnmarshalJSON(data []byte) error     { return unmarshalJSON[T](q, data) }
+func (q *WLQueue[T]) MarshalBinary() ([]byte, error)       { return marshalBinary[T](q) }
+func (q *WLQueue[T]) UnmarshalBinary(data []byte) error    { return unmarshalBinary[T](q, data) }
+func (q *WLQueue[T]) MarshalJSON() ([]byte, error)         { return marshalJSON[T](q) }
+func (q *WLQueue[T]) UnmarshalJSON(data []byte) error      { return unmarshalJSON[T](q, data) }
+func (q *ShardQueue[T]) MarshalBinary() ([]byte, error)    { return marshalBinary[T](q) }
+func (q *ShardQueue[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](q, data) }
+func (q *ShardQueue[T]) MarshalJSON() ([]byte, error)      { return marshalJSON[T](q) }
+func (q *ShardQueue[T]) UnmarshalJSON(data []byte) error   { return unmarshalJSON[T](q, data) }
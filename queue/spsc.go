@@ -0,0 +1,278 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SPRQueue is a wait-free bounded ring queue for a single producer and a single consumer.
+//
+// 单生产者单消费者环形队列，只用原子读写，没有CAS和锁，EnQueue,DeQueue都是wait-free。
+// 同一时刻只能有一个goroutine EnQueue，一个goroutine DeQueue，
+// 多个生产者或者消费者需要使用LRQueue等MPMC队列。
+// 生产者调用EnQueue,EnQueueMany,EnQueueCtx,Close；
+// 消费者调用DeQueue,DeQueueMany,DeQueueCtx,Peek,Range,Snapshot,MarshalBinary,MarshalJSON；
+// Full,Empty,Size,Cap,Done可以由任意goroutine调用。
+//
+// enID只由生产者写入，deID只由消费者写入，slot先读写，后原子移动ID发布。
+// 生产者缓存了deID(deCache)，只有缓存显示满时才重新读取deID，
+// 消费者同样缓存了enID(enCache)，减少读取对方的缓存行。
+// 生产者和消费者的字段用cacheLinePad隔开，避免伪共享。
+type SPRQueue[T any] struct {
+	once sync.Once
+
+	cap  uint32
+	mod  uint32
+	data []T
+
+	_ cacheLinePad
+	// 生产者独占
+	enID    uint32 // 指向下次写入数据的位置:enID&mod
+	deCache uint32 // 生产者缓存的deID
+	_       cacheLinePad
+	// 消费者独占
+	deID    uint32 // 指向下次取出数据的位置:deID&mod
+	enCache uint32 // 消费者缓存的enID
+	_       cacheLinePad
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+	// 队列满时EnQueueCtx在notFull等待
+	notFull notifier
+}
+
+func (q *SPRQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init()
+	})
+}
+
+func (q *SPRQueue[T]) init() {
+	if q.cap < 1 {
+		q.cap = DefauleSize
+	}
+	if q.cap > queueLimit {
+		q.cap = queueLimit
+	}
+	q.mod = modUint32(q.cap)
+	q.cap = q.mod + 1
+	q.data = make([]T, q.cap)
+	atomic.StoreUint32(&q.enID, 0)
+	atomic.StoreUint32(&q.deID, 0)
+	q.deCache = 0
+	q.enCache = 0
+}
+
+// Init 清空队列，不能和EnQueue,DeQueue并发调用。
+func (q *SPRQueue[T]) Init() {
+	q.InitWith()
+}
+
+// InitWith 初始化长度为cap的queue,不能和EnQueue,DeQueue并发调用。
+// 如果未提供，则使用默认值: DefaultSize
+func (q *SPRQueue[T]) InitWith(caps ...int) {
+	q.onceInit()
+	if len(caps) > 0 && caps[0] > 0 {
+		q.cap = uint32(caps[0])
+	}
+	q.init()
+	q.notFull.signal()
+}
+
+// InitAt 清空队列，enID,deID从id开始，容量不变，不能和EnQueue,DeQueue并发调用。
+// ID回绕前后行为相同，InitAt从回绕点附近开始，用来验证长时间运行后的状态。
+func (q *SPRQueue[T]) InitAt(id uint32) {
+	q.onceInit()
+	q.init()
+	atomic.StoreUint32(&q.enID, id)
+	atomic.StoreUint32(&q.deID, id)
+	q.deCache, q.enCache = id, id
+	q.notFull.signal()
+}
+
+func (q *SPRQueue[T]) Cap() int {
+	q.onceInit()
+	return int(q.cap)
+}
+
+// Full,Empty,Size可以由任意goroutine调用，原子读取ID
+func (q *SPRQueue[T]) Full() bool {
+	return q.Size() >= q.Cap()
+}
+
+func (q *SPRQueue[T]) Empty() bool {
+	return q.Size() == 0
+}
+
+func (q *SPRQueue[T]) Size() int {
+	// 先读deID,保证enID-deID不会溢出
+	deID := atomic.LoadUint32(&q.deID)
+	return int(atomic.LoadUint32(&q.enID) - deID)
+}
+
+// free 生产者可以写入的数量，至少need个时不读取deID。
+func (q *SPRQueue[T]) free(enID uint32, need int) int {
+	n := int(q.cap - (enID - q.deCache))
+	if n < need {
+		q.deCache = atomic.LoadUint32(&q.deID)
+		n = int(q.cap - (enID - q.deCache))
+	}
+	return n
+}
+
+// stored 消费者可以取出的数量，至少need个时不读取enID。
+func (q *SPRQueue[T]) stored(deID uint32, need int) int {
+	n := int(q.enCache - deID)
+	if n < need {
+		q.enCache = atomic.LoadUint32(&q.enID)
+		n = int(q.enCache - deID)
+	}
+	return n
+}
+
+// EnQueue 只能由生产者调用
+func (q *SPRQueue[T]) EnQueue(val T) bool {
+	q.onceInit()
+	if q.cl.isClosed() {
+		return false
+	}
+	enID := q.enID
+	if q.free(enID, 1) < 1 {
+		// queue full,
+		return false
+	}
+	q.data[enID&q.mod] = val
+	atomic.StoreUint32(&q.enID, enID+1)
+	q.notEmpty.signal()
+	return true
+}
+
+// DeQueue 只能由消费者调用
+func (q *SPRQueue[T]) DeQueue() (val T, ok bool) {
+	q.onceInit()
+	deID := q.deID
+	if q.stored(deID, 1) < 1 {
+		// queue empty,
+		return
+	}
+	slot := &q.data[deID&q.mod]
+	val = *slot
+	var zero T
+	*slot = zero
+	atomic.StoreUint32(&q.deID, deID+1)
+	q.notFull.signal()
+	return val, true
+}
+
+// Peek 返回队头的值，不取出。只能由消费者调用
+func (q *SPRQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	deID := q.deID
+	if q.stored(deID, 1) < 1 {
+		// queue empty,
+		return
+	}
+	return q.data[deID&q.mod], true
+}
+
+// EnQueueMany 写入连续的slot，一次移动enID。只能由生产者调用
+func (q *SPRQueue[T]) EnQueueMany(vals []T) (n int) {
+	q.onceInit()
+	if len(vals) == 0 || q.cl.isClosed() {
+		return
+	}
+	enID := q.enID
+	n = q.free(enID, len(vals))
+	if n > len(vals) {
+		n = len(vals)
+	}
+	for i := 0; i < n; i++ {
+		q.data[(enID+uint32(i))&q.mod] = vals[i]
+	}
+	if n > 0 {
+		atomic.StoreUint32(&q.enID, enID+uint32(n))
+		q.notEmpty.signal()
+	}
+	return n
+}
+
+// DeQueueMany 取出连续的slot，一次移动deID。只能由消费者调用
+func (q *SPRQueue[T]) DeQueueMany(dst []T) (n int) {
+	q.onceInit()
+	if len(dst) == 0 {
+		return
+	}
+	deID := q.deID
+	n = q.stored(deID, len(dst))
+	if n > len(dst) {
+		n = len(dst)
+	}
+	var zero T
+	for i := 0; i < n; i++ {
+		slot := &q.data[(deID+uint32(i))&q.mod]
+		dst[i] = *slot
+		*slot = zero
+	}
+	if n > 0 {
+		atomic.StoreUint32(&q.deID, deID+uint32(n))
+		q.notFull.signal()
+	}
+	return n
+}
+
+// snapshot 读取[deID,enID)的值，只能由消费者调用。
+func (q *SPRQueue[T]) snapshot() []T {
+	deID := q.deID
+	enID := atomic.LoadUint32(&q.enID)
+	vals := make([]T, 0, enID-deID)
+	for id := deID; id != enID; id++ {
+		vals = append(vals, q.data[id&q.mod])
+	}
+	return vals
+}
+
+// Range 按出队顺序遍历[deID,enID)的副本，f返回false时停止。
+// 只能由消费者调用，不能和DeQueue并发，见snapshot。
+func (q *SPRQueue[T]) Range(f func(v T) bool) { rangeSlice(q.Snapshot(), f) }
+
+// Snapshot 按出队顺序返回所有值，见包函数Snapshot。
+// 只能由消费者调用，不能和DeQueue并发；和EnQueue并发时不包含读取期间入队的值。
+func (q *SPRQueue[T]) Snapshot() []T { return Snapshot[T](q) }
+
+// MarshalBinary 编码Snapshot，只能由消费者调用，见Snapshot。
+func (q *SPRQueue[T]) MarshalBinary() ([]byte, error) { return marshalBinary[T](q) }
+
+// UnmarshalBinary 恢复快照，调用Init，不能和EnQueue,DeQueue并发调用。
+func (q *SPRQueue[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](q, data) }
+
+// MarshalJSON 编码Snapshot，只能由消费者调用，见Snapshot。
+func (q *SPRQueue[T]) MarshalJSON() ([]byte, error) { return marshalJSON[T](q) }
+
+// UnmarshalJSON 恢复快照，调用Init，不能和EnQueue,DeQueue并发调用。
+func (q *SPRQueue[T]) UnmarshalJSON(data []byte) error { return unmarshalJSON[T](q, data) }
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+//
+// 只能由生产者调用：EnQueue不登记也不检查锁，Close无法等待正在进行的EnQueue，
+// 由生产者调用时关闭前的EnQueue都已经完成。其他goroutine调用时，
+// 并发的EnQueue可能在关闭之后写入，DeQueueCtx可能在这个值写入前就返回ErrClosed。
+func (q *SPRQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(func() {}) {
+		q.notEmpty.signal()
+		q.notFull.signal()
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *SPRQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+func (q *SPRQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.Empty()
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// notifier 唤醒等待队列状态变化的goroutine，零值可用。
+//
+// 等待者先wait登记并拿到当前的channel，再重试一次操作，失败才阻塞在channel上。
+// 通知者先完成操作，再检查是否有等待者，有则关闭channel唤醒所有等待者。
+// 登记和检查都是原子操作，等待者的重试和通知者的检查至少有一个能看到对方，
+// 所以不会丢失唤醒。没有等待者时，通知只需要一次原子读。
+type notifier struct {
+	waiters int32
+	mu      sync.Mutex
+	ch      chan struct{}
+}
+
+// wait 登记等待，返回的channel在下次signal时关闭。
+// 之后必须调用done取消登记。
+func (n *notifier) wait() <-chan struct{} {
+	atomic.AddInt32(&n.waiters, 1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) done() {
+	atomic.AddInt32(&n.waiters, -1)
+}
+
+// signal 唤醒所有等待者
+func (n *notifier) signal() {
+	if atomic.LoadInt32(&n.waiters) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// enQueueCtx 队列满时阻塞，直到enQueue成功、ctx结束或者队列关闭。
+// enQueue是队列满时返回false的EnQueue，不使用SetOverflow设置的处理方式。
+func enQueueCtx[T any](ctx context.Context, enQueue func(val T) bool, notFull *notifier, cl *closer, val T) error {
+	for {
+		if enQueue(val) {
+			return nil
+		}
+		if cl.isClosed() {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ch := notFull.wait()
+		if enQueue(val) {
+			notFull.done()
+			return nil
+		}
+		if cl.isClosed() {
+			notFull.done()
+			return ErrClosed
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+		notFull.done()
+	}
+}
+
+// deQueueCtx 队列空时阻塞，直到DeQueue成功、ctx结束或者队列关闭并且取完。
+// drained为true表示队列已经关闭，并且不会再有值可以取出。
+func deQueueCtx[T any](ctx context.Context, q Queue[T], notEmpty *notifier, drained func() bool) (val T, err error) {
+	for {
+		if val, ok := q.DeQueue(); ok {
+			return val, nil
+		}
+		if drained() {
+			return val, ErrClosed
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		ch := notEmpty.wait()
+		if val, ok := q.DeQueue(); ok {
+			notEmpty.done()
+			return val, nil
+		}
+		if drained() {
+			notEmpty.done()
+			return val, ErrClosed
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+		notEmpty.done()
+	}
+}
+
+// EnQueueCtx 队列满时阻塞等待，直到入队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭返回ErrClosed。
+func (q *SRQueue[T]) EnQueueCtx(ctx context.Context, val T) error {
+	return enQueueCtx[T](ctx, q.enQueue, &q.notFull, &q.cl, val)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *SRQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// EnQueueCtx 队列满时阻塞等待，直到入队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭返回ErrClosed。
+func (q *DRQueue[T]) EnQueueCtx(ctx context.Context, val T) error {
+	return enQueueCtx[T](ctx, q.enQueue, &q.notFull, &q.cl, val)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *DRQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// EnQueueCtx 队列满时阻塞等待，直到入队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭返回ErrClosed。
+func (q *LRQueue[T]) EnQueueCtx(ctx context.Context, val T) error {
+	return enQueueCtx[T](ctx, q.enQueue, &q.notFull, &q.cl, val)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *LRQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *SAQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *SLQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *DLQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *LLQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
+
+// EnQueueCtx 队列满时阻塞等待，直到入队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭返回ErrClosed。只能由生产者调用。
+func (q *SPRQueue[T]) EnQueueCtx(ctx context.Context, val T) error {
+	return enQueueCtx[T](ctx, q.EnQueue, &q.notFull, &q.cl, val)
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。只能由消费者调用。
+func (q *SPRQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
@@ -0,0 +1,479 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// WLQueue is an unbounded wait-free linked list queue.
+//
+// Kogan & Petrank的wait-free队列。每个操作先取得一个phase，发布操作描述(wlDesc)，
+// 然后帮助所有phase不大于自己的操作完成，最后才完成自己的操作。
+// 之后开始的操作都会先帮助它，所以每个操作的步数有上限，不会被饿死。
+//
+// 原算法每个线程固定一个tid，这里操作开始时从handles取得一个空闲的wlHandle作为tid，
+// 结束后归还，handles只增加不删除，数量不超过同时进行的操作数。
+// phase由一个FAA计数器分配，不需要遍历所有handle取最大值。
+// node和发布过的描述都不会复用，由GC回收，没有ABA。
+// 帮助时先确认描述还在等待，才准备新的描述；cas失败的描述没有发布，留给下一次cas使用，
+// 所以只有cas成功才需要分配新的描述，见casDesc。
+type WLQueue[T any] struct {
+	// 放在第一个，保证32位平台原子操作8字节对齐。
+	phase int64
+
+	once sync.Once
+
+	// len is num of value store in queue
+	// EnQueue写入后才增加，DeQueue可能先减少，按int32读取。
+	len uint32
+
+	// head 哨兵node,head.next才是第一个值。tail可能指向最后一个node的前一个。
+	head unsafe.Pointer
+	tail unsafe.Pointer
+
+	// handles 所有wlHandle的链表，新的handle加到表头。
+	handles unsafe.Pointer
+
+	// 队列关闭状态，见Close
+	cl closer
+
+	// 队列空时DeQueueCtx在notEmpty等待
+	notEmpty notifier
+}
+
+// wlNode 链表节点。
+type wlNode[T any] struct {
+	p    T
+	next unsafe.Pointer
+
+	// enqTid 加入这个node的操作
+	enqTid *wlHandle
+	// deqTid 取出这个node之后的值的操作，只能由nil变成非nil。
+	deqTid unsafe.Pointer
+}
+
+// wlDesc 操作描述，发布后不再修改，更新时cas整个描述。
+// cas失败的描述没有发布，可以修改后再次使用。
+type wlDesc[T any] struct {
+	phase   int64
+	pending bool
+	enqueue bool
+	// EnQueue: 要加入的node,失败(队列关闭)后为nil。
+	// DeQueue: 取出时的head,值在node.next,队列空时为nil。
+	node *wlNode[T]
+}
+
+// wlHandle 相当于原算法的tid。
+type wlHandle struct {
+	state unsafe.Pointer // *wlDesc[T]
+	busy  uint32
+	next  *wlHandle
+}
+
+func (h *wlHandle) casState(old, new unsafe.Pointer) bool {
+	return atomic.CompareAndSwapPointer(&h.state, old, new)
+}
+
+func (q *WLQueue[T]) onceInit() {
+	q.once.Do(func() {
+		q.init()
+	})
+}
+
+func (q *WLQueue[T]) init() {
+	sentinel := unsafe.Pointer(&wlNode[T]{})
+	q.head = sentinel
+	q.tail = sentinel
+	q.len = 0
+}
+
+// Init 取出当前数量的值并丢弃，不会中断其他goroutine的操作。
+func (q *WLQueue[T]) Init() {
+	q.onceInit()
+	for n := q.Size(); n > 0; n-- {
+		if _, ok := q.DeQueue(); !ok {
+			return
+		}
+	}
+}
+
+// acquire 取得一个空闲的handle,没有则新建一个，返回handle和遍历的步数。
+// 新建时cas表头失败，说明有其他handle加入，次数不超过handle总数。
+func (q *WLQueue[T]) acquire() (h *wlHandle, steps int) {
+	first := (*wlHandle)(atomic.LoadPointer(&q.handles))
+	for h = first; h != nil; h = h.next {
+		steps++
+		if atomic.LoadUint32(&h.busy) == 0 && atomic.CompareAndSwapUint32(&h.busy, 0, 1) {
+			return h, steps
+		}
+	}
+	h = &wlHandle{busy: 1}
+	for {
+		steps++
+		h.next = first
+		if cas(&q.handles, unsafe.Pointer(first), unsafe.Pointer(h)) {
+			return h, steps
+		}
+		first = (*wlHandle)(atomic.LoadPointer(&q.handles))
+	}
+}
+
+// release 归还handle。操作已经完成，helper不会再修改它的描述。
+func (q *WLQueue[T]) release(h *wlHandle) {
+	atomic.StoreUint32(&h.busy, 0)
+}
+
+func (q *WLQueue[T]) loadDesc(h *wlHandle) *wlDesc[T] {
+	return (*wlDesc[T])(atomic.LoadPointer(&h.state))
+}
+
+func (q *WLQueue[T]) loadHead() *wlNode[T] {
+	return (*wlNode[T])(atomic.LoadPointer(&q.head))
+}
+
+func (q *WLQueue[T]) loadTail() *wlNode[T] {
+	return (*wlNode[T])(atomic.LoadPointer(&q.tail))
+}
+
+// loadNext 下一个node,队列关闭后最后一个node返回nil。
+func (n *wlNode[T]) loadNext() *wlNode[T] {
+	next := atomic.LoadPointer(&n.next)
+	if next == closedNext {
+		return nil
+	}
+	return (*wlNode[T])(next)
+}
+
+// stillPending h的操作d还没完成，并且phase不大于phase。
+func (d *wlDesc[T]) stillPending(phase int64, enqueue bool) bool {
+	return d.pending && d.phase <= phase && d.enqueue == enqueue
+}
+
+// casDesc 把h的描述从old换成内容为nd的描述。
+// 新描述取自spare，cas失败时没有发布，放回spare留给下一次使用，
+// 成功后spare为nil，下一次才重新分配。
+func (q *WLQueue[T]) casDesc(h *wlHandle, old *wlDesc[T], nd wlDesc[T], spare **wlDesc[T]) bool {
+	d := *spare
+	if d == nil {
+		d = new(wlDesc[T])
+	}
+	*d = nd
+	if h.casState(unsafe.Pointer(old), unsafe.Pointer(d)) {
+		*spare = nil
+		return true
+	}
+	*spare = d
+	return false
+}
+
+// help 帮助所有phase不大于phase的操作，返回步数。
+func (q *WLQueue[T]) help(phase int64, spare **wlDesc[T]) (steps int) {
+	for h := (*wlHandle)(atomic.LoadPointer(&q.handles)); h != nil; h = h.next {
+		steps++
+		d := q.loadDesc(h)
+		if d == nil || !d.pending || d.phase > phase {
+			continue
+		}
+		if d.enqueue {
+			steps += q.helpEnq(h, phase, spare)
+		} else {
+			steps += q.helpDeq(h, phase, spare)
+		}
+	}
+	return steps
+}
+
+func (q *WLQueue[T]) helpEnq(h *wlHandle, phase int64, spare **wlDesc[T]) (steps int) {
+	for q.loadDesc(h).stillPending(phase, true) {
+		steps++
+		last := q.loadTail()
+		next := atomic.LoadPointer(&last.next)
+		if last != q.loadTail() {
+			continue
+		}
+		// 读取last,next之后再确认操作未完成：
+		// 完成前tail不会越过它的node,last.next为nil时node一定还没连接。
+		d := q.loadDesc(h)
+		if !d.stillPending(phase, true) {
+			return
+		}
+		switch next {
+		case nil:
+			if cas(&last.next, nil, unsafe.Pointer(d.node)) {
+				q.helpFinishEnq(spare)
+				return
+			}
+		case closedNext:
+			// 队列已经关闭，操作失败
+			q.casDesc(h, d, wlDesc[T]{phase: d.phase, enqueue: true}, spare)
+		default:
+			q.helpFinishEnq(spare)
+		}
+	}
+	return
+}
+
+// helpFinishEnq 完成已经连接到链表的EnQueue，再移动tail。
+// 操作已经完成时不需要新的描述。
+func (q *WLQueue[T]) helpFinishEnq(spare **wlDesc[T]) {
+	last := q.loadTail()
+	next := last.loadNext()
+	if next == nil {
+		return
+	}
+	h := next.enqTid
+	d := q.loadDesc(h)
+	if last == q.loadTail() && d.pending && d.node == next {
+		q.casDesc(h, d, wlDesc[T]{phase: d.phase, enqueue: true, node: next}, spare)
+	}
+	cas(&q.tail, unsafe.Pointer(last), unsafe.Pointer(next))
+}
+
+func (q *WLQueue[T]) helpDeq(h *wlHandle, phase int64, spare **wlDesc[T]) (steps int) {
+	for q.loadDesc(h).stillPending(phase, false) {
+		steps++
+		first := q.loadHead()
+		last := q.loadTail()
+		next := first.loadNext()
+		if first != q.loadHead() {
+			continue
+		}
+		d := q.loadDesc(h)
+		if !d.stillPending(phase, false) {
+			return
+		}
+		if first == last {
+			if next == nil {
+				// 队列空
+				if last == q.loadTail() {
+					q.casDesc(h, d, wlDesc[T]{phase: d.phase}, spare)
+				}
+			} else {
+				// tail落后，先完成EnQueue
+				q.helpFinishEnq(spare)
+			}
+			continue
+		}
+		if d.node != first {
+			// 记录要取出的head,cas失败说明描述已经改变，重新读取
+			if first != q.loadHead() || !q.casDesc(h, d, wlDesc[T]{phase: d.phase, pending: true, node: first}, spare) {
+				continue
+			}
+		}
+		// 多个DeQueue竞争同一个head,只有一个能写入deqTid
+		cas(&first.deqTid, nil, unsafe.Pointer(h))
+		q.helpFinishDeq(spare)
+	}
+	return
+}
+
+// helpFinishDeq 完成已经取得head的DeQueue，再移动head。
+// 操作已经完成时不需要新的描述。
+func (q *WLQueue[T]) helpFinishDeq(spare **wlDesc[T]) {
+	first := q.loadHead()
+	next := first.loadNext()
+	h := (*wlHandle)(atomic.LoadPointer(&first.deqTid))
+	if h == nil {
+		return
+	}
+	d := q.loadDesc(h)
+	if first == q.loadHead() && next != nil {
+		if d.pending {
+			q.casDesc(h, d, wlDesc[T]{phase: d.phase, node: d.node}, spare)
+		}
+		cas(&q.head, unsafe.Pointer(first), unsafe.Pointer(next))
+	}
+}
+
+func (q *WLQueue[T]) EnQueue(val T) bool {
+	ok, _ := q.EnQueueSteps(val)
+	return ok
+}
+
+// EnQueueSteps 同EnQueue，同时返回这次操作执行的步数。
+// wait-free保证步数不超过StepBound，用来验证和观察最坏情况。
+func (q *WLQueue[T]) EnQueueSteps(val T) (ok bool, steps int) {
+	q.onceInit()
+	h, steps := q.acquire()
+	phase := atomic.AddInt64(&q.phase, 1)
+	node := &wlNode[T]{p: val, enqTid: h}
+	atomic.StorePointer(&h.state, unsafe.Pointer(&wlDesc[T]{phase: phase, pending: true, enqueue: true, node: node}))
+	var spare *wlDesc[T]
+	steps += q.help(phase, &spare)
+	// 返回前移动tail,handle复用时helper不会把新操作当成这个node的EnQueue。
+	q.helpFinishEnq(&spare)
+	ok = q.loadDesc(h).node != nil
+	q.release(h)
+	if ok {
+		atomic.AddUint32(&q.len, 1)
+		q.notEmpty.signal()
+	}
+	return ok, steps
+}
+
+func (q *WLQueue[T]) DeQueue() (val T, ok bool) {
+	val, ok, _ = q.DeQueueSteps()
+	return
+}
+
+// DeQueueSteps 同DeQueue，同时返回这次操作执行的步数，见EnQueueSteps。
+func (q *WLQueue[T]) DeQueueSteps() (val T, ok bool, steps int) {
+	q.onceInit()
+	h, steps := q.acquire()
+	phase := atomic.AddInt64(&q.phase, 1)
+	atomic.StorePointer(&h.state, unsafe.Pointer(&wlDesc[T]{phase: phase, pending: true}))
+	var spare *wlDesc[T]
+	steps += q.help(phase, &spare)
+	// 返回前移动head,handle复用时helper不会把新操作当成取出这个head的DeQueue。
+	q.helpFinishDeq(&spare)
+	node := q.loadDesc(h).node
+	q.release(h)
+	if node == nil {
+		// queue empty,
+		return val, false, steps
+	}
+	// node.next成为新的哨兵。Peek可能还在读取它的值，所以不清空，
+	// 哨兵保留最后取出的值，下一次DeQueue后由GC回收。
+	val = node.loadNext().p
+	atomic.AddUint32(&q.len, negativeOne)
+	return val, true, steps
+}
+
+// Peek 返回队头的值，不取出。
+//
+// 哨兵head的deqTid还是nil时，没有DeQueue取出head.next，它就是队头。
+// 先读取值，再确认deqTid为nil并且head没有变化，返回的是那一刻DeQueue会取出的值。
+// node的值写入后不再修改，读取没有数据竞争。
+func (q *WLQueue[T]) Peek() (val T, ok bool) {
+	q.onceInit()
+	var spare *wlDesc[T]
+	for {
+		head := q.loadHead()
+		next := head.loadNext()
+		if next == nil {
+			// queue empty,
+			return
+		}
+		val = next.p
+		if atomic.LoadPointer(&head.deqTid) != nil {
+			// 已经被取出，帮助移动head
+			q.helpFinishDeq(&spare)
+			continue
+		}
+		if q.loadHead() == head {
+			return val, true
+		}
+	}
+}
+
+func (q *WLQueue[T]) EnQueueMany(vals []T) (n int) {
+	for ; n < len(vals); n++ {
+		if !q.EnQueue(vals[n]) {
+			break
+		}
+	}
+	return n
+}
+
+func (q *WLQueue[T]) DeQueueMany(dst []T) (n int) {
+	for ; n < len(dst); n++ {
+		val, ok := q.DeQueue()
+		if !ok {
+			break
+		}
+		dst[n] = val
+	}
+	return n
+}
+
+// Handles 已经创建的handle数量，不超过曾经同时进行的操作数。
+func (q *WLQueue[T]) Handles() int {
+	var n int
+	for h := (*wlHandle)(atomic.LoadPointer(&q.handles)); h != nil; h = h.next {
+		n++
+	}
+	return n
+}
+
+// StepBound 当前handle数量下每个操作的步数上限：
+// acquire和help各遍历n个handle,帮助每个操作时，
+// 其他n个操作每个最多让循环多执行常数次。
+func (q *WLQueue[T]) StepBound() int {
+	n := q.Handles()
+	return 2*n + n*4*(n+1)
+}
+
+func (q *WLQueue[T]) Cap() int {
+	return queueLimit
+}
+
+func (q *WLQueue[T]) Full() bool {
+	return false
+}
+
+// 数量，并发时只是近似值。
+func (q *WLQueue[T]) Size() int {
+	n := int32(atomic.LoadUint32(&q.len))
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+func (q *WLQueue[T]) Empty() bool {
+	return q.Size() == 0
+}
+
+// snapshot 读取哨兵之后的node。node只会连接在最后，读到的是最后一个next时的状态。
+func (q *WLQueue[T]) snapshot() []T {
+	vals := make([]T, 0, q.Size())
+	for n := q.loadHead().loadNext(); n != nil; n = n.loadNext() {
+		vals = append(vals, n.p)
+	}
+	return vals
+}
+
+// Close 关闭队列，之后EnQueue失败，DeQueue可以继续取出剩下的值。
+// 重复调用无效，Init不会重新打开队列。
+//
+// 最后一个node的next换成closedNext，还没连接到链表的EnQueue失败。
+func (q *WLQueue[T]) Close() {
+	q.onceInit()
+	if q.cl.close(q.seal) {
+		q.notEmpty.signal()
+	}
+}
+
+// seal 将最后一个node的next换成closedNext。
+func (q *WLQueue[T]) seal() {
+	var spare *wlDesc[T]
+	for {
+		last := q.loadTail()
+		next := atomic.LoadPointer(&last.next)
+		if next == closedNext {
+			return
+		}
+		if next == nil && cas(&last.next, nil, closedNext) {
+			return
+		}
+		q.helpFinishEnq(&spare)
+	}
+}
+
+// Done 队列关闭后，返回的channel被关闭。
+func (q *WLQueue[T]) Done() <-chan struct{} {
+	return q.cl.doneChan()
+}
+
+// drained 关闭后链表已经取完。
+func (q *WLQueue[T]) drained() bool {
+	return q.cl.isSealed() && q.loadHead().loadNext() == nil
+}
+
+// DeQueueCtx 队列空时阻塞等待，直到出队成功，或者ctx取消、超时返回ctx.Err()，
+// 队列关闭并且取完返回ErrClosed。
+func (q *WLQueue[T]) DeQueueCtx(ctx context.Context) (T, error) {
+	return deQueueCtx[T](ctx, q, &q.notEmpty, q.drained)
+}
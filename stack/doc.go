@@ -2,13 +2,16 @@ package stack
 
 /*
 // Stack接口
-type Stack interface {
-	Push(i interface{}) bool
-	Pop() (val interface{}, ok bool)
+type Stack[T any] interface {
+	Push(i T) bool
+	Pop() (val T, ok bool)
 }
 
-type DataStack interface {
-	Stack
+type DataStack[T any] interface {
+	Stack[T]
+	Top() (val T, ok bool)
+	Range(f func(v T) bool)
+	Snapshot() []T
 	onceInit()
 	Init()
 	Size() int
@@ -16,7 +19,7 @@ type DataStack interface {
 	Empty() bool
 }
 
-type XXStack struct {
+type XXStack[T any] struct {
 	// 链表形式
 	// len uint32
 	// top unsafe.Pointer
@@ -29,15 +32,16 @@ type XXStack struct {
 	// once sync.Once
 	// len  uint32
 	// cap  uint32
-	// data []listNode
+	// data []listNode[T]
 	// 可选项
 	// mu   sync.Mutex
 }
 
-type XXNode struct {
+type XXNode[T any] struct {
 	// 链表节点
 	next  unsafe.Pointer
-	p     interface{}
+	p     T
+	state uint32 // slot是否储存了值
 
 	// 额外可选
 	// prev unsafe.Pointer
@@ -55,6 +59,7 @@ const (
 链表			top == nil					无
 
 slot:储存或者取出value时的节点node。
+值以T类型直接储存，不再装箱，也不再需要包装nil值。
 push:将slot.next=top,然后cas(top,top,slot)，成功则完成。
 pop:cas(top,top,slot.next),成功则完成。
 
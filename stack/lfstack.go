@@ -1,96 +1,173 @@
 package stack
 
 import (
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"unsafe"
+
+	"github.com/min1324/data/backoff"
+	"github.com/min1324/data/internal/reclaim"
 )
 
 // LLStack a lock-free concurrent FILO stack.
-type LLStack struct {
-	len uint32         // stack value num.
-	top unsafe.Pointer // point to the latest value pushed.
+type LLStack[T any] struct {
+	once sync.Once
+	len  uint32         // stack value num.
+	top  unsafe.Pointer // point to the latest value pushed.
+
+	// Pop前用危险指针保护top，出栈的node退休，
+	// 没有goroutine保护时清空并放入pool，供Push复用。
+	//
+	// ReclaimEpoch方式则每次操作Pin一次，
+	// 回收的node放入空闲链表free。见 epoch 包
+	rc   reclaim.Reclaimer
+	pool sync.Pool
+	free reclaim.FreeList[ptrNode[T], *ptrNode[T]]
+
+	// bo cas失败后的退避策略，nil时立即重试，见NewLLStackWith
+	bo backoff.Backoff
+}
+
+func (s *LLStack[T]) onceInit() {
+	s.once.Do(func() {
+		s.rc.Init(s.reclaim)
+	})
 }
 
-func (q *LLStack) onceInit() {}
+// newNode 优先复用已回收的node，需要在pin期间调用。
+func (s *LLStack[T]) newNode() *ptrNode[T] {
+	if s.rc.Mode == ReclaimEpoch {
+		if n := s.free.Pop(); n != nil {
+			return n
+		}
+	} else if n, ok := s.pool.Get().(*ptrNode[T]); ok {
+		return n
+	}
+	return newPrtNode[T]()
+}
+
+// reclaim 回收没有goroutine持有的node
+func (s *LLStack[T]) reclaim(p unsafe.Pointer) {
+	n := (*ptrNode[T])(p)
+	n.free()
+	if s.rc.Mode == ReclaimEpoch {
+		s.free.Push(n)
+	} else {
+		s.pool.Put(n)
+	}
+}
 
 // Init initialize stack.
-func (s *LLStack) Init() {
-	top := atomic.LoadPointer(&s.top)
-	for top != nil {
+func (s *LLStack[T]) Init() {
+	s.onceInit()
+	g := s.rc.Pin()
+	defer s.rc.Unpin(g)
+	var top unsafe.Pointer
+	for {
 		top = atomic.LoadPointer(&s.top)
-		oldLen := atomic.LoadUint32(&s.len)
+		if top == nil {
+			return
+		}
 		if cas(&s.top, top, nil) {
-			atomic.AddUint32(&s.len, (^oldLen + 1))
 			break
 		}
 	}
-	// free dummy node
-	for top != nil {
-		freeNode := (*ptrNode)(top)
-		top = freeNode.next
-		freeNode.free()
+	// Push在cas成功后才增加len，所以按移出的node数量减少len。
+	var n uint32
+	for p := top; p != nil; p = loadNext[T](p) {
+		n++
 	}
+	atomic.AddUint32(&s.len, (^n + 1))
+	// 移出的node只有当前goroutine能退休。
+	s.rc.RetireChain(g, top, nil, loadNext[T])
 }
 
-func (q *LLStack) Cap() int {
+func (q *LLStack[T]) Cap() int {
 	return stackLimit
 }
 
-func (q *LLStack) Full() bool {
+func (q *LLStack[T]) Full() bool {
 	return false
 }
 
-func (q *LLStack) Empty() bool {
-	return q.len == 0
+func (q *LLStack[T]) Empty() bool {
+	return atomic.LoadPointer(&q.top) == nil
 }
 
 // Size stack element's number
-func (s *LLStack) Size() int {
+func (s *LLStack[T]) Size() int {
 	return int(atomic.LoadUint32(&s.len))
 }
 
 // Push puts the given value at the top of the stack.
-func (s *LLStack) Push(val interface{}) bool {
-	if val == nil {
-		val = empty
-	}
-	slot := newPrtNode(val)
-	for {
-		slot.next = atomic.LoadPointer(&s.top)
-		if cas(&s.top, slot.next, unsafe.Pointer(slot)) {
+func (s *LLStack[T]) Push(val T) bool {
+	s.onceInit()
+	g := s.rc.Pin()
+	defer s.rc.Unpin(g)
+
+	slot := s.newNode()
+	slot.store(val)
+	for n := 0; ; n++ {
+		top := atomic.LoadPointer(&s.top)
+		atomic.StorePointer(&slot.next, top)
+		if cas(&s.top, top, unsafe.Pointer(slot)) {
 			atomic.AddUint32(&s.len, 1)
 			break
 		}
+		backoff.Wait(s.bo, n)
 	}
 	return true
 }
 
 // Pop removes and returns the value at the top of the stack.
-// It returns nil if the stack is empty.
-func (s *LLStack) Pop() (val interface{}, ok bool) {
-	var slot *ptrNode
-	for {
-		top := atomic.LoadPointer(&s.top)
+// It returns false if the stack is empty.
+func (s *LLStack[T]) Pop() (val T, ok bool) {
+	s.onceInit()
+	g := s.rc.Pin()
+	defer s.rc.Unpin(g)
+
+	var slot *ptrNode[T]
+	for n := 0; ; n++ {
+		// 保护top，top被保护期间不会被回收复用，cas不会出现ABA问题。
+		top := s.rc.Protect(g, 0, &s.top)
 		if top == nil {
 			return
 		}
-		slot = (*ptrNode)(top)
-		if cas(&s.top, top, slot.next) {
+		slot = (*ptrNode[T])(top)
+		if cas(&s.top, top, atomic.LoadPointer(&slot.next)) {
 			atomic.AddUint32(&s.len, ^uint32(0))
 			break
 		}
+		backoff.Wait(s.bo, n)
 	}
-	val = slot.load()
-	if val == empty {
-		val = nil
-	}
-	slot.free()
+	val, _ = slot.load()
+	s.rc.Retire(g, unsafe.Pointer(slot))
 	return val, true
 }
 
+// Top returns the value at the top of the stack without removing it.
+//
+// 保护top后读取它的值，再确认top没有变化：读取时它就是栈顶，
+// 返回的是那一刻Pop会取出的值。
+func (s *LLStack[T]) Top() (val T, ok bool) {
+	s.onceInit()
+	g := s.rc.Pin()
+	defer s.rc.Unpin(g)
+	for {
+		top := s.rc.Protect(g, 0, &s.top)
+		if top == nil {
+			return
+		}
+		val, ok = (*ptrNode[T])(top).load()
+		if top == atomic.LoadPointer(&s.top) {
+			return
+		}
+	}
+}
+
 // LAStack a lock-free concurrent FILO array stack.
-type LAStack struct {
+type LAStack[T any] struct {
 	once sync.Once
 	len  uint32
 	cap  uint32
@@ -99,56 +176,59 @@ type LAStack struct {
 	// 低16位表示当前写线程数量。
 	// 允许连续读，连续写，但不允许读写同时进行。
 	state uint32
-	data  []baseNode
+	data  []baseNode[T]
+
+	// bo cas失败或者等待读写状态时的退避策略，nil时立即重试，见NewLAStackWith
+	bo backoff.Backoff
 }
 
 const stackBits = 16
 const stackMark = (1 << stackBits) - 1
 const stackLimit = (1 << 32) / 4
 
-func (s LAStack) unpack(ptrs uint32) (read, write uint16) {
+func (s *LAStack[T]) unpack(ptrs uint32) (read, write uint16) {
 	read = uint16((ptrs >> stackBits) & stackMark)
 	write = uint16(ptrs & stackMark)
 	return
 }
 
-func (s LAStack) pack(read, write uint16) (ptrs uint32) {
+func (s *LAStack[T]) pack(read, write uint16) (ptrs uint32) {
 	return (uint32(read)<<stackBits | uint32(write&stackMark))
 }
 
 // 一次性初始化
-func (q *LAStack) onceInit() {
+func (q *LAStack[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
 // 无并发初始化
-func (s *LAStack) init() {
+func (s *LAStack[T]) init() {
 	var cap = s.cap
 	if cap < 1 {
 		cap = DefauleSize
 	}
-	s.data = make([]baseNode, cap)
-	s.len = 0
-	s.state = 0
-	s.cap = cap
+	s.data = make([]baseNode[T], cap)
+	atomic.StoreUint32(&s.len, 0)
+	atomic.StoreUint32(&s.state, 0)
+	atomic.StoreUint32(&s.cap, cap)
 }
 
 // Init初始化长度为: DefauleSize.
-func (s *LAStack) Init() {
+func (s *LAStack[T]) Init() {
 	s.InitWith()
 }
 
 // InitWith初始化长度为cap的queue,
 // 如果未提供，则使用默认值: DefauleSize.
-func (s *LAStack) InitWith(caps ...int) {
+func (s *LAStack[T]) InitWith(caps ...int) {
 	s.onceInit()
-	if s.cap < 1 {
-		// 第一次初始化，cap为0.
-		s.cap = DefauleSize
-	}
 	var newCap = atomic.LoadUint32(&s.cap)
+	if newCap < 1 {
+		// 其他InitWith正在初始化，使用默认值
+		newCap = DefauleSize
+	}
 	if len(caps) > 0 && caps[0] > 0 {
 		newCap = uint32(caps[0])
 	}
@@ -182,51 +262,50 @@ func (s *LAStack) InitWith(caps ...int) {
 		}
 	}
 	// 初始化
-	s.data = make([]baseNode, newCap)
-	s.len = 0
-	s.cap = newCap
+	s.data = make([]baseNode[T], newCap)
+	atomic.StoreUint32(&s.len, 0)
+	atomic.StoreUint32(&s.cap, newCap)
 	atomic.StoreUint32(&s.state, 0)
 }
 
-func (s *LAStack) getSlot(id uint32) *baseNode {
+func (s *LAStack[T]) getSlot(id uint32) *baseNode[T] {
 	return &s.data[id]
 }
 
+// addWriter 增加1个写线程，有读线程时等待。
+// 写线程之间len只会增加，已经占用的slot不会被Pop清空。
+func (s *LAStack[T]) addWriter() {
+	for n := 0; ; n++ {
+		state := atomic.LoadUint32(&s.state)
+		read, write := s.unpack(state)
+		// 有读线程，或者写线程已经达到 1<<bit-1了，无法再添加，等待。
+		if read == 0 && write != stackMark {
+			state2 := s.pack(0, write+1)
+			if casUint32(&s.state, state, state2) {
+				// 成功添加1个写线程
+				return
+			}
+		}
+		backoff.Wait(s.bo, n)
+	}
+}
+
 // Push puts the given value at the top of the stack.
-func (s *LAStack) Push(val interface{}) bool {
+func (s *LAStack[T]) Push(val T) bool {
 	s.onceInit()
 	if s.Full() {
 		return false
 	}
-	if val == nil {
-		val = empty
-	}
 	// 先增加写状态数量
-	for {
-		state := atomic.LoadUint32(&s.state)
-		read, write := s.unpack(state)
-		if read > 0 {
-			// 有读线程，等待。
-			continue
-		}
-		if write == stackMark {
-			// 写线程已经达到 1<<bit-1了，无法再添加。
-			continue
-		}
-		state2 := s.pack(0, write+1)
-		if casUint32(&s.state, state, state2) {
-			// 成功添加1个写线程
-			break
-		}
-	}
+	s.addWriter()
 	// 写线程已增加1，最后需要减少1
 	defer atomic.AddUint32(&s.state, negativeOne)
 
 	// 再获取slot
-	var slot *baseNode
-	for {
+	var slot *baseNode[T]
+	for n := 0; ; n++ {
 		top := atomic.LoadUint32(&s.len)
-		if top >= s.cap {
+		if top >= atomic.LoadUint32(&s.cap) {
 			// withInit时缩短stack,肯能出现情况：top > s.cap，
 			return false
 		}
@@ -234,44 +313,41 @@ func (s *LAStack) Push(val interface{}) bool {
 		if casUint32(&s.len, top, top+1) {
 			break
 		}
+		backoff.Wait(s.bo, n)
 	}
 	slot.store(val)
 	return true
 }
 
 // Pop removes and returns the value at the top of the stack.
-// It returns nil if the stack is empty.
-func (s *LAStack) Pop() (val interface{}, ok bool) {
+// It returns false if the stack is empty.
+func (s *LAStack[T]) Pop() (val T, ok bool) {
 	s.onceInit()
 	if s.Empty() {
 		return
 	}
 	// 先增加读状态数量
-	for {
+	for n := 0; ; n++ {
 		state := atomic.LoadUint32(&s.state)
 		read, write := s.unpack(state)
-		if write > 0 {
-			// 有写线程，等待。
-			continue
-		}
-		if read == stackMark {
-			// 读线程已经达到 1<<bit-1了，无法再添加。
-			continue
-		}
-		state2 := s.pack(read+1, 0)
-		if casUint32(&s.state, state, state2) {
-			// 成功添加1个读线程
-			break
+		// 有写线程，或者读线程已经达到 1<<bit-1了，无法再添加，等待。
+		if write == 0 && read != stackMark {
+			state2 := s.pack(read+1, 0)
+			if casUint32(&s.state, state, state2) {
+				// 成功添加1个读线程
+				break
+			}
 		}
+		backoff.Wait(s.bo, n)
 	}
 	// 读线程已增加1，最后需要减少1
 	defer atomic.AddUint32(&s.state, ^uint32(stackMark))
 
 	// 再获取slot
-	var slot *baseNode
-	for {
+	var slot *baseNode[T]
+	for n := 0; ; n++ {
 		top := atomic.LoadUint32(&s.len)
-		if top == 0 || top > s.cap {
+		if top == 0 || top > atomic.LoadUint32(&s.cap) {
 			// withInit时缩短stack,肯能出现情况：top > s.cap，
 			return
 		}
@@ -279,28 +355,52 @@ func (s *LAStack) Pop() (val interface{}, ok bool) {
 		if casUint32(&s.len, top, top-1) {
 			break
 		}
+		backoff.Wait(s.bo, n)
 	}
-	val = slot.load()
+	val, _ = slot.load()
 	slot.free()
-	if val == empty {
-		val = nil
-	}
 	return val, true
 }
 
-func (q *LAStack) Cap() int {
-	return int(q.cap)
+// Top returns the value at the top of the stack without removing it.
+//
+// 和Push一样作为写线程，等待Pop完成，读取len时栈顶的slot已经被Push占用，
+// 等待写入完成，返回的是读取len那一刻的栈顶。
+func (s *LAStack[T]) Top() (val T, ok bool) {
+	s.onceInit()
+	if s.Empty() {
+		return
+	}
+	s.addWriter()
+	defer atomic.AddUint32(&s.state, negativeOne)
+
+	top := atomic.LoadUint32(&s.len)
+	if top == 0 || top > atomic.LoadUint32(&s.cap) {
+		return
+	}
+	slot := s.getSlot(top - 1)
+	for {
+		if val, ok = slot.load(); ok {
+			return
+		}
+		// Push已经占用slot，还没写入。
+		runtime.Gosched()
+	}
+}
+
+func (q *LAStack[T]) Cap() int {
+	return int(atomic.LoadUint32(&q.cap))
 }
 
-func (q *LAStack) Full() bool {
-	return q.len == q.cap
+func (q *LAStack[T]) Full() bool {
+	return atomic.LoadUint32(&q.len) == atomic.LoadUint32(&q.cap)
 }
 
-func (q *LAStack) Empty() bool {
-	return q.len == 0
+func (q *LAStack[T]) Empty() bool {
+	return atomic.LoadUint32(&q.len) == 0
 }
 
 // Size stack element's number
-func (s *LAStack) Size() int {
-	return int(s.len)
+func (s *LAStack[T]) Size() int {
+	return int(atomic.LoadUint32(&s.len))
 }
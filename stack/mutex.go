@@ -2,46 +2,47 @@ package stack
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // mutex stack
 // 单锁有限数组栈
-type SAStack struct {
+type SAStack[T any] struct {
 	once sync.Once
 	mu   sync.Mutex
 
 	len  uint32 // 栈数量，也指栈顶指向
 	cap  uint32
-	data []ptrNode
+	data []ptrNode[T]
 }
 
-func (q *SAStack) onceInit() {
+func (q *SAStack[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *SAStack) init() {
+func (q *SAStack[T]) init() {
 	if q.cap < 1 {
-		q.cap = DefauleSize
+		atomic.StoreUint32(&q.cap, DefauleSize)
 	}
-	q.len = 0
-	q.data = make([]ptrNode, q.cap)
+	atomic.StoreUint32(&q.len, 0)
+	q.data = make([]ptrNode[T], q.cap)
 }
 
-func (q *SAStack) Init() {
+func (q *SAStack[T]) Init() {
 	q.InitWith()
 }
 
 // InitWith 初始化长度为cap的queue,
 // 如果未提供，则使用默认值: DefaultSize
-func (q *SAStack) InitWith(caps ...int) {
+func (q *SAStack[T]) InitWith(caps ...int) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	q.onceInit()
 
 	if len(caps) > 0 && caps[0] > 0 {
-		q.cap = uint32(caps[0])
+		atomic.StoreUint32(&q.cap, uint32(caps[0]))
 	}
 	q.init()
 	for i := 0; i < len(q.data); i++ {
@@ -49,23 +50,24 @@ func (q *SAStack) InitWith(caps ...int) {
 	}
 }
 
-func (q *SAStack) Cap() int {
-	return int(q.cap)
+// Cap,Full,Empty,Size可能在锁外调用，原子读取len,cap
+func (q *SAStack[T]) Cap() int {
+	return int(atomic.LoadUint32(&q.cap))
 }
 
-func (q *SAStack) Full() bool {
-	return q.len == q.cap
+func (q *SAStack[T]) Full() bool {
+	return atomic.LoadUint32(&q.len) == atomic.LoadUint32(&q.cap)
 }
 
-func (q *SAStack) Empty() bool {
-	return q.len == 0
+func (q *SAStack[T]) Empty() bool {
+	return atomic.LoadUint32(&q.len) == 0
 }
 
-func (q *SAStack) Size() int {
-	return int(q.len)
+func (q *SAStack[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
-func (q *SAStack) Push(val interface{}) bool {
+func (q *SAStack[T]) Push(val T) bool {
 	if q.Full() {
 		return false
 	}
@@ -75,16 +77,12 @@ func (q *SAStack) Push(val interface{}) bool {
 	if q.Full() {
 		return false
 	}
-	if val == nil {
-		val = empty
-	}
-
 	q.data[q.len].store(val)
-	q.len += 1
+	atomic.StoreUint32(&q.len, q.len+1)
 	return true
 }
 
-func (q *SAStack) Pop() (val interface{}, ok bool) {
+func (q *SAStack[T]) Pop() (val T, ok bool) {
 	if q.Empty() {
 		return
 	}
@@ -94,37 +92,48 @@ func (q *SAStack) Pop() (val interface{}, ok bool) {
 	if q.Empty() {
 		return
 	}
-	slot := q.data[q.len-1]
-	q.len -= 1
-	val = slot.load()
-	if val == empty {
-		val = nil
-	}
+	slot := &q.data[q.len-1]
+	atomic.StoreUint32(&q.len, q.len-1)
+	val, _ = slot.load()
 	slot.free()
 	return val, true
 }
 
+// Top 返回栈顶的值，不取出。
+func (q *SAStack[T]) Top() (val T, ok bool) {
+	q.onceInit()
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Empty() {
+		return
+	}
+	return q.data[q.len-1].load()
+}
+
 // mutex list stack
 
 // 单锁无限制链表栈
-type SLStack struct {
+type SLStack[T any] struct {
 	once sync.Once
 	mu   sync.Mutex
 
 	len uint32
-	top *listNode
+	top *listNode[T]
 }
 
-func (q *SLStack) onceInit() {
+func (q *SLStack[T]) onceInit() {
 	q.once.Do(func() {
 		q.init()
 	})
 }
 
-func (q *SLStack) init() {
+func (q *SLStack[T]) init() {
 	top := q.top
 	q.top = nil
-	q.len = 0
+	atomic.StoreUint32(&q.len, 0)
 	for top != nil {
 		freeNode := top
 		top = freeNode.next
@@ -132,39 +141,38 @@ func (q *SLStack) init() {
 	}
 }
 
-func (q *SLStack) Init() {
+func (q *SLStack[T]) Init() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	q.init()
 }
 
-func (q *SLStack) Full() bool {
+func (q *SLStack[T]) Full() bool {
 	return false
 }
 
-func (q *SLStack) Empty() bool {
-	return q.len == 0
+// Empty,Size可能在锁外调用，原子读取len
+func (q *SLStack[T]) Empty() bool {
+	return atomic.LoadUint32(&q.len) == 0
 }
 
-func (q *SLStack) Size() int {
-	return int(q.len)
+func (q *SLStack[T]) Size() int {
+	return int(atomic.LoadUint32(&q.len))
 }
 
-func (q *SLStack) Push(val interface{}) bool {
+func (q *SLStack[T]) Push(val T) bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	q.onceInit()
-	if val == nil {
-		val = empty
-	}
-	slot := newListNode(val)
+	slot := newListNode[T]()
+	slot.store(val)
 	slot.next = q.top
 	q.top = slot
-	q.len += 1
+	atomic.StoreUint32(&q.len, q.len+1)
 	return true
 }
 
-func (q *SLStack) Pop() (val interface{}, ok bool) {
+func (q *SLStack[T]) Pop() (val T, ok bool) {
 	if q.Empty() {
 		return
 	}
@@ -176,11 +184,22 @@ func (q *SLStack) Pop() (val interface{}, ok bool) {
 	}
 	slot := q.top
 	q.top = slot.next
-	q.len -= 1
-	val = slot.load()
-	if val == empty {
-		val = nil
-	}
+	atomic.StoreUint32(&q.len, q.len-1)
+	val, _ = slot.load()
 	slot.free()
 	return val, true
 }
+
+// Top 返回栈顶的值，不取出。
+func (q *SLStack[T]) Top() (val T, ok bool) {
+	if q.Empty() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onceInit()
+	if q.top == nil {
+		return
+	}
+	return q.top.load()
+}
@@ -0,0 +1,15 @@
+package stack
+
+import "github.com/min1324/data/internal/reclaim"
+
+// Reclaim 无锁链表node的回收方式。
+type Reclaim = reclaim.Mode
+
+const (
+	// ReclaimHazard 危险指针，访问node前先保护，默认方式。
+	ReclaimHazard Reclaim = reclaim.Hazard
+
+	// ReclaimEpoch 基于epoch回收，每次操作只Pin一次，开销更低。
+	// 回收的node放入空闲链表，供新node复用。
+	ReclaimEpoch Reclaim = reclaim.Epoch
+)
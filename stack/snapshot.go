@@ -0,0 +1,214 @@
+package stack
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/min1324/data/codec"
+)
+
+// ErrFull 恢复快照时栈容量不够。
+var ErrFull = errors.New("stack: full")
+
+var (
+	_ DataStack[int] = (*SAStack[int])(nil)
+	_ DataStack[int] = (*SLStack[int])(nil)
+	_ DataStack[int] = (*LLStack[int])(nil)
+	_ DataStack[int] = (*LAStack[int])(nil)
+)
+
+// Snapshot 从栈底到栈顶返回s中所有值，即Push的顺序，不会取出。
+//
+// 只有锁栈(SAStack,SLStack)的快照持有锁，是某一时刻的完整状态。
+// LLStack,LAStack不能给出某一时刻的快照，并发修改时得到的序列栈可能从来没有处于过：
+// LLStack的快照是弱一致的遍历，见LLStack.Range，可以和任意操作并发。
+// LAStack的快照和Push一样等待Pop完成，逐个读取slot，可以和任意操作并发。
+// 需要某一时刻的状态时，先停止这些栈的所有操作，或者使用锁栈。
+func Snapshot[T any](s DataStack[T]) []T {
+	s.onceInit()
+	return s.snapshot()
+}
+
+// Restore 清空s，再按顺序Push vals，可以恢复到任意DataStack，vals的最后一个在栈顶。
+// s容量不够时只加入前面的一部分，返回ErrFull。
+// 恢复不是原子操作，不应该和其他操作并发调用。
+func Restore[T any](s DataStack[T], vals []T) error {
+	s.Init()
+	for _, v := range vals {
+		if !s.Push(v) {
+			return ErrFull
+		}
+	}
+	return nil
+}
+
+// Marshal 将s的快照从栈底到栈顶编码，每个值由c编码，格式见codec包。
+// 快照的一致性见Snapshot。
+func Marshal[T any](s DataStack[T], c codec.Codec[T]) ([]byte, error) {
+	return codec.EncodeSlice(Snapshot(s), c)
+}
+
+// Unmarshal 解码Marshal生成的数据，恢复到s，见Restore。
+func Unmarshal[T any](s DataStack[T], data []byte, c codec.Codec[T]) error {
+	vals, err := codec.DecodeSlice(data, c)
+	if err != nil {
+		return err
+	}
+	return Restore(s, vals)
+}
+
+// MarshalJSON 将s的快照从栈底到栈顶编码成json数组，每个元素由c编码，c的输出必须是json，见codec.EncodeJSON。
+// 快照的一致性见Snapshot。
+func MarshalJSON[T any](s DataStack[T], c codec.Codec[T]) ([]byte, error) {
+	return codec.EncodeJSON(Snapshot(s), c)
+}
+
+// UnmarshalJSON 解码MarshalJSON生成的json数组，每个元素由c解码，恢复到s，见Restore。
+func UnmarshalJSON[T any](s DataStack[T], data []byte, c codec.Codec[T]) error {
+	vals, err := codec.DecodeJSON(data, c)
+	if err != nil {
+		return err
+	}
+	return Restore(s, vals)
+}
+
+// 各个栈的encoding.BinaryMarshaler和json.Marshaler使用下面的默认codec：
+// 二进制格式的值由codec.Gob编码，json格式的值由codec.JSON编码，是从栈底到栈顶的数组。
+// 其他codec使用Marshal,Unmarshal和MarshalJSON,UnmarshalJSON。
+// 快照的一致性见Snapshot，不是锁栈时，并发修改中编码的可能是栈从来没有处于过的状态。
+
+func marshalBinary[T any](s DataStack[T]) ([]byte, error) {
+	return Marshal[T](s, codec.Gob[T]{})
+}
+
+func unmarshalBinary[T any](s DataStack[T], data []byte) error {
+	return Unmarshal[T](s, data, codec.Gob[T]{})
+}
+
+func marshalJSON[T any](s DataStack[T]) ([]byte, error) {
+	return MarshalJSON[T](s, codec.JSON[T]{})
+}
+
+func unmarshalJSON[T any](s DataStack[T], data []byte) error {
+	return UnmarshalJSON[T](s, data, codec.JSON[T]{})
+}
+
+func (q *SAStack[T]) snapshot() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vals := make([]T, 0, q.len)
+	for i := uint32(0); i < q.len; i++ {
+		val, _ := q.data[i].load()
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+func (q *SLStack[T]) snapshot() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	vals := make([]T, q.len)
+	i := len(vals)
+	for n := q.top; n != nil; n = n.next {
+		i--
+		vals[i], _ = n.load()
+	}
+	return vals
+}
+
+func (s *LLStack[T]) snapshot() []T {
+	vals := make([]T, 0, s.Size())
+	s.Range(func(v T) bool {
+		vals = append(vals, v)
+		return true
+	})
+	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
+		vals[i], vals[j] = vals[j], vals[i]
+	}
+	return vals
+}
+
+// snapshot 和Push一样增加写状态，等待Pop完成，期间len只会增加。
+// 读取len，len之前的slot都已经被Push占用，等待写入完成。
+func (s *LAStack[T]) snapshot() []T {
+	s.addWriter()
+	defer atomic.AddUint32(&s.state, negativeOne)
+
+	top := atomic.LoadUint32(&s.len)
+	if cap := atomic.LoadUint32(&s.cap); top > cap {
+		top = cap
+	}
+	vals := make([]T, 0, top)
+	for i := uint32(0); i < top; i++ {
+		slot := s.getSlot(i)
+		for {
+			if val, ok := slot.load(); ok {
+				vals = append(vals, val)
+				break
+			}
+			// Push已经占用slot，还没写入。
+			runtime.Gosched()
+		}
+	}
+	return vals
+}
+
+// Range 从栈顶到栈底遍历，即Pop的顺序，f返回false时停止。
+//
+// 弱一致的遍历：不加锁也不复制，可以和任意操作并发。
+// 遍历开始时已经在栈中，并且直到遍历结束都没有出栈的值，按顺序恰好访问一次；
+// 遍历期间Push或者Pop的值，可能访问也可能不访问，但不会重复。
+// 遍历期间退休的node不会回收复用，由GC回收，所以f不宜执行太久。
+func (s *LLStack[T]) Range(f func(v T) bool) {
+	s.onceInit()
+	s.rc.Hold()
+	defer s.rc.Release()
+	// node的next在Push前设置，之后不再改变，退休后在release前也不会清空，
+	// 所以从某一时刻的top出发，沿着next读到的是那一刻栈中的值。
+	for p := atomic.LoadPointer(&s.top); p != nil; p = loadNext[T](p) {
+		val, _ := (*ptrNode[T])(p).load()
+		if !f(val) {
+			return
+		}
+	}
+}
+
+// 其他栈的Range从栈顶到栈底遍历Snapshot返回的副本，一致性和Snapshot相同：
+// 锁栈是持有锁复制的某一时刻状态，遍历时不再持有锁，f可以操作栈。
+
+func rangeReverse[T any](vals []T, f func(v T) bool) {
+	for i := len(vals) - 1; i >= 0; i-- {
+		if !f(vals[i]) {
+			return
+		}
+	}
+}
+
+func (s *SAStack[T]) Range(f func(v T) bool) { rangeReverse(s.Snapshot(), f) }
+func (s *SLStack[T]) Range(f func(v T) bool) { rangeReverse(s.Snapshot(), f) }
+func (s *LAStack[T]) Range(f func(v T) bool) { rangeReverse(s.Snapshot(), f) }
+
+// Snapshot 从栈底到栈顶返回所有值，见包函数Snapshot。
+
+func (s *SAStack[T]) Snapshot() []T { return Snapshot[T](s) }
+func (s *SLStack[T]) Snapshot() []T { return Snapshot[T](s) }
+func (s *LLStack[T]) Snapshot() []T { return Snapshot[T](s) }
+func (s *LAStack[T]) Snapshot() []T { return Snapshot[T](s) }
+
+func (s *SAStack[T]) MarshalBinary() ([]byte, error)    { return marshalBinary[T](s) }
+func (s *SAStack[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](s, data) }
+func (s *SAStack[T]) MarshalJSON() ([]byte, error)      { return marshalJSON[T](s) }
+func (s *SAStack[T]) UnmarshalJSON(data []byte) error   { return unmarshalJSON[T](s, data) }
+func (s *SLStack[T]) MarshalBinary() ([]byte, error)    { return marshalBinary[T](s) }
+func (s *SLStack[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](s, data) }
+func (s *SLStack[T]) MarshalJSON() ([]byte, error)      { return marshalJSON[T](s) }
+func (s *SLStack[T]) UnmarshalJSON(data []byte) error   { return unmarshalJSON[T](s, data) }
+func (s *LLStack[T]) MarshalBinary() ([]byte, error)    { return marshalBinary[T](s) }
+func (s *LLStack[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](s, data) }
+func (s *LLStack[T]) MarshalJSON() ([]byte, error)      { return marshalJSON[T](s) }
+func (s *LLStack[T]) UnmarshalJSON(data []byte) error   { return unmarshalJSON[T](s, data) }
+func (s *LAStack[T]) MarshalBinary() ([]byte, error)    { return marshalBinary[T](s) }
+func (s *LAStack[T]) UnmarshalBinary(data []byte) error { return unmarshalBinary[T](s, data) }
+func (s *LAStack[T]) MarshalJSON() ([]byte, error)      { return marshalJSON[T](s) }
+func (s *LAStack[T]) UnmarshalJSON(data []byte) error   { return unmarshalJSON[T](s, data) }
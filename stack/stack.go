@@ -3,55 +3,95 @@ package stack
 import (
 	"sync/atomic"
 	"unsafe"
+
+	"github.com/min1324/data/backoff"
 )
 
-type Stack interface {
-	Push(i interface{}) bool
-	Pop() (val interface{}, ok bool)
+type Stack[T any] interface {
+	Push(i T) bool
+	Pop() (val T, ok bool)
 }
 
-type DataStack interface {
-	Stack
+type DataStack[T any] interface {
+	Stack[T]
+	// Top 返回栈顶的值，不取出。并发时的语义见各个栈。
+	Top() (val T, ok bool)
+	// Range 从栈顶到栈底遍历，不取出，f返回false时停止。一致性见各个栈。
+	Range(f func(v T) bool)
+	// Snapshot 从栈底到栈顶返回所有值，不取出，见包函数Snapshot。
+	Snapshot() []T
 	onceInit()
+	// snapshot 从栈底到栈顶返回所有值，见Snapshot
+	snapshot() []T
 	Init()
 	Size() int
 	Full() bool
 	Empty() bool
 }
 
+// AnyStack 兼容旧的interface{}接口。
+type AnyStack = Stack[interface{}]
+
+// AnyDataStack 兼容旧的interface{}接口。
+type AnyDataStack = DataStack[interface{}]
+
 const (
 	DefauleSize = 1 << 10
 	negativeOne = ^uint32(0) // -1
 )
 
-type stackNil *struct{}
-
-// 用一个包装nil值。
-var empty = stackNil(nil)
+// New return an empty lock-free unbounded list Stack of interface{},
+// 兼容旧的interface{}接口。
+func New() AnyStack {
+	return NewLLStack[interface{}]()
+}
 
-// New return an empty lock-free unbounded list Stack
-func New() Stack {
-	return &LLStack{}
+// lock-free 数组栈
+func NewLAStack[T any]() Stack[T] {
+	var s LAStack[T]
+	s.onceInit()
+	return &s
 }
 
-// // lock-free 数组栈
-// func NewLAStack() Stack {
-// 	return &LAStack{}
-// }
+// lock-free 数组栈,cas失败后按b退避，caps同InitWith。
+func NewLAStackWith[T any](b backoff.Backoff, caps ...int) Stack[T] {
+	var s LAStack[T]
+	s.bo = b
+	s.InitWith(caps...)
+	return &s
+}
 
 // lock-free 链表栈
-func NewLLStack() Stack {
-	return &LLStack{}
+func NewLLStack[T any]() Stack[T] {
+	var s LLStack[T]
+	s.onceInit()
+	return &s
+}
+
+// lock-free 链表栈,使用reclaim方式回收node。
+// 可选的b为cas失败后的退避策略，未提供时立即重试。
+func NewLLStackWith[T any](reclaim Reclaim, b ...backoff.Backoff) Stack[T] {
+	var s LLStack[T]
+	s.rc.Mode = reclaim
+	if len(b) > 0 {
+		s.bo = b[0]
+	}
+	s.onceInit()
+	return &s
 }
 
 // 单锁数组栈
-func NewSAStack() Stack {
-	return &SAStack{}
+func NewSAStack[T any]() Stack[T] {
+	var s SAStack[T]
+	s.onceInit()
+	return &s
 }
 
 // 单锁链表栈
-func NewSLStack() Stack {
-	return &SLStack{}
+func NewSLStack[T any]() Stack[T] {
+	var s SLStack[T]
+	s.onceInit()
+	return &s
 }
 
 func cas(p *unsafe.Pointer, old, new unsafe.Pointer) bool {
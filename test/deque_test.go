@@ -0,0 +1,106 @@
+package data_test
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/min1324/data/deque"
+)
+
+func TestCLDeque(t *testing.T) {
+	var d deque.CLDeque[int]
+	d.InitWith(4)
+	if v, ok := d.PopBottom(); ok {
+		t.Fatalf("empty PopBottom want:false, real:%d", v)
+	}
+	if v, ok := d.Steal(); ok {
+		t.Fatalf("empty Steal want:false, real:%d", v)
+	}
+	// 超过初始容量，自动扩容
+	const n = 100
+	for i := 0; i < n; i++ {
+		d.PushBottom(i)
+	}
+	if d.Size() != n || d.Cap() < n {
+		t.Fatalf("size want:%d, real:%d,cap:%d", n, d.Size(), d.Cap())
+	}
+	// owner后进先出，thief先进先出
+	for i := 0; i < n/2; i++ {
+		if v, ok := d.Steal(); !ok || v != i {
+			t.Fatalf("Steal want:%d, real:%d,%v", i, v, ok)
+		}
+		if v, ok := d.PopBottom(); !ok || v != n-1-i {
+			t.Fatalf("PopBottom want:%d, real:%d,%v", n-1-i, v, ok)
+		}
+	}
+	if !d.Empty() {
+		t.Fatalf("size want:0, real:%d", d.Size())
+	}
+	if v, ok := d.PopBottom(); ok {
+		t.Fatalf("drained PopBottom want:false, real:%d", v)
+	}
+	d.PushBottom(1)
+	d.Init()
+	if v, ok := d.Steal(); ok {
+		t.Fatalf("Init Steal want:false, real:%d", v)
+	}
+}
+
+func TestConcurrentCLDeque(t *testing.T) {
+	const thieves, maxNum = 4, 1 << 18
+	if runtime.GOMAXPROCS(0) < thieves+1 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(thieves + 1))
+	}
+	d := deque.NewCLDeque[int]()
+	var seen [maxNum]int32
+	var popped int64
+	var wg sync.WaitGroup
+	var done int32
+	for g := 0; g < thieves; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if v, ok := d.Steal(); ok {
+					atomic.AddInt32(&seen[v], 1)
+					atomic.AddInt64(&popped, 1)
+					continue
+				}
+				if atomic.LoadInt32(&done) == 1 {
+					return
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+	// owner交替加入和取出，让PopBottom和Steal竞争最后一个值
+	for i := 0; i < maxNum; i++ {
+		d.PushBottom(i)
+		if i%3 == 0 {
+			if v, ok := d.PopBottom(); ok {
+				atomic.AddInt32(&seen[v], 1)
+				atomic.AddInt64(&popped, 1)
+			}
+		}
+	}
+	for {
+		v, ok := d.PopBottom()
+		if !ok {
+			break
+		}
+		atomic.AddInt32(&seen[v], 1)
+		atomic.AddInt64(&popped, 1)
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+	if popped != maxNum {
+		t.Fatalf("PushBottom:%d, taken:%d", maxNum, popped)
+	}
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d taken %d times", v, seen[v])
+		}
+	}
+}
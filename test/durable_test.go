@@ -0,0 +1,239 @@
+package data_test
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/min1324/data/codec"
+	"github.com/min1324/data/queue"
+	"github.com/min1324/data/queue/durable"
+)
+
+func openDurable(t *testing.T, dir string, opt durable.Options) *durable.Queue[int] {
+	t.Helper()
+	q, err := durable.OpenWith[int](dir, codec.Gob[int]{}, opt)
+	if err != nil {
+		t.Fatalf("Open:%v", err)
+	}
+	return q
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDurableQueue(t *testing.T) {
+	const maxNum = 1 << 10
+	dir := t.TempDir()
+	// 小的日志文件，测试中会切换多个文件
+	opt := durable.Options{SegmentSize: 1 << 10}
+	q := openDurable(t, dir, opt)
+	var _ queue.Queue[int] = q
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("empty DeQueue want:false, real:%d", v)
+	}
+	for i := 0; i < maxNum; i++ {
+		if !q.EnQueue(i) {
+			t.Fatalf("EnQueue:%d fail,%v", i, q.Err())
+		}
+	}
+	if n := len(segmentFiles(t, dir)); n < 2 {
+		t.Fatalf("segments want >1, real:%d", n)
+	}
+	for i := 0; i < maxNum/2; i++ {
+		if v, ok := q.DeQueue(); !ok || v != i {
+			t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if q.EnQueue(-1) {
+		t.Fatal("closed EnQueue want:false")
+	}
+
+	// 重新打开，从上次出队的位置继续
+	q = openDurable(t, dir, opt)
+	if q.Size() != maxNum/2 {
+		t.Fatalf("reopen size want:%d, real:%d", maxNum/2, q.Size())
+	}
+	for i := maxNum / 2; i < maxNum; i++ {
+		if v, ok := q.DeQueue(); !ok || v != i {
+			t.Fatalf("reopen DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("drained DeQueue want:false, real:%d", v)
+	}
+	// 读取完的日志文件被删除
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Fatalf("segments after drain want:1, real:%d", n)
+	}
+	q.EnQueue(maxNum)
+	q.Close()
+
+	q = openDurable(t, dir, opt)
+	defer q.Close()
+	if v, ok := q.DeQueue(); !ok || v != maxNum {
+		t.Fatalf("DeQueue after compaction want:%d, real:%d,%v", maxNum, v, ok)
+	}
+	if q.Err() != nil {
+		t.Fatal(q.Err())
+	}
+}
+
+func TestDurableQueueTornRecord(t *testing.T) {
+	const maxNum = 1 << 4
+	dir := t.TempDir()
+	q := openDurable(t, dir, durable.Options{Sync: true})
+	for i := 0; i < maxNum; i++ {
+		q.EnQueue(i)
+	}
+	q.DeQueue()
+	q.Close()
+
+	// 模拟崩溃：最后一条记录只写了一半
+	files := segmentFiles(t, dir)
+	last := files[len(files)-1]
+	st, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(last, st.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openDurable(t, dir, durable.Options{})
+	if q.Size() != maxNum-2 {
+		t.Fatalf("recovered size want:%d, real:%d", maxNum-2, q.Size())
+	}
+	// 截断后可以继续写入
+	q.EnQueue(maxNum)
+	want := append(seqInts(1, maxNum-1), maxNum)
+	for _, w := range want {
+		if v, ok := q.DeQueue(); !ok || v != w {
+			t.Fatalf("recovered DeQueue want:%d, real:%d,%v", w, v, ok)
+		}
+	}
+	q.Close()
+
+	// 末尾的垃圾数据同样被截断
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xff, 0xff, 0, 0, 1, 2, 3, 4, 5})
+	f.Close()
+	q = openDurable(t, dir, durable.Options{})
+	defer q.Close()
+	if !q.Empty() || !q.EnQueue(1) {
+		t.Fatalf("garbage tail size:%d, %v", q.Size(), q.Err())
+	}
+	if v, ok := q.DeQueue(); !ok || v != 1 {
+		t.Fatalf("DeQueue after garbage want:1, real:%d,%v", v, ok)
+	}
+}
+
+func seqInts(from, to int) []int {
+	s := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+type durableItem struct {
+	ID   int
+	Name string
+}
+
+func TestDurableCodec(t *testing.T) {
+	gob.Register(durableItem{})
+	dir := t.TempDir()
+	q, err := durable.Open[interface{}](dir, codec.Gob[interface{}]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := []interface{}{1, "two", durableItem{3, "three"}}
+	for _, v := range vals {
+		if !q.EnQueue(v) {
+			t.Fatalf("EnQueue:%v fail,%v", v, q.Err())
+		}
+	}
+	q.Close()
+	q, err = durable.Open[interface{}](dir, codec.Gob[interface{}]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, want := range vals {
+		if v, ok := q.DeQueue(); !ok || v != want {
+			t.Fatalf("gob DeQueue want:%v, real:%v,%v", want, v, ok)
+		}
+	}
+
+	s, err := durable.Open[string](t.TempDir(), codec.String{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.EnQueue("")
+	s.EnQueue("hello")
+	if v, ok := s.DeQueue(); !ok || v != "" {
+		t.Fatalf("string DeQueue want empty, real:%q,%v", v, ok)
+	}
+	if v, ok := s.DeQueue(); !ok || v != "hello" {
+		t.Fatalf("string DeQueue want:hello, real:%q,%v", v, ok)
+	}
+}
+
+func TestConcurrentDurableQueue(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	q := openDurable(t, t.TempDir(), durable.Options{SegmentSize: 1 << 12})
+	defer q.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < maxNum; i++ {
+				q.EnQueue(g*maxNum + i)
+			}
+		}(g)
+	}
+	seen := make([]int, maxGo*maxNum)
+	last := make([]int, maxGo)
+	for i := range last {
+		last[i] = -1
+	}
+	for n := 0; n < maxGo*maxNum; {
+		v, ok := q.DeQueue()
+		if !ok {
+			continue
+		}
+		g := v / maxNum
+		if v <= last[g] {
+			t.Fatalf("order err,producer:%d,last:%d,real:%d", g, last[g], v)
+		}
+		last[g] = v
+		seen[v]++
+		n++
+	}
+	wg.Wait()
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("value:%d taken %d times", v, n)
+		}
+	}
+	if q.Err() != nil {
+		t.Fatal(q.Err())
+	}
+}
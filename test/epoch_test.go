@@ -0,0 +1,90 @@
+package data_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/min1324/data/epoch"
+)
+
+func TestEpochRetire(t *testing.T) {
+	reclaimed := make(map[unsafe.Pointer]int)
+	d := epoch.NewDomain(func(p unsafe.Pointer) {
+		reclaimed[p]++
+	})
+	p := unsafe.Pointer(new(int))
+	var deferred int
+
+	// reader一直Pin，阻止epoch前进
+	reader := d.Pin()
+
+	writer := d.Pin()
+	writer.Retire(p)
+	writer.Defer(func() { deferred++ })
+	writer.Unpin()
+	for i := 0; i < 4; i++ {
+		g := d.Pin()
+		g.Flush()
+		g.Unpin()
+	}
+	if reclaimed[p] != 0 || deferred != 0 {
+		t.Fatalf("reclaim while pinned,reclaimed:%d,deferred:%d", reclaimed[p], deferred)
+	}
+
+	// reader Unpin后，epoch可以前进两次，然后回收
+	reader.Unpin()
+	start := d.Epoch()
+	for i := 0; i < 4; i++ {
+		g := d.Pin()
+		g.Flush()
+		g.Unpin()
+	}
+	if d.Epoch() < start+2 {
+		t.Fatalf("epoch want >=%d, real:%d", start+2, d.Epoch())
+	}
+	if reclaimed[p] != 1 || deferred != 1 {
+		t.Fatalf("reclaim want 1,reclaimed:%d,deferred:%d", reclaimed[p], deferred)
+	}
+	g := d.Pin()
+	if g.Retired() != 0 {
+		t.Fatalf("Retired want:0, real:%d", g.Retired())
+	}
+	g.Unpin()
+}
+
+// 有goroutine一直Pin时，退休列表不会无限增长：超出的node直接交给GC，
+// 不调用回收函数，Defer的回调依旧在Unpin后执行。
+func TestEpochLimboLimit(t *testing.T) {
+	const maxNum = 1 << 14
+	var reclaimed, deferred int
+	d := epoch.NewDomain(func(p unsafe.Pointer) {
+		reclaimed++
+	})
+	reader := d.Pin()
+	writer := d.Pin()
+	writer.Defer(func() { deferred++ })
+	for i := 0; i < maxNum; i++ {
+		writer.Retire(unsafe.Pointer(new(int)))
+	}
+	if n := writer.Retired(); n >= maxNum/4 {
+		t.Fatalf("Retired while pinned want<%d, real:%d", maxNum/4, n)
+	}
+	writer.Unpin()
+	if reclaimed != 0 || deferred != 0 {
+		t.Fatalf("reclaim while pinned,reclaimed:%d,deferred:%d", reclaimed, deferred)
+	}
+	reader.Unpin()
+	for i := 0; i < 4; i++ {
+		g := d.Pin()
+		g.Flush()
+		g.Unpin()
+	}
+	if deferred != 1 {
+		t.Fatalf("deferred want 1, real:%d", deferred)
+	}
+	g := d.Pin()
+	if g.Retired() != 0 {
+		t.Fatalf("Retired want:0, real:%d", g.Retired())
+	}
+	g.Unpin()
+}
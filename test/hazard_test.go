@@ -0,0 +1,56 @@
+package data_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/min1324/data/hazard"
+)
+
+func TestHazardRetire(t *testing.T) {
+	reclaimed := make(map[unsafe.Pointer]int)
+	d := hazard.NewDomain(func(p unsafe.Pointer) {
+		reclaimed[p]++
+	})
+	var a, b = new(int), new(int)
+	pa, pb := unsafe.Pointer(a), unsafe.Pointer(b)
+
+	// reader保护a
+	reader := d.Acquire()
+	if p := reader.Protect(0, &pa); p != pa {
+		t.Fatalf("Protect want:%v, real:%v", pa, p)
+	}
+
+	writer := d.Acquire()
+	if writer == reader {
+		t.Fatalf("Acquire return an active record")
+	}
+	d.Retire(writer, pa)
+	d.Retire(writer, pb)
+	d.Scan(writer)
+	if reclaimed[pa] != 0 {
+		t.Fatalf("protected pointer reclaimed")
+	}
+	if reclaimed[pb] != 1 {
+		t.Fatalf("unprotected pointer want reclaim 1, real:%d", reclaimed[pb])
+	}
+	if writer.Retired() != 1 {
+		t.Fatalf("Retired want:1, real:%d", writer.Retired())
+	}
+
+	// 释放后可以回收
+	d.Release(reader)
+	d.Scan(writer)
+	if reclaimed[pa] != 1 {
+		t.Fatalf("released pointer want reclaim 1, real:%d", reclaimed[pa])
+	}
+	if writer.Retired() != 0 {
+		t.Fatalf("Retired want:0, real:%d", writer.Retired())
+	}
+	d.Release(writer)
+
+	// 归还的record可以复用
+	if r := d.Acquire(); r != writer && r != reader {
+		t.Fatalf("Acquire did not reuse released record")
+	}
+}
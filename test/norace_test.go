@@ -0,0 +1,6 @@
+//go:build !race
+
+package data_test
+
+// raceEnabled race detector打开时，并发测试缩小规模，避免超时和内存不足。
+const raceEnabled = false
@@ -46,17 +46,22 @@ func benchMap(b *testing.B, bench bench) {
 	for _, m := range [...]QInterface{
 		// queue
 		// &UnsafeQueue{},
-		// &queue.Chain{},
-		&queue.DLQueue{},
-		&queue.DRQueue{},
-		&queue.LLQueue{},
-		&queue.LRQueue{},
-		// &queue.SAQueue{},
-		// &queue.SLQueue{},
-		// &queue.SRQueue{},
-
-		// &queue.SLQueue{},
-		// &queue.SRQueue{},
+		// &queue.Chain[interface{}]{},
+		&queue.DLQueue[interface{}]{},
+		&queue.DRQueue[interface{}]{},
+		&queue.LLQueue[interface{}]{},
+		&EpochLLQueue{},
+		&queue.LRQueue[interface{}]{},
+		&queue.SPRQueue[interface{}]{},
+		&queue.LCRQueue[interface{}]{},
+		&queue.WLQueue[interface{}]{},
+		&queue.ShardQueue[interface{}]{},
+		// &queue.SAQueue[interface{}]{},
+		// &queue.SLQueue[interface{}]{},
+		// &queue.SRQueue[interface{}]{},
+
+		// &queue.SLQueue[interface{}]{},
+		// &queue.SRQueue[interface{}]{},
 
 		// // stack
 		// &MutexStack{},
@@ -65,15 +70,20 @@ func benchMap(b *testing.B, bench bench) {
 		b.Run(fmt.Sprintf("%T", m), func(b *testing.B) {
 			m = reflect.New(reflect.TypeOf(m).Elem()).Interface().(QInterface)
 			m.Init()
-			if q, ok := m.(*queue.LRQueue); ok {
+			if q, ok := m.(*queue.LRQueue[interface{}]); ok {
 				q.InitWith(queueMaxSize)
 			}
-			if q, ok := m.(*queue.DRQueue); ok {
+			if q, ok := m.(*queue.DRQueue[interface{}]); ok {
 				q.InitWith(queueMaxSize)
 			}
-			if q, ok := m.(*queue.SRQueue); ok {
+			if q, ok := m.(*queue.SRQueue[interface{}]); ok {
 				q.InitWith(queueMaxSize)
 			}
+			if q, ok := m.(*queue.SPRQueue[interface{}]); ok {
+				q.InitWith(queueMaxSize)
+				// 只允许一个生产者和一个消费者，RunParallel只用一个goroutine。
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+			}
 
 			// setup
 			if bench.setup != nil {
@@ -237,6 +247,9 @@ func BenchmarkConcurrentDeQueue(b *testing.B) {
 			if _, ok := m.(*UnsafeQueue); ok {
 				b.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := m.(*queue.SPRQueue[interface{}]); ok {
+				b.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(b *testing.B, pb *testing.PB, i int, m QInterface) {
 			var wg sync.WaitGroup
@@ -272,6 +285,9 @@ func BenchmarkConcurrentEnQueue(b *testing.B) {
 			if _, ok := m.(*UnsafeQueue); ok {
 				b.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := m.(*queue.SPRQueue[interface{}]); ok {
+				b.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(b *testing.B, pb *testing.PB, i int, m QInterface) {
 			var wg sync.WaitGroup
@@ -308,6 +324,9 @@ func BenchmarkConcurrentRand(b *testing.B) {
 			if _, ok := m.(*UnsafeQueue); ok {
 				b.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := m.(*queue.SPRQueue[interface{}]); ok {
+				b.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(b *testing.B, pb *testing.PB, i int, m QInterface) {
 			var wg sync.WaitGroup
@@ -380,6 +399,9 @@ func BenchmarkConcurrentMulRand(b *testing.B) {
 			if _, ok := m.(*UnsafeQueue); ok {
 				b.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := m.(*queue.SPRQueue[interface{}]); ok {
+				b.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(b *testing.B, pb *testing.PB, i int, m QInterface) {
 			exit := make(chan struct{}, 1)
@@ -418,3 +440,256 @@ func BenchmarkConcurrentMulRand(b *testing.B) {
 		},
 	})
 }
+
+// BenchmarkQueueMany 比较逐个操作和批量操作
+func BenchmarkQueueMany(b *testing.B) {
+	const batch = 1 << 7
+	for _, name := range []string{"SAQueue", "SRQueue", "DRQueue", "SLQueue", "DLQueue", "LLQueue", "EpochLLQueue", "LRQueue", "LCRQueue", "WLQueue", "ShardQueue"} {
+		b.Run(name+"/One", func(b *testing.B) {
+			q := dataQueueMap(batch)[name]
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < batch; j++ {
+					q.EnQueue(j)
+				}
+				for j := 0; j < batch; j++ {
+					q.DeQueue()
+				}
+			}
+		})
+		b.Run(name+"/Many", func(b *testing.B) {
+			q := dataQueueMap(batch)[name]
+			vals := make([]int, batch)
+			for i := 0; i < b.N; i++ {
+				q.EnQueueMany(vals)
+				q.DeQueueMany(vals)
+			}
+		})
+	}
+}
+
+// BenchmarkSPSC 一个生产者，一个消费者
+func BenchmarkSPSC(b *testing.B) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	}
+	const size = 1 << 10
+	var (
+		sp queue.SPRQueue[int]
+		lr queue.LRQueue[int]
+		sr queue.SRQueue[int]
+		dr queue.DRQueue[int]
+	)
+	sp.InitWith(size)
+	lr.InitWith(size)
+	sr.InitWith(size)
+	dr.InitWith(size)
+	for _, q := range [...]queue.DataQueue[int]{&sp, &lr, &sr, &dr} {
+		b.Run(fmt.Sprintf("%T", q), func(b *testing.B) {
+			q.Init()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < b.N; {
+					if q.EnQueue(i) {
+						i++
+					} else {
+						runtime.Gosched()
+					}
+				}
+			}()
+			for i := 0; i < b.N; {
+				if _, ok := q.DeQueue(); ok {
+					i++
+				} else {
+					runtime.Gosched()
+				}
+			}
+			<-done
+		})
+	}
+}
+
+// BenchmarkMPSC 多个生产者，一个消费者
+func BenchmarkMPSC(b *testing.B) {
+	for _, m := range [...]struct {
+		name string
+		q    queue.Queue[int]
+	}{
+		{"LLQueue", &queue.LLQueue[int]{}},
+		{"EpochLLQueue", queue.NewLLQueueWith[int](queue.ReclaimEpoch)},
+		{"MPSCQueue", &queue.MPSCQueue[int]{}},
+	} {
+		q := m.q
+		b.Run(m.name, func(b *testing.B) {
+			var wg sync.WaitGroup
+			exit := make(chan struct{})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, ok := q.DeQueue(); ok {
+						continue
+					}
+					select {
+					case <-exit:
+						return
+					default:
+						runtime.Gosched()
+					}
+				}
+			}()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					q.EnQueue(i)
+				}
+			})
+			close(exit)
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkBackoff 比较不同退避策略，负载同BenchmarkConcurrentMulRand：
+// 每个P一个goroutine，随机EnQueue,DeQueue。
+func BenchmarkBackoff(b *testing.B) {
+	const size = 1 << 10
+	const mod = size - 1
+	var random [size]int
+	for i := range random {
+		random[i] = rand.Intn(10) & 1
+	}
+	for _, name := range []string{"LLQueue", "EpochLLQueue", "LRQueue", "LKQueue"} {
+		for _, bo := range backoffs {
+			b.Run(name+"/"+bo.name, func(b *testing.B) {
+				q := backoffQueues(bo.b)[name]
+				for i := 0; i < prevEnQueueSize>>4; i++ {
+					q.EnQueue(i)
+				}
+				b.ResetTimer()
+				var id int64
+				b.RunParallel(func(pb *testing.PB) {
+					i := int(atomic.AddInt64(&id, 1)) * size / 7
+					for ; pb.Next(); i++ {
+						if random[i&mod] == 0 {
+							q.EnQueue(i)
+						} else {
+							q.DeQueue()
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// layoutProcs 测试伪共享的GOMAXPROCS，核数越多，缓存行失效的代价越明显。
+var layoutProcs = []int{2, 8, 32, 64}
+
+// benchProducerConsumer 一半goroutine只EnQueue，一半只DeQueue，
+// 生产者和消费者分别修改队尾和队头，用来观察两端字段的伪共享。
+func benchProducerConsumer(b *testing.B, newQueue func() queue.Queue[int]) {
+	for _, procs := range layoutProcs {
+		b.Run(fmt.Sprintf("procs-%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			q := newQueue()
+			for i := 0; i < 1<<10; i++ {
+				q.EnQueue(i)
+			}
+			var id int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				producer := atomic.AddInt64(&id, 1)&1 == 0
+				for i := 0; pb.Next(); i++ {
+					if producer {
+						q.EnQueue(i)
+					} else {
+						q.DeQueue()
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkQueueLayout 生产者消费者分开时LLQueue,LRQueue的吞吐量。
+// 只用到原有的接口，可以在调整字段布局前后的提交上分别运行，用benchstat比较:
+//
+//	go test -run NONE -bench QueueLayout -count 10 ./test > old.txt
+//	go test -run NONE -bench QueueLayout -count 10 ./test > new.txt
+//	benchstat old.txt new.txt
+func BenchmarkQueueLayout(b *testing.B) {
+	b.Run("LLQueue", func(b *testing.B) {
+		benchProducerConsumer(b, func() queue.Queue[int] {
+			return queue.NewLLQueue[int]()
+		})
+	})
+	b.Run("EpochLLQueue", func(b *testing.B) {
+		benchProducerConsumer(b, func() queue.Queue[int] {
+			return queue.NewLLQueueWith[int](queue.ReclaimEpoch)
+		})
+	})
+	b.Run("LRQueue", func(b *testing.B) {
+		benchProducerConsumer(b, func() queue.Queue[int] {
+			var q queue.LRQueue[int]
+			q.InitWith(1 << 12)
+			return &q
+		})
+	})
+}
+
+// BenchmarkPaddedSlots 比较LRQueue的slot紧密排列和每个slot独占缓存行。
+func BenchmarkPaddedSlots(b *testing.B) {
+	for _, pad := range []bool{false, true} {
+		name := "Compact"
+		if pad {
+			name = "Padded"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchProducerConsumer(b, func() queue.Queue[int] {
+				var q queue.LRQueue[int]
+				q.PadSlots(pad)
+				q.InitWith(1 << 12)
+				return &q
+			})
+		})
+	}
+}
+
+// BenchmarkFalseSharing 直接比较两个计数器相邻和用缓存行隔开，
+// 即调整前后LLQueue的head,tail和lrRing的enID,deID的布局。
+func BenchmarkFalseSharing(b *testing.B) {
+	type adjacent struct {
+		en, de uint32
+	}
+	type padded struct {
+		en uint32
+		_  [64]byte
+		de uint32
+		_  [64]byte
+	}
+	run := func(b *testing.B, en, de *uint32) {
+		for _, procs := range layoutProcs {
+			b.Run(fmt.Sprintf("procs-%d", procs), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+				var id int64
+				b.RunParallel(func(pb *testing.PB) {
+					p := en
+					if atomic.AddInt64(&id, 1)&1 == 0 {
+						p = de
+					}
+					for pb.Next() {
+						atomic.AddUint32(p, 1)
+					}
+				})
+			})
+		}
+	}
+	b.Run("Adjacent", func(b *testing.B) {
+		var c adjacent
+		run(b, &c.en, &c.de)
+	})
+	b.Run("Padded", func(b *testing.B) {
+		var c padded
+		run(b, &c.en, &c.de)
+	})
+}
@@ -2,14 +2,21 @@ package data_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/min1324/data/backoff"
+	"github.com/min1324/data/codec"
 	"github.com/min1324/data/queue"
 )
 
@@ -21,26 +28,32 @@ type queueStruct struct {
 func queueMap(t *testing.T, test queueStruct) {
 	for _, m := range [...]QInterface{
 		// &UnsafeQueue{},
-		// &queue.DLQueue{},
-		// &queue.DRQueue{},
-		&queue.LLQueue{},
-		// &queue.LRQueue{},
-		// &queue.SAQueue{},
-		// &queue.SLQueue{},
-		// &queue.SRQueue{},
-		// &queue.Chain{},
-		// &queue.LKQueue{},
+		// &queue.DLQueue[interface{}]{},
+		// &queue.DRQueue[interface{}]{},
+		&queue.LLQueue[interface{}]{},
+		&EpochLLQueue{},
+		&queue.SPRQueue[interface{}]{},
+		&queue.LRQueue[interface{}]{},
+		&queue.LCRQueue[interface{}]{},
+		&queue.WLQueue[interface{}]{},
+		&queue.ShardQueue[interface{}]{},
+		// &queue.SAQueue[interface{}]{},
+		// &queue.SLQueue[interface{}]{},
+		// &queue.SRQueue[interface{}]{},
+		// &queue.Chain[interface{}]{},
+		// &queue.LKQueue[interface{}]{},
 	} {
 		t.Run(fmt.Sprintf("%T", m), func(t *testing.T) {
 			m = reflect.New(reflect.TypeOf(m).Elem()).Interface().(QInterface)
 			m.Init()
-			// if q, ok := m.(*queue.LRQueue); ok {
-			// 	q.InitWith(queueMaxSize)
-			// }
-			if q, ok := m.(*queue.DRQueue); ok {
+			if q, ok := m.(*queue.LRQueue[interface{}]); ok {
+				// 从默认容量开始扩容，并发Init时不用每次都分配queueMaxSize个slot。
+				q.InitWith(queue.DefauleSize, queueMaxSize)
+			}
+			if q, ok := m.(*queue.DRQueue[interface{}]); ok {
 				q.InitWith(queueMaxSize)
 			}
-			if q, ok := m.(*queue.SRQueue); ok {
+			if q, ok := m.(*queue.SRQueue[interface{}]); ok {
 				q.InitWith(queueMaxSize)
 			}
 
@@ -48,6 +61,9 @@ func queueMap(t *testing.T, test queueStruct) {
 				test.setup(t, m)
 			}
 			test.perG(t, m)
+			// 大数据量的测试结束后马上回收，
+			// 否则下次GC的目标堆大小过高，后面的测试会耗尽内存。
+			runtime.GC()
 		})
 	}
 }
@@ -161,6 +177,10 @@ func TestInit(t *testing.T) {
 			if v, ok := s.DeQueue(); !ok || null != v {
 				t.Fatalf("EnQueue nil want:%v, real:%v", null, v)
 			}
+			s.EnQueue(nil)
+			if v, ok := s.DeQueue(); !ok || v != nil {
+				t.Fatalf("EnQueue nil want:%v, real:%v,%v", nil, v, ok)
+			}
 		},
 	})
 }
@@ -218,12 +238,19 @@ func TestDeQueue(t *testing.T) {
 func TestConcurrentInit(t *testing.T) {
 	const maxGo = 4
 	var timeout = time.Second * 5
+	if raceEnabled {
+		// 入队不等待，race模式下也会堆积大量值。
+		timeout = time.Second
+	}
 
 	queueMap(t, queueStruct{
 		setup: func(t *testing.T, s QInterface) {
 			if _, ok := s.(*UnsafeQueue); ok {
 				t.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := s.(*queue.SPRQueue[interface{}]); ok {
+				t.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(t *testing.T, s QInterface) {
 			var wg sync.WaitGroup
@@ -295,6 +322,9 @@ func TestConcurrentEnQueue(t *testing.T) {
 			if _, ok := s.(*UnsafeQueue); ok {
 				t.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := s.(*queue.SPRQueue[interface{}]); ok {
+				t.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(t *testing.T, s QInterface) {
 			var wg sync.WaitGroup
@@ -329,14 +359,23 @@ func TestConcurrentEnQueue(t *testing.T) {
 }
 
 func TestConcurrentDeQueue(t *testing.T) {
-	const maxGo, maxNum = 64, 1 << 20
-	const maxSize = maxGo * maxNum
+	const maxGo = 64
+	// 每个队列预先入队maxSize个值，queueMap中的队列依次测试，
+	// 1<<20时值和node超出测试机内存。
+	var maxNum = 1 << 18
+	if raceEnabled {
+		maxNum = 1 << 12
+	}
+	var maxSize = maxGo * maxNum
 
 	queueMap(t, queueStruct{
 		setup: func(t *testing.T, s QInterface) {
 			if _, ok := s.(*UnsafeQueue); ok {
 				t.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := s.(*queue.SPRQueue[interface{}]); ok {
+				t.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(t *testing.T, s QInterface) {
 			var wg sync.WaitGroup
@@ -385,6 +424,9 @@ func TestConcurrentEnQueueDeQueue(t *testing.T) {
 			if _, ok := s.(*UnsafeQueue); ok {
 				t.Skip("UnsafeQueue can not test concurrent.")
 			}
+			if _, ok := s.(*queue.SPRQueue[interface{}]); ok {
+				t.Skip("SPRQueue only allows one producer and one consumer.")
+			}
 		},
 		perG: func(t *testing.T, s QInterface) {
 			var DeQueueWG sync.WaitGroup
@@ -414,7 +456,8 @@ func TestConcurrentEnQueueDeQueue(t *testing.T) {
 							v, ok := s.DeQueue()
 							if ok {
 								if v == nil {
-									t.Fatal("err:v nil")
+									t.Error("err:v nil")
+									return
 								}
 								atomic.AddInt64(&sumDeQueue, 1)
 							}
@@ -442,3 +485,2139 @@ func TestConcurrentEnQueueDeQueue(t *testing.T) {
 		},
 	})
 }
+
+func TestGenericQueue(t *testing.T) {
+	const maxNum = 1 << 8
+	for name, newQueue := range map[string]func() queue.Queue[int]{
+		"DLQueue":  queue.NewDLQueue[int],
+		"DRQueue":  queue.NewDRQueue[int],
+		"LLQueue":  queue.NewLLQueue[int],
+		"LRQueue":  queue.NewLRQueue[int],
+		"SAQueue":  queue.NewSAQueue[int],
+		"SLQueue":  queue.NewSLQueue[int],
+		"SRQueue":  queue.NewSRQueue[int],
+		"Chain":    queue.NewChain[int],
+		"LCRQueue": queue.NewLCRQueue[int],
+		"WLQueue":  queue.NewWLQueue[int],
+		"ShardQueue": func() queue.Queue[int] {
+			return queue.NewShardQueue[int](0, nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue()
+			if v, ok := q.DeQueue(); ok {
+				t.Fatalf("empty DeQueue want:false, real:%v", v)
+			}
+			// 零值也能正常储存
+			for i := 0; i < maxNum; i++ {
+				if !q.EnQueue(i) {
+					t.Fatalf("EnQueue %d fail", i)
+				}
+			}
+			for i := 0; i < maxNum; i++ {
+				v, ok := q.DeQueue()
+				if !ok || v != i {
+					t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			if v, ok := q.DeQueue(); ok {
+				t.Fatalf("after DeQueue want:false, real:%v", v)
+			}
+		})
+	}
+}
+
+// 复现LLQueue DeQueue中BUG memory reclamation描述的情况：
+// 队列长度很短，node被频繁回收复用，
+// 如果回收不安全，会出现值丢失、重复出队或者顺序错乱。
+func TestLLQueueReclamation(t *testing.T) {
+	testReclamation(t, &queue.LLQueue[int]{})
+}
+
+func TestLLQueueEpochReclamation(t *testing.T) {
+	testReclamation(t, queue.NewLLQueueWith[int](queue.ReclaimEpoch).(*queue.LLQueue[int]))
+}
+
+func TestLKQueueReclamation(t *testing.T) {
+	testReclamation(t, queue.NewLKQueue[int]())
+}
+
+func testReclamation(t *testing.T, q interface {
+	queue.Queue[int]
+	Size() int
+}) {
+	const maxGo, maxNum = 8, 1 << 18
+	// 单核时也需要多个线程交替执行，才能触发竞争。
+	if runtime.GOMAXPROCS(0) < maxGo {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo))
+	}
+	var seen [maxGo * maxNum]int32
+	var enWG, deWG sync.WaitGroup
+	var done int32
+
+	for g := 0; g < maxGo; g++ {
+		enWG.Add(1)
+		go func(g int) {
+			defer enWG.Done()
+			for i := 0; i < maxNum; i++ {
+				q.EnQueue(g*maxNum + i)
+			}
+		}(g)
+	}
+	dequeue := func(last []int) bool {
+		v, ok := q.DeQueue()
+		if !ok {
+			return false
+		}
+		atomic.AddInt32(&seen[v], 1)
+		// 同一个生产者的值，出队顺序必须递增。
+		g := v / maxNum
+		if v <= last[g] {
+			t.Errorf("order err,producer:%d,last:%d,real:%d", g, last[g], v)
+		}
+		last[g] = v
+		return true
+	}
+	for g := 0; g < maxGo; g++ {
+		deWG.Add(1)
+		go func() {
+			defer deWG.Done()
+			last := make([]int, maxGo)
+			for i := range last {
+				last[i] = -1
+			}
+			for atomic.LoadInt32(&done) == 0 {
+				dequeue(last)
+			}
+		}()
+	}
+	enWG.Wait()
+	atomic.StoreInt32(&done, 1)
+	deWG.Wait()
+
+	last := make([]int, maxGo)
+	for i := range last {
+		last[i] = -1
+	}
+	for dequeue(last) {
+	}
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d DeQueue %d times", v, seen[v])
+		}
+	}
+	if q.Size() != 0 {
+		t.Fatalf("size want:0, real:%d", q.Size())
+	}
+}
+
+// dataQueueMap 所有DataQueue实现，有界队列容量为size。
+func dataQueueMap(size int) map[string]queue.DataQueue[int] {
+	var (
+		sr queue.SRQueue[int]
+		dr queue.DRQueue[int]
+		lr queue.LRQueue[int]
+		gr queue.LRQueue[int]
+		pr queue.LRQueue[int]
+		sp queue.SPRQueue[int]
+		lc queue.LCRQueue[int]
+	)
+	sr.InitWith(size)
+	dr.InitWith(size)
+	lr.InitWith(size)
+	gr.InitWith(size/8, size)
+	// 每个slot独占缓存行，同样会扩容
+	pr.PadSlots(true)
+	pr.InitWith(size/8, size)
+	sp.InitWith(size)
+	// 小的ring,测试中会连接多个ring
+	lc.InitWith(size / 8)
+	// 4路LRQueue,总容量为size
+	sh := queue.NewShardQueue[int](4, func() queue.Shard[int] {
+		var q queue.LRQueue[int]
+		q.InitWith(size / 4)
+		return &q
+	}).(*queue.ShardQueue[int])
+	return map[string]queue.DataQueue[int]{
+		"SAQueue":      &queue.SAQueue[int]{},
+		"SRQueue":      &sr,
+		"DRQueue":      &dr,
+		"SLQueue":      &queue.SLQueue[int]{},
+		"DLQueue":      &queue.DLQueue[int]{},
+		"LLQueue":      &queue.LLQueue[int]{},
+		"EpochLLQueue": queue.NewLLQueueWith[int](queue.ReclaimEpoch).(*queue.LLQueue[int]),
+		"LRQueue":      &lr,
+		"GrowLRQueue":  &gr,
+		"PadLRQueue":   &pr,
+		"SPRQueue":     &sp,
+		"LCRQueue":     &lc,
+		"WLQueue":      &queue.WLQueue[int]{},
+		"ShardQueue":   sh,
+	}
+}
+
+// skipSPSC 多生产者或者多消费者的测试跳过SPRQueue
+func skipSPSC(t *testing.T, q interface{}) {
+	if _, ok := q.(*queue.SPRQueue[int]); ok {
+		t.Skip("SPRQueue only allows one producer and one consumer.")
+	}
+}
+
+func TestQueueMany(t *testing.T) {
+	const size = 1 << 6
+	for name, q := range dataQueueMap(size) {
+		t.Run(name, func(t *testing.T) {
+			if n := q.EnQueueMany(nil); n != 0 {
+				t.Fatalf("EnQueueMany nil want:0, real:%d", n)
+			}
+			if n := q.DeQueueMany(make([]int, 4)); n != 0 {
+				t.Fatalf("empty DeQueueMany want:0, real:%d", n)
+			}
+			vals := make([]int, size+size/2)
+			for i := range vals {
+				vals[i] = i
+			}
+			want := len(vals)
+			if q.Cap() == size {
+				want = size
+			}
+			n := q.EnQueueMany(vals[:1])
+			n += q.EnQueueMany(vals[1:])
+			if n != want || q.Size() != want {
+				t.Fatalf("EnQueueMany want:%d, real:%d,size:%d", want, n, q.Size())
+			}
+			// 批量和单个操作交替，顺序不变
+			dst := make([]int, 3)
+			for i := 0; i < want; {
+				if i%2 == 0 {
+					v, ok := q.DeQueue()
+					if !ok || v != i {
+						t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+					}
+					i++
+					continue
+				}
+				n := q.DeQueueMany(dst)
+				if n == 0 {
+					t.Fatalf("DeQueueMany at %d return 0", i)
+				}
+				for _, v := range dst[:n] {
+					if v != i {
+						t.Fatalf("DeQueueMany want:%d, real:%d", i, v)
+					}
+					i++
+				}
+			}
+			if n := q.DeQueueMany(dst); n != 0 || !q.Empty() || q.Size() != 0 {
+				t.Fatalf("after DeQueueMany want empty, real:%d,size:%d", n, q.Size())
+			}
+		})
+	}
+}
+
+func TestConcurrentQueueMany(t *testing.T) {
+	const maxGo, maxNum, batch = 4, 1 << 14, 7
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	for name, q := range dataQueueMap(1 << 8) {
+		t.Run(name, func(t *testing.T) {
+			skipSPSC(t, q)
+			// ShardQueue是宽松FIFO，同一个生产者的值可能乱序
+			_, relaxed := q.(*queue.ShardQueue[int])
+			var seen [maxGo * maxNum]int32
+			var enWG, deWG sync.WaitGroup
+			var done int32
+			for g := 0; g < maxGo; g++ {
+				enWG.Add(1)
+				go func(g int) {
+					defer enWG.Done()
+					vals := make([]int, 0, batch)
+					for i := 0; i < maxNum; {
+						vals = vals[:0]
+						for j := i; j < maxNum && len(vals) < batch; j++ {
+							vals = append(vals, g*maxNum+j)
+						}
+						n := q.EnQueueMany(vals)
+						if n == 0 {
+							runtime.Gosched()
+						}
+						i += n
+					}
+				}(g)
+			}
+			dequeue := func(dst, last []int) int {
+				n := q.DeQueueMany(dst)
+				for _, v := range dst[:n] {
+					atomic.AddInt32(&seen[v], 1)
+					g := v / maxNum
+					if v <= last[g] && !relaxed {
+						t.Errorf("order err,producer:%d,last:%d,real:%d", g, last[g], v)
+					}
+					last[g] = v
+				}
+				return n
+			}
+			for g := 0; g < maxGo; g++ {
+				deWG.Add(1)
+				go func() {
+					defer deWG.Done()
+					dst := make([]int, batch)
+					last := make([]int, maxGo)
+					for i := range last {
+						last[i] = -1
+					}
+					for atomic.LoadInt32(&done) == 0 {
+						if dequeue(dst, last) == 0 {
+							runtime.Gosched()
+						}
+					}
+					for dequeue(dst, last) > 0 {
+					}
+				}()
+			}
+			enWG.Wait()
+			atomic.StoreInt32(&done, 1)
+			deWG.Wait()
+			for v := range seen {
+				if seen[v] != 1 {
+					t.Fatalf("value:%d DeQueue %d times", v, seen[v])
+				}
+			}
+			if q.Size() != 0 {
+				t.Fatalf("size want:0, real:%d", q.Size())
+			}
+		})
+	}
+}
+
+func TestLRQueueGrow(t *testing.T) {
+	const initCap, maxCap = 4, 1 << 6
+	var q queue.LRQueue[int]
+	q.InitWith(initCap, maxCap)
+	if q.Cap() != initCap {
+		t.Fatalf("init cap want:%d, real:%d", initCap, q.Cap())
+	}
+	// 交替出队入队，让旧ring的ID绕过一圈再扩容。
+	for i := 0; i < initCap*3; i++ {
+		q.EnQueue(i)
+		q.DeQueue()
+	}
+	var n int
+	for q.EnQueue(n) {
+		n++
+		if n == initCap*2 {
+			// 扩容期间取出的依旧是最早的值
+			if v, ok := q.DeQueue(); !ok || v != 0 {
+				t.Fatalf("DeQueue while growing want:0, real:%d,%v", v, ok)
+			}
+		}
+	}
+	if q.Cap() != maxCap || !q.Full() {
+		t.Fatalf("cap want:%d, real:%d,full:%v", maxCap, q.Cap(), q.Full())
+	}
+	if q.Size() != n-1 {
+		t.Fatalf("size want:%d, real:%d", n-1, q.Size())
+	}
+	for i := 1; i < n; i++ {
+		v, ok := q.DeQueue()
+		if !ok || v != i {
+			t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	if !q.Empty() || q.Size() != 0 {
+		t.Fatalf("after DeQueue want empty, real size:%d", q.Size())
+	}
+
+	// Init保留最大容量,重新从cap开始扩容
+	q.EnQueue(1)
+	q.Init()
+	if q.Cap() != initCap || !q.Empty() {
+		t.Fatalf("Init want cap:%d and empty, real cap:%d,size:%d", initCap, q.Cap(), q.Size())
+	}
+	for i := 0; i < maxCap; i++ {
+		if !q.EnQueue(i) {
+			t.Fatalf("EnQueue %d after Init fail", i)
+		}
+	}
+}
+
+func TestConcurrentLRQueueGrow(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 16
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	var q queue.LRQueue[int]
+	q.InitWith(2, 1<<12)
+	var seen [maxGo * maxNum]int32
+	var enWG, deWG sync.WaitGroup
+	var done int32
+	for g := 0; g < maxGo; g++ {
+		enWG.Add(1)
+		go func(g int) {
+			defer enWG.Done()
+			for i := 0; i < maxNum; {
+				if q.EnQueue(g*maxNum + i) {
+					i++
+				} else {
+					runtime.Gosched()
+				}
+			}
+		}(g)
+	}
+	dequeue := func(last []int) bool {
+		v, ok := q.DeQueue()
+		if !ok {
+			return false
+		}
+		atomic.AddInt32(&seen[v], 1)
+		g := v / maxNum
+		if v <= last[g] {
+			t.Errorf("order err,producer:%d,last:%d,real:%d", g, last[g], v)
+		}
+		last[g] = v
+		return true
+	}
+	for g := 0; g < maxGo; g++ {
+		deWG.Add(1)
+		go func() {
+			defer deWG.Done()
+			last := make([]int, maxGo)
+			for i := range last {
+				last[i] = -1
+			}
+			for atomic.LoadInt32(&done) == 0 {
+				if !dequeue(last) {
+					runtime.Gosched()
+				}
+			}
+			for dequeue(last) {
+			}
+		}()
+	}
+	enWG.Wait()
+	atomic.StoreInt32(&done, 1)
+	deWG.Wait()
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d DeQueue %d times", v, seen[v])
+		}
+	}
+	if q.Size() != 0 || q.Cap() <= 2 {
+		t.Fatalf("size want:0, real:%d,cap:%d", q.Size(), q.Cap())
+	}
+}
+
+// ctxQueue 可以阻塞出队的队列
+type ctxQueue interface {
+	queue.DataQueue[int]
+	DeQueueCtx(ctx context.Context) (int, error)
+}
+
+func TestDeQueueCtx(t *testing.T) {
+	for name, q := range dataQueueMap(1 << 4) {
+		q, ok := q.(ctxQueue)
+		if !ok {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			if v, err := q.DeQueueCtx(ctx); err != context.DeadlineExceeded {
+				t.Fatalf("empty DeQueueCtx want:%v, real:%d,%v", context.DeadlineExceeded, v, err)
+			}
+			// 阻塞到其他goroutine入队
+			go func() {
+				time.Sleep(time.Millisecond)
+				q.EnQueue(1)
+			}()
+			if v, err := q.DeQueueCtx(context.Background()); err != nil || v != 1 {
+				t.Fatalf("DeQueueCtx want:1, real:%d,%v", v, err)
+			}
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(time.Millisecond)
+				cancel()
+			}()
+			if _, err := q.DeQueueCtx(ctx); err != context.Canceled {
+				t.Fatalf("cancel DeQueueCtx want:%v, real:%v", context.Canceled, err)
+			}
+		})
+	}
+}
+
+func TestEnQueueCtx(t *testing.T) {
+	const size = 1 << 4
+	for name, q := range dataQueueMap(size) {
+		q, ok := q.(queue.BlockingQueue[int])
+		if !ok {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			for i := 0; q.EnQueue(i); i++ {
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			if err := q.EnQueueCtx(ctx, -1); err != context.DeadlineExceeded {
+				t.Fatalf("full EnQueueCtx want:%v, real:%v", context.DeadlineExceeded, err)
+			}
+			// 阻塞到其他goroutine出队。
+			// 扩容的LRQueue要先取完旧ring，才有空间。
+			n := q.Size() - q.Cap() + 1
+			go func() {
+				time.Sleep(time.Millisecond)
+				for i := 0; i < n; i++ {
+					q.DeQueue()
+				}
+			}()
+			if err := q.EnQueueCtx(context.Background(), -1); err != nil {
+				t.Fatalf("EnQueueCtx want:nil, real:%v", err)
+			}
+		})
+	}
+}
+
+func TestConcurrentQueueCtx(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 12
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	for name, q := range dataQueueMap(1 << 3) {
+		q, ok := q.(queue.BlockingQueue[int])
+		if !ok {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			skipSPSC(t, q)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			var seen [maxGo * maxNum]int32
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(2)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						if err := q.EnQueueCtx(ctx, g*maxNum+i); err != nil {
+							t.Errorf("EnQueueCtx err:%v", err)
+							return
+						}
+					}
+				}(g)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						v, err := q.DeQueueCtx(ctx)
+						if err != nil {
+							t.Errorf("DeQueueCtx err:%v", err)
+							return
+						}
+						atomic.AddInt32(&seen[v], 1)
+					}
+				}()
+			}
+			wg.Wait()
+			for v := range seen {
+				if seen[v] != 1 {
+					t.Fatalf("value:%d DeQueue %d times", v, seen[v])
+				}
+			}
+		})
+	}
+}
+
+func TestLRQueuePutWait(t *testing.T) {
+	var q queue.LRQueue[int]
+	q.InitWith(2)
+	for q.EnQueue(0) {
+	}
+	if ok, err := q.PutWait(1, time.Millisecond); ok || err != context.DeadlineExceeded {
+		t.Fatalf("full PutWait want:false,%v, real:%v,%v", context.DeadlineExceeded, ok, err)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	const size = 1 << 4
+	for name, q := range dataQueueMap(size) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < size/2; i++ {
+				q.EnQueue(i)
+			}
+			select {
+			case <-q.Done():
+				t.Fatalf("Done closed before Close")
+			default:
+			}
+			q.Close()
+			q.Close()
+			<-q.Done()
+			if q.EnQueue(-1) {
+				t.Fatalf("EnQueue after Close want:false")
+			}
+			if n := q.EnQueueMany([]int{-1, -2}); n != 0 {
+				t.Fatalf("EnQueueMany after Close want:0, real:%d", n)
+			}
+			// 关闭后继续取出剩下的值
+			for i := 0; i < size/2; i++ {
+				if v, ok := q.DeQueue(); !ok || v != i {
+					t.Fatalf("DeQueue after Close want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			if v, ok := q.DeQueue(); ok {
+				t.Fatalf("drained DeQueue want:false, real:%d", v)
+			}
+			if q, ok := q.(ctxQueue); ok {
+				if _, err := q.DeQueueCtx(context.Background()); err != queue.ErrClosed {
+					t.Fatalf("drained DeQueueCtx want:%v, real:%v", queue.ErrClosed, err)
+				}
+			}
+			if q, ok := q.(queue.BlockingQueue[int]); ok {
+				if err := q.EnQueueCtx(context.Background(), -1); err != queue.ErrClosed {
+					t.Fatalf("EnQueueCtx after Close want:%v, real:%v", queue.ErrClosed, err)
+				}
+			}
+			// Init不会重新打开队列
+			q.Init()
+			if q.EnQueue(-1) {
+				t.Fatalf("EnQueue after Init want:false")
+			}
+		})
+	}
+}
+
+func TestCloseWakeup(t *testing.T) {
+	const size = 1 << 4
+	wait := func(t *testing.T, errs chan error) {
+		select {
+		case err := <-errs:
+			if err != queue.ErrClosed {
+				t.Fatalf("blocked op want:%v, real:%v", queue.ErrClosed, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("blocked op not woken by Close")
+		}
+	}
+	for name, q := range dataQueueMap(size) {
+		t.Run(name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			// 队列空时阻塞的DeQueueCtx
+			if q, ok := q.(ctxQueue); ok {
+				go func() {
+					_, err := q.DeQueueCtx(context.Background())
+					errs <- err
+				}()
+				time.Sleep(time.Millisecond)
+				q.Close()
+				wait(t, errs)
+			}
+			// 队列满时阻塞的EnQueueCtx
+			if q, ok := dataQueueMap(size)[name].(queue.BlockingQueue[int]); ok {
+				for q.EnQueue(0) {
+				}
+				go func() {
+					errs <- q.EnQueueCtx(context.Background(), -1)
+				}()
+				time.Sleep(time.Millisecond)
+				q.Close()
+				wait(t, errs)
+			}
+		})
+	}
+}
+
+func TestConcurrentQueueClose(t *testing.T) {
+	const maxGo = 4
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	for name, q := range dataQueueMap(1 << 3) {
+		q, ok := q.(ctxQueue)
+		if !ok {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			skipSPSC(t, q)
+			// 生产者入队直到Close，消费者取到ErrClosed，
+			// 关闭前成功入队的值都要被取出。
+			var enCount, deCount int64
+			var wg, pwg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				pwg.Add(1)
+				go func() {
+					defer pwg.Done()
+					for {
+						select {
+						case <-q.Done():
+							if q.EnQueue(1) {
+								t.Errorf("EnQueue after Done")
+							}
+							return
+						default:
+						}
+						if q.EnQueue(1) {
+							atomic.AddInt64(&enCount, 1)
+						} else {
+							runtime.Gosched()
+						}
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for {
+						_, err := q.DeQueueCtx(context.Background())
+						if err == queue.ErrClosed {
+							return
+						}
+						if err != nil {
+							t.Errorf("DeQueueCtx err:%v", err)
+							return
+						}
+						atomic.AddInt64(&deCount, 1)
+					}
+				}()
+			}
+			time.Sleep(10 * time.Millisecond)
+			q.Close()
+			pwg.Wait()
+			wg.Wait()
+			if enCount != deCount || !q.Empty() {
+				t.Fatalf("EnQueue:%d, DeQueue:%d, empty:%v", enCount, deCount, q.Empty())
+			}
+		})
+	}
+}
+
+func TestConcurrentSPRQueue(t *testing.T) {
+	const maxNum = 1 << 20
+	if runtime.GOMAXPROCS(0) < 2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	}
+	var q queue.SPRQueue[int]
+	q.InitWith(1 << 6)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 交替单个和批量入队
+		vals := make([]int, 7)
+		for i := 0; i < maxNum; {
+			if q.Full() {
+				runtime.Gosched()
+				continue
+			}
+			if i&1 == 0 {
+				if q.EnQueue(i) {
+					i++
+				}
+				continue
+			}
+			n := len(vals)
+			if maxNum-i < n {
+				n = maxNum - i
+			}
+			for j := 0; j < n; j++ {
+				vals[j] = i + j
+			}
+			i += q.EnQueueMany(vals[:n])
+		}
+	}()
+	dst := make([]int, 5)
+	for want := 0; want < maxNum; {
+		if q.Empty() {
+			runtime.Gosched()
+			continue
+		}
+		if want&1 == 0 {
+			if v, ok := q.DeQueue(); ok {
+				if v != want {
+					t.Fatalf("DeQueue want:%d, real:%d", want, v)
+				}
+				want++
+			}
+			continue
+		}
+		n := q.DeQueueMany(dst)
+		for _, v := range dst[:n] {
+			if v != want {
+				t.Fatalf("DeQueueMany want:%d, real:%d", want, v)
+			}
+			want++
+		}
+	}
+	<-done
+	if !q.Empty() {
+		t.Fatalf("size want:0, real:%d", q.Size())
+	}
+}
+
+func TestSPRQueuePipeline(t *testing.T) {
+	const maxNum = 1 << 16
+	if runtime.GOMAXPROCS(0) < 2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	}
+	var q queue.SPRQueue[int]
+	q.InitWith(1 << 4)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go func() {
+		for i := 0; i < maxNum; i++ {
+			if err := q.EnQueueCtx(ctx, i); err != nil {
+				t.Errorf("EnQueueCtx err:%v", err)
+				return
+			}
+		}
+		q.Close()
+	}()
+	var want int
+	for {
+		v, err := q.DeQueueCtx(ctx)
+		if err == queue.ErrClosed {
+			break
+		}
+		if err != nil || v != want {
+			t.Fatalf("DeQueueCtx want:%d, real:%d,%v", want, v, err)
+		}
+		want++
+	}
+	if want != maxNum {
+		t.Fatalf("DeQueueCtx count want:%d, real:%d", maxNum, want)
+	}
+}
+
+func TestMPSCQueue(t *testing.T) {
+	var q queue.MPSCQueue[int]
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("empty DeQueue want:false, real:%d,%v", v, ok)
+	}
+	for i := 0; i < 10; i++ {
+		q.EnQueue(i)
+	}
+	for i := 0; i < 5; i++ {
+		if v, ok := q.DeQueue(); !ok || v != i {
+			t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	q.Init()
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("Init DeQueue want:false, real:%d,%v", v, ok)
+	}
+	q.EnQueue(1)
+	if v, ok := q.DeQueue(); !ok || v != 1 {
+		t.Fatalf("DeQueue want:1, real:%d,%v", v, ok)
+	}
+}
+
+func TestConcurrentMPSCQueue(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 16
+	if runtime.GOMAXPROCS(0) < maxGo+1 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo + 1))
+	}
+	var q queue.MPSCQueue[int]
+	for g := 0; g < maxGo; g++ {
+		go func(g int) {
+			for i := 0; i < maxNum; i++ {
+				q.EnQueue(g*maxNum + i)
+			}
+		}(g)
+	}
+	// 每个生产者的值按入队顺序取出
+	var next [maxGo]int
+	for n := 0; n < maxGo*maxNum; {
+		v, ok := q.DeQueue()
+		if !ok {
+			runtime.Gosched()
+			continue
+		}
+		g, i := v/maxNum, v%maxNum
+		if next[g] != i {
+			t.Fatalf("producer:%d want:%d, real:%d", g, next[g], i)
+		}
+		next[g]++
+		n++
+	}
+	if v, ok := q.DeQueue(); ok {
+		t.Fatalf("drained DeQueue want:false, real:%d", v)
+	}
+}
+
+func TestConcurrentLCRQueue(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 16
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	// 很小的ring,入队经常关闭ring并连接新的ring
+	var q queue.LCRQueue[int]
+	q.InitWith(4)
+	var wg sync.WaitGroup
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < maxNum; i++ {
+				if !q.EnQueue(g*maxNum + i) {
+					t.Errorf("EnQueue:%d fail", g*maxNum+i)
+					return
+				}
+			}
+		}(g)
+	}
+	var seen [maxGo * maxNum]int32
+	var taken int64
+	var cw sync.WaitGroup
+	for c := 0; c < maxGo; c++ {
+		cw.Add(1)
+		go func() {
+			defer cw.Done()
+			// 每个消费者看到同一个生产者的值是递增的
+			var last [maxGo]int
+			for i := range last {
+				last[i] = -1
+			}
+			for atomic.LoadInt64(&taken) < maxGo*maxNum {
+				v, ok := q.DeQueue()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				g, i := v/maxNum, v%maxNum
+				if i <= last[g] {
+					t.Errorf("producer:%d out of order, last:%d, real:%d", g, last[g], i)
+				}
+				last[g] = i
+				atomic.AddInt32(&seen[v], 1)
+				atomic.AddInt64(&taken, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	cw.Wait()
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d taken %d times", v, seen[v])
+		}
+	}
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("drained DeQueue want:false, real:%d,size:%d", v, q.Size())
+	}
+}
+
+// 批量入队出队在很小的ring上，值恰好取出一次，同一个生产者的值保持顺序。
+func TestConcurrentLCRQueueMany(t *testing.T) {
+	const maxGo, maxNum, batch = 4, 1 << 14, 7
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	var q queue.LCRQueue[int]
+	q.InitWith(8)
+	var wg sync.WaitGroup
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			vals := make([]int, batch)
+			for i := 0; i < maxNum; i += batch {
+				n := 0
+				for ; n < batch && i+n < maxNum; n++ {
+					vals[n] = g*maxNum + i + n
+				}
+				if m := q.EnQueueMany(vals[:n]); m != n {
+					t.Errorf("EnQueueMany want:%d, real:%d", n, m)
+					return
+				}
+			}
+		}(g)
+	}
+	var seen [maxGo * maxNum]int32
+	var taken int64
+	var cw sync.WaitGroup
+	for c := 0; c < maxGo; c++ {
+		cw.Add(1)
+		go func() {
+			defer cw.Done()
+			var last [maxGo]int
+			for i := range last {
+				last[i] = -1
+			}
+			dst := make([]int, batch)
+			for atomic.LoadInt64(&taken) < maxGo*maxNum {
+				n := q.DeQueueMany(dst)
+				if n == 0 {
+					runtime.Gosched()
+					continue
+				}
+				for _, v := range dst[:n] {
+					g, i := v/maxNum, v%maxNum
+					if i <= last[g] {
+						t.Errorf("producer:%d out of order, last:%d, real:%d", g, last[g], i)
+					}
+					last[g] = i
+					atomic.AddInt32(&seen[v], 1)
+				}
+				atomic.AddInt64(&taken, int64(n))
+			}
+		}()
+	}
+	wg.Wait()
+	cw.Wait()
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d taken %d times", v, seen[v])
+		}
+	}
+	if !q.Empty() {
+		t.Fatalf("drained size:%d", q.Size())
+	}
+}
+
+// Close和正在进行的EnQueue并发，DeQueueCtx返回ErrClosed之前，
+// 成功入队的值都已经取出，不会留在队列中。
+func TestLCRQueueCloseDrained(t *testing.T) {
+	const maxGo, rounds = 4, 1 << 8
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	for r := 0; r < rounds; r++ {
+		var q queue.LCRQueue[int]
+		q.InitWith(4)
+		var enCount, deCount int64
+		var wg sync.WaitGroup
+		for g := 0; g < maxGo; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for q.EnQueue(1) {
+					atomic.AddInt64(&enCount, 1)
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, err := q.DeQueueCtx(context.Background()); err != nil {
+					return
+				}
+				atomic.AddInt64(&deCount, 1)
+			}
+		}()
+		runtime.Gosched()
+		q.Close()
+		wg.Wait()
+		<-done
+		if enCount != deCount {
+			t.Fatalf("round:%d EnQueue:%d, DeQueue:%d, size:%d", r, enCount, deCount, q.Size())
+		}
+	}
+}
+
+func TestShardQueue(t *testing.T) {
+	const shards, size = 4, 1 << 4
+	// 每一路是容量为size的SRQueue
+	q := queue.NewShardQueue[int](shards, func() queue.Shard[int] {
+		var s queue.SRQueue[int]
+		s.InitWith(size)
+		return &s
+	}).(*queue.ShardQueue[int])
+	if q.Cap() != shards*size {
+		t.Fatalf("cap want:%d, real:%d", shards*size, q.Cap())
+	}
+	// 顺序操作，没有一路满或者空时是FIFO
+	for i := 0; i < shards*size; i++ {
+		if !q.EnQueue(i) {
+			t.Fatalf("EnQueue:%d fail", i)
+		}
+	}
+	if !q.Full() || q.EnQueue(-1) {
+		t.Fatalf("full want:true, size:%d", q.Size())
+	}
+	for i := 0; i < shards*size; i++ {
+		if v, ok := q.DeQueue(); !ok || v != i {
+			t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	if v, ok := q.DeQueue(); ok || !q.Empty() {
+		t.Fatalf("empty DeQueue want:false, real:%d", v)
+	}
+	// 空队列DeQueue后游标依旧对齐
+	for i := 0; i < shards+1; i++ {
+		q.EnQueue(i)
+	}
+	for i := 0; i < shards+1; i++ {
+		if v, ok := q.DeQueue(); !ok || v != i {
+			t.Fatalf("after empty DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+
+	// 重新创建为2路无界队列，Init不会重新打开关闭的队列
+	q.InitWith(2, func() queue.Shard[int] { return &queue.LLQueue[int]{} })
+	if q.Full() || !q.Empty() {
+		t.Fatalf("InitWith full:%v, size:%d", q.Full(), q.Size())
+	}
+	q.EnQueue(1)
+	q.Close()
+	if q.EnQueue(2) {
+		t.Fatal("closed EnQueue want:false")
+	}
+	q.InitWith(0, nil)
+	if q.EnQueue(3) {
+		t.Fatal("InitWith reopened closed queue")
+	}
+
+	// 每一路是不能关闭的Chain，ShardQueue自己拦截关闭后的EnQueue
+	cq := queue.NewShardQueue[int](shards, func() queue.Shard[int] {
+		return queue.NewChain[int]().(*queue.Chain[int])
+	}).(*queue.ShardQueue[int])
+	// 没有Cap,Full时当作无界队列
+	if cq.Full() || cq.Cap() < shards*size {
+		t.Fatalf("Chain shards full:%v, cap:%d", cq.Full(), cq.Cap())
+	}
+	for i := 0; i < shards*size; i++ {
+		cq.EnQueue(i)
+	}
+	if snap := cq.Snapshot(); len(snap) != shards*size || snap[0] != 0 || snap[len(snap)-1] != shards*size-1 {
+		t.Fatalf("Chain shards Snapshot len:%d", len(snap))
+	}
+	cq.Close()
+	if cq.EnQueue(-1) {
+		t.Fatal("Chain shards closed EnQueue want:false")
+	}
+	for i := 0; i < shards*size; i++ {
+		if v, ok := cq.DeQueue(); !ok || v != i {
+			t.Fatalf("Chain shards DeQueue want:%d, real:%d,%v", i, v, ok)
+		}
+	}
+	if _, err := cq.DeQueueCtx(context.Background()); err != queue.ErrClosed {
+		t.Fatalf("Chain shards drained want:%v, real:%v", queue.ErrClosed, err)
+	}
+}
+
+func TestConcurrentShardQueue(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 15
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	q := queue.NewShardQueue[int](maxGo, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < maxNum; i++ {
+				q.EnQueue(g*maxNum + i)
+			}
+		}(g)
+	}
+	// 宽松FIFO不保证顺序，只检查每个值取出一次
+	var seen [maxGo * maxNum]int32
+	var taken int64
+	var cw sync.WaitGroup
+	for c := 0; c < maxGo; c++ {
+		cw.Add(1)
+		go func() {
+			defer cw.Done()
+			for atomic.LoadInt64(&taken) < maxGo*maxNum {
+				v, ok := q.DeQueue()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				atomic.AddInt32(&seen[v], 1)
+				atomic.AddInt64(&taken, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	cw.Wait()
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d taken %d times", v, seen[v])
+		}
+	}
+}
+
+func TestChanAdapter(t *testing.T) {
+	const maxNum = 1 << 10
+	for name, q := range map[string]queue.Queue[int]{
+		"LLQueue": queue.NewLLQueue[int](),
+		"Chain":   queue.NewChain[int](),
+		"LRQueue": queue.NewLRQueue[int](),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in, out := queue.In(ctx, q), queue.Out(ctx, q)
+			go func() {
+				for i := 0; i < maxNum; i++ {
+					in <- i
+				}
+				// 关闭in会关闭DataQueue,out取完后关闭
+				close(in)
+			}()
+			for i := 0; i < maxNum; i++ {
+				if v, ok := <-out; !ok || v != i {
+					t.Fatalf("Out want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			// Chain不能关闭，取消ctx后out关闭
+			if _, ok := q.(queue.DataQueue[int]); !ok {
+				cancel()
+			}
+			if v, ok := <-out; ok {
+				t.Fatalf("Out want closed, real:%d", v)
+			}
+		})
+	}
+
+	// 无界队列，没有接收者时发送不会阻塞
+	var ll queue.LLQueue[int]
+	in := queue.In[int](context.Background(), &ll)
+	for i := 0; i < maxNum; i++ {
+		in <- i
+	}
+	close(in)
+	<-ll.Done()
+	if ll.Size() != maxNum {
+		t.Fatalf("In size want:%d, real:%d", maxNum, ll.Size())
+	}
+
+	// 没有Close的队列，select中接收，取消ctx后out关闭
+	ctx, cancel := context.WithCancel(context.Background())
+	mq := queue.NewMPSCQueue[int]()
+	mq.EnQueue(1)
+	out := queue.Out(ctx, mq)
+	select {
+	case v := <-out:
+		if v != 1 {
+			t.Fatalf("MPSCQueue Out want:1, real:%d", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("MPSCQueue Out timeout")
+	}
+	cancel()
+	select {
+	case v, ok := <-out:
+		if ok {
+			t.Fatalf("MPSCQueue Out want closed, real:%d", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("MPSCQueue Out not closed after cancel")
+	}
+
+	// 有DeQueueCtx的队列挂起等待，Close后out关闭
+	var lc queue.LCRQueue[int]
+	out = queue.Out[int](context.Background(), &lc)
+	lc.EnQueue(1)
+	if v := <-out; v != 1 {
+		t.Fatalf("LCRQueue Out want:1, real:%d", v)
+	}
+	lc.Close()
+	select {
+	case v, ok := <-out:
+		if ok {
+			t.Fatalf("LCRQueue Out want closed, real:%d", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LCRQueue Out not closed after Close")
+	}
+}
+
+func TestPump(t *testing.T) {
+	const maxNum = 1 << 10
+	// 小的有界队列，满时Pump等待出队
+	var q queue.SRQueue[int]
+	q.InitWith(1 << 3)
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < maxNum; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	res := make(chan error, 1)
+	go func() {
+		n, err := queue.Pump[int](context.Background(), ch, &q)
+		if err == nil && n != maxNum {
+			err = fmt.Errorf("Pump want:%d, real:%d", maxNum, n)
+		}
+		res <- err
+	}()
+	for i := 0; i < maxNum; i++ {
+		v, err := q.DeQueueCtx(context.Background())
+		if err != nil || v != i {
+			t.Fatalf("DeQueueCtx want:%d, real:%d,%v", i, v, err)
+		}
+	}
+	if err := <-res; err != nil {
+		t.Fatal(err)
+	}
+
+	// 队列关闭后Pump返回ErrClosed
+	var ll queue.LLQueue[int]
+	ll.Close()
+	ch = make(chan int, 1)
+	ch <- 1
+	if n, err := queue.Pump[int](context.Background(), ch, &ll); n != 0 || err != queue.ErrClosed {
+		t.Fatalf("closed Pump want:0,%v, real:%d,%v", queue.ErrClosed, n, err)
+	}
+
+	// 不能挂起的队列满时轮询，ctx超时后返回
+	var cq queue.Chain[int]
+	var sp queue.SPRQueue[int]
+	sp.InitWith(1)
+	sp.EnQueue(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ch = make(chan int, 1)
+	ch <- 1
+	if _, err := queue.Pump[int](ctx, ch, &sp); err != context.DeadlineExceeded {
+		t.Fatalf("full Pump want:%v, real:%v", context.DeadlineExceeded, err)
+	}
+	if _, err := queue.Pump[int](ctx, make(chan int), &cq); err != context.DeadlineExceeded {
+		t.Fatalf("Pump want:%v, real:%v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestQueueSnapshot(t *testing.T) {
+	const size = 1 << 6
+	for name, q := range dataQueueMap(size) {
+		t.Run(name, func(t *testing.T) {
+			if vals := queue.Snapshot(q); len(vals) != 0 {
+				t.Fatalf("empty Snapshot want:[], real:%v", vals)
+			}
+			// 先取出几个，快照从deID开始
+			want := seqInts(0, size/2)
+			q.EnQueueMany(want)
+			for i := 0; i < 3; i++ {
+				q.DeQueue()
+			}
+			want = want[3:]
+			if vals := queue.Snapshot(q); !reflect.DeepEqual(vals, want) || q.Size() != len(want) {
+				t.Fatalf("Snapshot want:%v, real:%v,size:%d", want, vals, q.Size())
+			}
+
+			// 恢复到其他实现
+			data, err := q.(interface{ MarshalBinary() ([]byte, error) }).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range []queue.DataQueue[int]{&queue.LLQueue[int]{}, &queue.SRQueue[int]{}, q} {
+				r.EnQueue(-1)
+				if err = r.(interface{ UnmarshalBinary([]byte) error }).UnmarshalBinary(data); err != nil {
+					t.Fatalf("%T UnmarshalBinary:%v", r, err)
+				}
+				if vals := queue.Snapshot(r); !reflect.DeepEqual(vals, want) {
+					t.Fatalf("%T restore want:%v, real:%v", r, want, vals)
+				}
+			}
+
+			// json,放在结构体中
+			type checkpoint struct {
+				Queue queue.DataQueue[int]
+			}
+			js, err := json.Marshal(checkpoint{q})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lr queue.LRQueue[int]
+			if err = json.Unmarshal(js, &checkpoint{&lr}); err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range want {
+				if v, ok := lr.DeQueue(); !ok || v != w {
+					t.Fatalf("json restore DeQueue want:%d, real:%d,%v", w, v, ok)
+				}
+			}
+
+			// 自定义codec
+			data, err = queue.Marshal[int](q, codec.JSON[int]{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sl queue.SLQueue[int]
+			if err = queue.Unmarshal[int](&sl, data, codec.JSON[int]{}); err != nil {
+				t.Fatal(err)
+			}
+			if vals := queue.Snapshot[int](&sl); !reflect.DeepEqual(vals, want) {
+				t.Fatalf("codec restore want:%v, real:%v", want, vals)
+			}
+
+			// json格式使用自定义codec
+			js, err = queue.MarshalJSON[int](q, hexCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(want) > 0 && !strings.Contains(string(js), `"0x`) {
+				t.Fatalf("MarshalJSON not use codec:%s", js)
+			}
+			var ll queue.LLQueue[int]
+			if err = queue.UnmarshalJSON[int](&ll, js, hexCodec{}); err != nil {
+				t.Fatal(err)
+			}
+			if vals := queue.Snapshot[int](&ll); !reflect.DeepEqual(vals, want) {
+				t.Fatalf("json codec restore want:%v, real:%v", want, vals)
+			}
+		})
+	}
+}
+
+// hexCodec 将int编码成json字符串"0x..."，和codec.JSON的格式不同。
+type hexCodec struct{}
+
+func (hexCodec) Encode(val int) ([]byte, error) {
+	return json.Marshal("0x" + strconv.FormatInt(int64(val), 16))
+}
+
+func (hexCodec) Decode(data []byte) (int, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(strings.TrimPrefix(s, "0x"), 16, 64)
+	return int(v), err
+}
+
+func TestQueueRestoreErr(t *testing.T) {
+	var sr queue.SRQueue[int]
+	sr.InitWith(4)
+	if err := queue.Restore[int](&sr, seqInts(0, 6)); err != queue.ErrFull {
+		t.Fatalf("Restore full want:ErrFull, real:%v", err)
+	}
+	if vals := queue.Snapshot[int](&sr); !reflect.DeepEqual(vals, seqInts(0, 4)) {
+		t.Fatalf("Restore full want:%v, real:%v", seqInts(0, 4), vals)
+	}
+	var ll queue.LLQueue[int]
+	ll.Close()
+	if err := queue.Restore[int](&ll, seqInts(0, 2)); err != queue.ErrClosed {
+		t.Fatalf("Restore closed want:ErrClosed, real:%v", err)
+	}
+	data, _ := queue.Marshal[int](&sr, codec.Gob[int]{})
+	for _, bad := range [][]byte{nil, {0}, data[:len(data)-1], append(data, 0)} {
+		if err := queue.Unmarshal[int](&sr, bad, codec.Gob[int]{}); !errors.Is(err, codec.ErrFormat) {
+			t.Fatalf("Unmarshal %v want:ErrFormat, real:%v", bad, err)
+		}
+	}
+	// Gob的输出不是json
+	if _, err := queue.MarshalJSON[int](&sr, codec.Gob[int]{}); err != codec.ErrNotJSON {
+		t.Fatalf("MarshalJSON gob want:ErrNotJSON, real:%v", err)
+	}
+}
+
+// 快照和EnQueue并发，每个生产者的值在快照中是从0开始连续的。
+func TestConcurrentQueueSnapshot(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	for name, q := range dataQueueMap(maxGo * maxNum) {
+		if _, ok := q.(*queue.ShardQueue[int]); ok {
+			// 多路之间不是同一时刻
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			skipSPSC(t, q)
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						q.EnQueue(g*maxNum + i)
+					}
+				}(g)
+			}
+			var done int32
+			go func() {
+				wg.Wait()
+				atomic.StoreInt32(&done, 1)
+			}()
+			last := 0
+			for atomic.LoadInt32(&done) == 0 {
+				vals := queue.Snapshot(q)
+				if len(vals) < last {
+					t.Fatalf("snapshot shrink, last:%d, real:%d", last, len(vals))
+				}
+				last = len(vals)
+				var next [maxGo]int
+				for _, v := range vals {
+					g, i := v/maxNum, v%maxNum
+					if i != next[g] {
+						t.Fatalf("producer:%d want:%d, real:%d", g, next[g], i)
+					}
+					next[g]++
+				}
+			}
+			if vals := queue.Snapshot(q); len(vals) != maxGo*maxNum {
+				t.Fatalf("final snapshot want:%d, real:%d", maxGo*maxNum, len(vals))
+			}
+		})
+	}
+}
+
+// Snapshot,Range可以在EnQueue,DeQueue的同时调用，不会阻塞：
+// 得到的值没有重复，同一个生产者的值保持入队顺序(ShardQueue多路之间不保证)。
+func TestQueueSnapshotLive(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 13
+	if runtime.GOMAXPROCS(0) < maxGo*2 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo * 2))
+	}
+	// 容量很小，slot会被反复复用
+	for name, q := range dataQueueMap(1 << 3) {
+		t.Run(name, func(t *testing.T) {
+			producers, consumers := maxGo, maxGo
+			_, spsc := q.(*queue.SPRQueue[int])
+			if spsc {
+				// Snapshot只能由唯一的消费者调用
+				producers, consumers = 1, 1
+			}
+			_, sharded := q.(*queue.ShardQueue[int])
+			check := func(op string, vals []int) {
+				var next [maxGo]int
+				seen := make(map[int]bool, len(vals))
+				for _, v := range vals {
+					g, i := v/maxNum, v%maxNum
+					if seen[v] || (!sharded && i < next[g]) {
+						t.Errorf("%s producer:%d value:%d repeated or out of order, want>=%d", op, g, i, next[g])
+						return
+					}
+					seen[v] = true
+					next[g] = i + 1
+				}
+			}
+			look := func() {
+				check("Snapshot", q.Snapshot())
+				var vals []int
+				q.Range(func(v int) bool {
+					vals = append(vals, v)
+					return true
+				})
+				check("Range", vals)
+				data, err := q.(json.Marshaler).MarshalJSON()
+				if err != nil {
+					t.Errorf("MarshalJSON: %v", err)
+					return
+				}
+				vals = vals[:0]
+				if err := json.Unmarshal(data, &vals); err != nil {
+					t.Errorf("MarshalJSON: %v", err)
+					return
+				}
+				check("MarshalJSON", vals)
+			}
+			var wg sync.WaitGroup
+			var taken int32
+			for g := 0; g < producers; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						for !q.EnQueue(g*maxNum + i) {
+							runtime.Gosched()
+						}
+					}
+				}(g)
+			}
+			for c := 0; c < consumers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for n := 0; atomic.LoadInt32(&taken) < int32(producers*maxNum); n++ {
+						if _, ok := q.DeQueue(); ok {
+							atomic.AddInt32(&taken, 1)
+						} else {
+							runtime.Gosched()
+						}
+						if spsc && n%64 == 0 {
+							look()
+						}
+					}
+				}()
+			}
+			var done int32
+			looked := make(chan int)
+			go func() {
+				n := 0
+				for ; !spsc && atomic.LoadInt32(&done) == 0; n++ {
+					look()
+					runtime.Gosched()
+				}
+				looked <- n
+			}()
+			finished := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("EnQueue,DeQueue blocked, taken:%d", atomic.LoadInt32(&taken))
+			}
+			atomic.StoreInt32(&done, 1)
+			select {
+			case <-looked:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Snapshot blocked after EnQueue,DeQueue finished")
+			}
+		})
+	}
+}
+
+func TestQueuePeek(t *testing.T) {
+	const size = 1 << 6
+	type peeker interface {
+		queue.Queue[int]
+		Peek() (int, bool)
+	}
+	qs := map[string]peeker{
+		"LKQueue":   &queue.LKQueue[int]{},
+		"Chain":     &queue.Chain[int]{},
+		"MPSCQueue": &queue.MPSCQueue[int]{},
+	}
+	for name, q := range dataQueueMap(size) {
+		qs[name] = q
+	}
+	for name, q := range qs {
+		t.Run(name, func(t *testing.T) {
+			if v, ok := q.Peek(); ok {
+				t.Fatalf("empty Peek want:false, real:%d", v)
+			}
+			n := size / 2
+			for i := 0; i < n; i++ {
+				q.EnQueue(i)
+			}
+			for i := 0; i < n; i++ {
+				// Peek不会取出
+				for j := 0; j < 2; j++ {
+					if v, ok := q.Peek(); !ok || v != i {
+						t.Fatalf("Peek want:%d, real:%d,%v", i, v, ok)
+					}
+				}
+				if v, ok := q.DeQueue(); !ok || v != i {
+					t.Fatalf("DeQueue want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			if v, ok := q.Peek(); ok {
+				t.Fatalf("drained Peek want:false, real:%d", v)
+			}
+		})
+	}
+}
+
+// Peek和EnQueue,DeQueue并发，看到同一个生产者的值是递增的，
+// DeQueue取出的值不会丢失或者重复。
+func TestConcurrentQueuePeek(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 12
+	for name, q := range dataQueueMap(1 << 10) {
+		t.Run(name, func(t *testing.T) {
+			skipSPSC(t, q)
+			if _, ok := q.(*queue.ShardQueue[int]); ok {
+				t.Skip("ShardQueue is relaxed FIFO.")
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; {
+						if q.EnQueue(g*maxNum + i) {
+							i++
+						} else {
+							runtime.Gosched()
+						}
+					}
+				}(g)
+			}
+			var seen [maxGo * maxNum]int32
+			var taken int64
+			for c := 0; c < maxGo; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.LoadInt64(&taken) < maxGo*maxNum {
+						v, ok := q.DeQueue()
+						if !ok {
+							runtime.Gosched()
+							continue
+						}
+						atomic.AddInt32(&seen[v], 1)
+						atomic.AddInt64(&taken, 1)
+					}
+				}()
+			}
+			for p := 0; p < 2; p++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var last [maxGo]int
+					for atomic.LoadInt64(&taken) < maxGo*maxNum {
+						v, ok := q.Peek()
+						if !ok {
+							runtime.Gosched()
+							continue
+						}
+						g, i := v/maxNum, v%maxNum
+						if i < last[g] {
+							t.Errorf("producer:%d Peek go back, last:%d, real:%d", g, last[g], i)
+							return
+						}
+						last[g] = i
+					}
+				}()
+			}
+			wg.Wait()
+			for v := range seen {
+				if seen[v] != 1 {
+					t.Fatalf("value:%d taken %d times", v, seen[v])
+				}
+			}
+		})
+	}
+}
+
+// Peek,Range,Snapshot和DeQueue同时读写同一个slot，用-race运行时不能有数据竞争。
+// ring很小，slot会被反复取出和覆盖；值是string，读到清空或者覆盖了一半的值时无法解析。
+func TestConcurrentQueuePeekDeQueue(t *testing.T) {
+	const maxGo = 4
+	maxNum := 1 << 12
+	if raceEnabled {
+		maxNum = 1 << 10
+	}
+	var (
+		lr queue.LRQueue[string]
+		gr queue.LRQueue[string]
+		lc queue.LCRQueue[string]
+	)
+	lr.InitWith(8)
+	gr.InitWith(2, 16)
+	lc.InitWith(8)
+	for name, q := range map[string]queue.DataQueue[string]{
+		"LRQueue":     &lr,
+		"GrowLRQueue": &gr,
+		"LCRQueue":    &lc,
+		"LLQueue":     &queue.LLQueue[string]{},
+		"WLQueue":     &queue.WLQueue[string]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			total := int64(maxGo * maxNum)
+			check := func(op, v string) bool {
+				if n, err := strconv.Atoi(v); err != nil || n < 0 || int64(n) >= total {
+					t.Errorf("%s read a torn value:%q", op, v)
+					return false
+				}
+				return true
+			}
+			var wg sync.WaitGroup
+			var taken int64
+			for g := 0; g < maxGo; g++ {
+				wg.Add(2)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; {
+						if q.EnQueue(strconv.Itoa(g*maxNum + i)) {
+							i++
+						} else {
+							runtime.Gosched()
+						}
+					}
+				}(g)
+				go func() {
+					defer wg.Done()
+					for atomic.LoadInt64(&taken) < total {
+						if v, ok := q.DeQueue(); ok {
+							check("DeQueue", v)
+							atomic.AddInt64(&taken, 1)
+						} else {
+							runtime.Gosched()
+						}
+					}
+				}()
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for atomic.LoadInt64(&taken) < total {
+					if v, ok := q.Peek(); ok && !check("Peek", v) {
+						return
+					}
+					q.Range(func(v string) bool {
+						return check("Range", v)
+					})
+					for _, v := range q.Snapshot() {
+						if !check("Snapshot", v) {
+							return
+						}
+					}
+					runtime.Gosched()
+				}
+			}()
+			wg.Wait()
+		})
+	}
+}
+
+func TestQueueRange(t *testing.T) {
+	const size = 1 << 6
+	for name, q := range dataQueueMap(size) {
+		t.Run(name, func(t *testing.T) {
+			q.Range(func(v int) bool {
+				t.Fatalf("empty Range visit:%d", v)
+				return false
+			})
+			want := seqInts(0, size/2)
+			q.EnQueueMany(want)
+			for i := 0; i < 3; i++ {
+				q.DeQueue()
+			}
+			want = want[3:]
+			var vals []int
+			q.Range(func(v int) bool {
+				vals = append(vals, v)
+				return true
+			})
+			if !reflect.DeepEqual(vals, want) || q.Size() != len(want) {
+				t.Fatalf("Range want:%v, real:%v,size:%d", want, vals, q.Size())
+			}
+			if vals := q.Snapshot(); !reflect.DeepEqual(vals, want) {
+				t.Fatalf("Snapshot want:%v, real:%v", want, vals)
+			}
+			// f返回false停止
+			vals = vals[:0]
+			q.Range(func(v int) bool {
+				vals = append(vals, v)
+				return len(vals) < 2
+			})
+			if !reflect.DeepEqual(vals, want[:2]) {
+				t.Fatalf("Range stop want:%v, real:%v", want[:2], vals)
+			}
+		})
+	}
+}
+
+// LLQueue的Range和EnQueue,DeQueue并发：每个生产者的值按顺序访问，不重复，
+// 读到的node不会是已经回收复用的。
+func TestConcurrentQueueRange(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 12
+	for name, q := range map[string]queue.DataQueue[int]{
+		"LLQueue":      &queue.LLQueue[int]{},
+		"EpochLLQueue": queue.NewLLQueueWith[int](queue.ReclaimEpoch).(*queue.LLQueue[int]),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(2)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						q.EnQueue(g*maxNum + i)
+					}
+				}(g)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < maxNum; {
+						if _, ok := q.DeQueue(); ok {
+							i++
+						} else {
+							runtime.Gosched()
+						}
+					}
+				}()
+			}
+			var done int32
+			go func() {
+				wg.Wait()
+				atomic.StoreInt32(&done, 1)
+			}()
+			for atomic.LoadInt32(&done) == 0 {
+				next := [maxGo]int{}
+				q.Range(func(v int) bool {
+					g, i := v/maxNum, v%maxNum
+					if g < 0 || g >= maxGo || i < next[g] {
+						t.Fatalf("producer:%d want>=%d, real:%d", g, next[g], i)
+					}
+					next[g] = i + 1
+					return true
+				})
+			}
+			if vals := q.Snapshot(); len(vals) != 0 {
+				t.Fatalf("drained Snapshot want:[], real:%v", vals)
+			}
+		})
+	}
+}
+
+// backoffs 测试和benchmark使用的退避策略，nil为立即重试。
+var backoffs = []struct {
+	name string
+	b    backoff.Backoff
+}{
+	{"None", nil},
+	{"Spin", backoff.Spin{}},
+	{"Exponential", backoff.Exponential{}},
+	{"Gosched", backoff.Gosched{}},
+	{"Sleep", backoff.Sleep{}},
+}
+
+// backoffQueues 可以注入退避策略的队列
+func backoffQueues(b backoff.Backoff) map[string]queue.Queue[int] {
+	return map[string]queue.Queue[int]{
+		"LLQueue":      queue.NewLLQueueWith[int](queue.ReclaimHazard, b),
+		"EpochLLQueue": queue.NewLLQueueWith[int](queue.ReclaimEpoch, b),
+		"LRQueue":      queue.NewLRQueueWith[int](b, 1<<6, 1<<16),
+		"LKQueue":      queue.NewLKQueueWith[int](b),
+	}
+}
+
+func TestBackoffWait(t *testing.T) {
+	for _, bo := range backoffs {
+		for _, n := range []int{0, 1, 10, 31, 32, 63, 1 << 20} {
+			backoff.Wait(bo.b, n)
+		}
+	}
+	// 自定义范围，Limit小于Base时自旋Limit次
+	for _, n := range []int{0, 5, 64} {
+		backoff.Exponential{Base: 8, Limit: 2}.Wait(n)
+		backoff.Exponential{Base: 1, Limit: 1}.Wait(n)
+	}
+}
+
+// 注入退避策略后，并发EnQueue,DeQueue的值不会丢失或者重复。
+func TestQueueBackoff(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	for _, bo := range backoffs {
+		for name, q := range backoffQueues(bo.b) {
+			t.Run(bo.name+"/"+name, func(t *testing.T) {
+				var wg sync.WaitGroup
+				var seen [maxGo * maxNum]int32
+				for g := 0; g < maxGo; g++ {
+					wg.Add(2)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < maxNum; i++ {
+							for !q.EnQueue(g*maxNum + i) {
+								runtime.Gosched()
+							}
+						}
+					}(g)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < maxNum; {
+							if v, ok := q.DeQueue(); ok {
+								atomic.AddInt32(&seen[v], 1)
+								i++
+							} else {
+								runtime.Gosched()
+							}
+						}
+					}()
+				}
+				wg.Wait()
+				for v, n := range seen {
+					if n != 1 {
+						t.Fatalf("value:%d DeQueue %d times", v, n)
+					}
+				}
+			})
+		}
+	}
+}
+
+type overflowQueue interface {
+	queue.BlockingQueue[int]
+	SetOverflow(policy queue.Overflow, dropped func(val int))
+}
+
+// overflowQueues 容量为size的有界环形队列
+func overflowQueues(size int) map[string]overflowQueue {
+	var (
+		sr queue.SRQueue[int]
+		dr queue.DRQueue[int]
+		lr queue.LRQueue[int]
+		gr queue.LRQueue[int]
+	)
+	sr.InitWith(size)
+	dr.InitWith(size)
+	lr.InitWith(size)
+	gr.InitWith(size/4, size)
+	return map[string]overflowQueue{
+		"SRQueue":     &sr,
+		"DRQueue":     &dr,
+		"LRQueue":     &lr,
+		"GrowLRQueue": &gr,
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	const size = 1 << 3
+	fill := func(q overflowQueue) {
+		for i := 0; i < size; i++ {
+			if !q.EnQueue(i) {
+				t.Fatalf("EnQueue %d before full failed", i)
+			}
+		}
+	}
+	check := func(t *testing.T, q overflowQueue, want []int) {
+		if got := q.Snapshot(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("queue want:%v, real:%v", want, got)
+		}
+	}
+	seq := func(from, to int) []int {
+		vals := make([]int, 0, to-from)
+		for i := from; i < to; i++ {
+			vals = append(vals, i)
+		}
+		return vals
+	}
+	for name := range overflowQueues(size) {
+		if name == "GrowLRQueue" {
+			// 扩容后旧ring的空位不能再EnQueue，满之前加入的数量大于size
+			continue
+		}
+		t.Run(name+"/Reject", func(t *testing.T) {
+			q := overflowQueues(size)[name]
+			var dropped []int
+			q.SetOverflow(queue.Reject, func(v int) { dropped = append(dropped, v) })
+			fill(q)
+			if q.EnQueue(size) {
+				t.Fatalf("full EnQueue want:false")
+			}
+			if n := q.EnQueueMany([]int{size, size + 1}); n != 0 {
+				t.Fatalf("full EnQueueMany want:0, real:%d", n)
+			}
+			check(t, q, seq(0, size))
+			if len(dropped) != 0 {
+				t.Fatalf("Reject dropped:%v", dropped)
+			}
+		})
+		t.Run(name+"/DropOldest", func(t *testing.T) {
+			q := overflowQueues(size)[name]
+			var dropped []int
+			q.SetOverflow(queue.DropOldest, func(v int) { dropped = append(dropped, v) })
+			fill(q)
+			if !q.EnQueue(size) {
+				t.Fatalf("full EnQueue want:true")
+			}
+			if n := q.EnQueueMany(seq(size+1, size+4)); n != 3 {
+				t.Fatalf("full EnQueueMany want:3, real:%d", n)
+			}
+			check(t, q, seq(4, size+4))
+			if !reflect.DeepEqual(dropped, seq(0, 4)) {
+				t.Fatalf("dropped want:%v, real:%v", seq(0, 4), dropped)
+			}
+			// 没有回调也能丢弃
+			q.SetOverflow(queue.DropOldest, nil)
+			if !q.EnQueue(size + 4) {
+				t.Fatalf("full EnQueue want:true")
+			}
+			check(t, q, seq(5, size+5))
+		})
+		t.Run(name+"/DropNewest", func(t *testing.T) {
+			q := overflowQueues(size)[name]
+			var dropped []int
+			q.SetOverflow(queue.DropNewest, func(v int) { dropped = append(dropped, v) })
+			fill(q)
+			if !q.EnQueue(size) {
+				t.Fatalf("full EnQueue want:true")
+			}
+			if n := q.EnQueueMany(seq(size+1, size+4)); n != 3 {
+				t.Fatalf("full EnQueueMany want:3, real:%d", n)
+			}
+			check(t, q, seq(0, size))
+			if !reflect.DeepEqual(dropped, seq(size, size+4)) {
+				t.Fatalf("dropped want:%v, real:%v", seq(size, size+4), dropped)
+			}
+		})
+		t.Run(name+"/Block", func(t *testing.T) {
+			q := overflowQueues(size)[name]
+			q.SetOverflow(queue.Block, func(v int) { t.Errorf("Block dropped:%d", v) })
+			fill(q)
+			done := make(chan bool, 1)
+			go func() {
+				done <- q.EnQueue(size)
+			}()
+			select {
+			case <-done:
+				t.Fatalf("full EnQueue not blocked")
+			case <-time.After(time.Millisecond):
+			}
+			// 扩容的LRQueue要先取完旧ring，才有空间。
+			for n := q.Size() - q.Cap() + 1; n > 0; n-- {
+				q.DeQueue()
+			}
+			select {
+			case ok := <-done:
+				if !ok {
+					t.Fatalf("blocked EnQueue want:true")
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("blocked EnQueue not woken by DeQueue")
+			}
+			// 队列关闭时返回false
+			go func() {
+				done <- q.EnQueue(-1)
+			}()
+			time.Sleep(time.Millisecond)
+			q.Close()
+			select {
+			case ok := <-done:
+				if ok {
+					t.Fatalf("closed EnQueue want:false")
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("blocked EnQueue not woken by Close")
+			}
+		})
+		t.Run(name+"/Closed", func(t *testing.T) {
+			for _, policy := range []queue.Overflow{queue.DropOldest, queue.DropNewest} {
+				q := overflowQueues(size)[name]
+				q.SetOverflow(policy, func(v int) { t.Errorf("%v dropped:%d after Close", policy, v) })
+				fill(q)
+				q.Close()
+				if q.EnQueue(size) {
+					t.Fatalf("%v closed EnQueue want:false", policy)
+				}
+			}
+		})
+	}
+}
+
+// 并发EnQueue,DeQueue时，每个值要么被取出，要么交给回调，不会丢失或者重复。
+func TestConcurrentQueueOverflow(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	for _, policy := range []queue.Overflow{queue.DropOldest, queue.DropNewest, queue.Block} {
+		for name, q := range overflowQueues(1 << 3) {
+			t.Run(policy.String()+"/"+name, func(t *testing.T) {
+				var seen [maxGo * maxNum]int32
+				q.SetOverflow(policy, func(v int) { atomic.AddInt32(&seen[v], 1) })
+				var wg, consumers sync.WaitGroup
+				for g := 0; g < maxGo; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < maxNum; i++ {
+							if !q.EnQueue(g*maxNum + i) {
+								t.Errorf("%v EnQueue want:true", policy)
+								return
+							}
+						}
+					}(g)
+					consumers.Add(1)
+					go func() {
+						defer consumers.Done()
+						for {
+							v, err := q.DeQueueCtx(context.Background())
+							if err != nil {
+								return
+							}
+							atomic.AddInt32(&seen[v], 1)
+						}
+					}()
+				}
+				wg.Wait()
+				q.Close()
+				consumers.Wait()
+				for v, n := range seen {
+					if n != 1 {
+						t.Fatalf("value:%d seen %d times", v, n)
+					}
+				}
+			})
+		}
+	}
+}
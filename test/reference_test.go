@@ -5,6 +5,7 @@ import (
 	"sync/atomic"
 
 	"github.com/min1324/data/queue"
+	"github.com/min1324/data/stack"
 )
 
 // use for slice
@@ -49,8 +50,32 @@ func (n *node) load() interface{} {
 
 // -------------------------------------	stack	------------------------------------------- //
 
+// EpochLLStack LLStack使用ReclaimEpoch方式回收node
+type EpochLLStack struct {
+	*stack.LLStack[interface{}]
+}
+
+func (s *EpochLLStack) Init() {
+	if s.LLStack == nil {
+		s.LLStack = stack.NewLLStackWith[interface{}](stack.ReclaimEpoch).(*stack.LLStack[interface{}])
+	}
+	s.LLStack.Init()
+}
+
 // -------------------------------------	queue	------------------------------------------- //
 
+// EpochLLQueue LLQueue使用ReclaimEpoch方式回收node
+type EpochLLQueue struct {
+	*queue.LLQueue[interface{}]
+}
+
+func (q *EpochLLQueue) Init() {
+	if q.LLQueue == nil {
+		q.LLQueue = queue.NewLLQueueWith[interface{}](queue.ReclaimEpoch).(*queue.LLQueue[interface{}])
+	}
+	q.LLQueue.Init()
+}
+
 // MutexSlice an array of queue
 // each time have concurrent Add pushID
 // but only one can get and add popID
@@ -61,14 +86,14 @@ type MutexSlice struct {
 	popID  uintptr // current pop id
 	pushID uintptr // current push id
 
-	dirty [mod + 1]queue.SLQueue
+	dirty [mod + 1]queue.SLQueue[interface{}]
 
 	pushMu sync.Mutex
 	popMu  sync.Mutex
 	once   sync.Once
 }
 
-func (s *MutexSlice) hash(id uintptr) *queue.SLQueue {
+func (s *MutexSlice) hash(id uintptr) *queue.SLQueue[interface{}] {
 	return &s.dirty[id&mod]
 }
 
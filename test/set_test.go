@@ -38,25 +38,25 @@ func initSet() (p, q *set.IntSet) {
 	return &s, &t
 }
 
-func ExampleSetUnion() {
+func Example_setUnion() {
 	s, t := initSet()
 	s.UnionWith(t)
 	fmt.Println(s.String())
 }
 
-func ExampleSetIntersect() {
+func Example_setIntersect() {
 	s, t := initSet()
 	s.IntersectWith(t)
 	fmt.Println(s.String())
 }
 
-func ExampleSetDifference() {
+func Example_setDifference() {
 	s, t := initSet()
 	s.DifferenceWith(t)
 	fmt.Println(s.String())
 }
 
-func ExampleSetComplement() {
+func Example_setComplement() {
 	s, t := initSet()
 	s.ComplementWith(t)
 	fmt.Println(s.String())
@@ -45,18 +45,19 @@ type benchS struct {
 func benchSMap(b *testing.B, benchS benchS) {
 	for _, m := range [...]SInterface{
 		// // stack
-		&stack.LLStack{},
-		&stack.SAStack{},
-		&stack.SLStack{},
-		&stack.LAStack{},
+		&stack.LLStack[interface{}]{},
+		&EpochLLStack{},
+		&stack.SAStack[interface{}]{},
+		&stack.SLStack[interface{}]{},
+		&stack.LAStack[interface{}]{},
 	} {
 		b.Run(fmt.Sprintf("%T", m), func(b *testing.B) {
 			m = reflect.New(reflect.TypeOf(m).Elem()).Interface().(SInterface)
 			m.Init()
-			if q, ok := m.(*stack.LAStack); ok {
+			if q, ok := m.(*stack.LAStack[interface{}]); ok {
 				q.InitWith(stackMaxSize)
 			}
-			if q, ok := m.(*stack.SAStack); ok {
+			if q, ok := m.(*stack.SAStack[interface{}]); ok {
 				q.InitWith(stackMaxSize)
 			}
 			if benchS.setup != nil {
@@ -397,3 +398,39 @@ func BenchmarkStackConMulRand(b *testing.B) {
 		},
 	})
 }
+
+// BenchmarkStackBackoff 比较不同退避策略，每个P一个goroutine，随机Push,Pop。
+func BenchmarkStackBackoff(b *testing.B) {
+	const size = 1 << 10
+	const mod = size - 1
+	var random [size]int
+	for i := range random {
+		random[i] = rand.Intn(10) & 1
+	}
+	for _, name := range []string{"LLStack", "EpochLLStack", "LAStack"} {
+		for _, bo := range backoffs {
+			b.Run(name+"/"+bo.name, func(b *testing.B) {
+				s := map[string]stack.Stack[int]{
+					"LLStack":      stack.NewLLStackWith[int](stack.ReclaimHazard, bo.b),
+					"EpochLLStack": stack.NewLLStackWith[int](stack.ReclaimEpoch, bo.b),
+					"LAStack":      stack.NewLAStackWith[int](bo.b, stackMaxSize),
+				}[name]
+				for i := 0; i < prevPushSize>>4; i++ {
+					s.Push(i)
+				}
+				b.ResetTimer()
+				var id int64
+				b.RunParallel(func(pb *testing.PB) {
+					i := int(atomic.AddInt64(&id, 1)) * size / 7
+					for ; pb.Next(); i++ {
+						if random[i&mod] == 0 {
+							s.Push(i)
+						} else {
+							s.Pop()
+						}
+					}
+				})
+			})
+		}
+	}
+}
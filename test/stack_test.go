@@ -2,14 +2,17 @@ package data_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/min1324/data/codec"
 	"github.com/min1324/data/stack"
 )
 
@@ -20,10 +23,11 @@ type stackStruct struct {
 
 func stackMap(t *testing.T, test stackStruct) {
 	for _, m := range [...]SInterface{
-		&stack.LAStack{},
-		&stack.LLStack{},
-		&stack.SAStack{},
-		&stack.SLStack{},
+		&stack.LAStack[interface{}]{},
+		&stack.LLStack[interface{}]{},
+		&EpochLLStack{},
+		&stack.SAStack[interface{}]{},
+		&stack.SLStack[interface{}]{},
 	} {
 		t.Run(fmt.Sprintf("%T", m), func(t *testing.T) {
 			m = reflect.New(reflect.TypeOf(m).Elem()).Interface().(SInterface)
@@ -34,6 +38,9 @@ func stackMap(t *testing.T, test stackStruct) {
 				test.setup(t, m)
 			}
 			test.perG(t, m)
+			// 大数据量的测试结束后马上回收，
+			// 否则下次GC的目标堆大小过高，后面的测试会耗尽内存。
+			runtime.GC()
 		})
 	}
 }
@@ -151,6 +158,10 @@ func TestStackInit(t *testing.T) {
 			if v, ok := s.Pop(); !ok || null != v {
 				t.Fatalf("Push nil want:%v, real:%v", null, v)
 			}
+			s.Push(nil)
+			if v, ok := s.Pop(); !ok || v != nil {
+				t.Fatalf("Push nil want:%v, real:%v,%v", nil, v, ok)
+			}
 		},
 	})
 }
@@ -393,3 +404,407 @@ func TestConcurrentPushPop(t *testing.T) {
 		},
 	})
 }
+
+func TestGenericStack(t *testing.T) {
+	const maxNum = 1 << 8
+	for name, newStack := range map[string]func() stack.Stack[int]{
+		"LAStack": stack.NewLAStack[int],
+		"LLStack": stack.NewLLStack[int],
+		"SAStack": stack.NewSAStack[int],
+		"SLStack": stack.NewSLStack[int],
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newStack()
+			if v, ok := s.Pop(); ok {
+				t.Fatalf("empty Pop want:false, real:%v", v)
+			}
+			// 零值也能正常储存
+			for i := 0; i < maxNum; i++ {
+				if !s.Push(i) {
+					t.Fatalf("Push %d fail", i)
+				}
+			}
+			for i := maxNum - 1; i >= 0; i-- {
+				v, ok := s.Pop()
+				if !ok || v != i {
+					t.Fatalf("Pop want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			if v, ok := s.Pop(); ok {
+				t.Fatalf("after Pop want:false, real:%v", v)
+			}
+		})
+	}
+}
+
+// node被频繁回收复用，如果回收不安全，会出现值丢失或者重复出栈。
+func TestLLStackReclamation(t *testing.T) {
+	testStackReclamation(t, &stack.LLStack[int]{})
+}
+
+func TestLLStackEpochReclamation(t *testing.T) {
+	testStackReclamation(t, stack.NewLLStackWith[int](stack.ReclaimEpoch).(*stack.LLStack[int]))
+}
+
+func testStackReclamation(t *testing.T, s *stack.LLStack[int]) {
+	const maxGo, maxNum = 8, 1 << 18
+	// 单核时也需要多个线程交替执行，才能触发竞争。
+	if runtime.GOMAXPROCS(0) < maxGo {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo))
+	}
+	var seen [maxGo * maxNum]int32
+	var wg sync.WaitGroup
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < maxNum; i += 2 {
+				s.Push(g*maxNum + i)
+				s.Push(g*maxNum + i + 1)
+				for j := 0; j < 2; j++ {
+					if v, ok := s.Pop(); ok {
+						atomic.AddInt32(&seen[v], 1)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for {
+		v, ok := s.Pop()
+		if !ok {
+			break
+		}
+		atomic.AddInt32(&seen[v], 1)
+	}
+	for v := range seen {
+		if seen[v] != 1 {
+			t.Fatalf("value:%d Pop %d times", v, seen[v])
+		}
+	}
+	if s.Size() != 0 {
+		t.Fatalf("size want:0, real:%d", s.Size())
+	}
+}
+
+func dataStackMap() map[string]stack.DataStack[int] {
+	return map[string]stack.DataStack[int]{
+		"LAStack": &stack.LAStack[int]{},
+		"LLStack": &stack.LLStack[int]{},
+		"SAStack": &stack.SAStack[int]{},
+		"SLStack": &stack.SLStack[int]{},
+	}
+}
+
+func TestStackSnapshot(t *testing.T) {
+	const maxNum = 1 << 6
+	for name, s := range dataStackMap() {
+		t.Run(name, func(t *testing.T) {
+			if vals := stack.Snapshot(s); len(vals) != 0 {
+				t.Fatalf("empty Snapshot want:[], real:%v", vals)
+			}
+			want := seqInts(0, maxNum)
+			for _, v := range want {
+				s.Push(v)
+			}
+			s.Pop()
+			want = want[:maxNum-1]
+			// 从栈底到栈顶
+			if vals := stack.Snapshot(s); !reflect.DeepEqual(vals, want) || s.Size() != len(want) {
+				t.Fatalf("Snapshot want:%v, real:%v,size:%d", want, vals, s.Size())
+			}
+
+			data, err := s.(interface{ MarshalBinary() ([]byte, error) }).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range dataStackMap() {
+				r.Push(-1)
+				if err = r.(interface{ UnmarshalBinary([]byte) error }).UnmarshalBinary(data); err != nil {
+					t.Fatalf("%T UnmarshalBinary:%v", r, err)
+				}
+				// 栈顶是最后一个
+				for i := len(want) - 1; i >= 0; i-- {
+					if v, ok := r.Pop(); !ok || v != want[i] {
+						t.Fatalf("%T restore Pop want:%d, real:%d,%v", r, want[i], v, ok)
+					}
+				}
+			}
+
+			js, err := json.Marshal(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sl stack.SLStack[int]
+			if err = json.Unmarshal(js, &sl); err != nil {
+				t.Fatal(err)
+			}
+			if vals := stack.Snapshot[int](&sl); !reflect.DeepEqual(vals, want) {
+				t.Fatalf("json restore want:%v, real:%v", want, vals)
+			}
+
+			data, err = stack.Marshal[int](s, codec.JSON[int]{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sa stack.SAStack[int]
+			sa.InitWith(maxNum / 2)
+			if err = stack.Unmarshal[int](&sa, data, codec.JSON[int]{}); err != stack.ErrFull {
+				t.Fatalf("Unmarshal full want:ErrFull, real:%v", err)
+			}
+			if vals := stack.Snapshot[int](&sa); !reflect.DeepEqual(vals, want[:maxNum/2]) {
+				t.Fatalf("Unmarshal full want:%v, real:%v", want[:maxNum/2], vals)
+			}
+
+			js, err = stack.MarshalJSON[int](s, hexCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ll stack.LLStack[int]
+			if err = stack.UnmarshalJSON[int](&ll, js, hexCodec{}); err != nil {
+				t.Fatal(err)
+			}
+			if vals := stack.Snapshot[int](&ll); !reflect.DeepEqual(vals, want) {
+				t.Fatalf("json codec restore want:%v, real:%v", want, vals)
+			}
+		})
+	}
+}
+
+// 快照和Push并发，每个生产者的值在快照中是从0开始连续的。
+func TestConcurrentStackSnapshot(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	for name, s := range dataStackMap() {
+		t.Run(name, func(t *testing.T) {
+			if b, ok := s.(interface{ InitWith(...int) }); ok {
+				b.InitWith(maxGo * maxNum)
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						s.Push(g*maxNum + i)
+					}
+				}(g)
+			}
+			var done int32
+			go func() {
+				wg.Wait()
+				atomic.StoreInt32(&done, 1)
+			}()
+			for atomic.LoadInt32(&done) == 0 {
+				var next [maxGo]int
+				for _, v := range stack.Snapshot(s) {
+					g, i := v/maxNum, v%maxNum
+					if i != next[g] {
+						t.Fatalf("producer:%d want:%d, real:%d", g, next[g], i)
+					}
+					next[g]++
+				}
+			}
+			if vals := stack.Snapshot(s); len(vals) != maxGo*maxNum {
+				t.Fatalf("final snapshot want:%d, real:%d", maxGo*maxNum, len(vals))
+			}
+		})
+	}
+}
+
+func TestStackTop(t *testing.T) {
+	const maxNum = 1 << 6
+	for name, s := range dataStackMap() {
+		t.Run(name, func(t *testing.T) {
+			if v, ok := s.Top(); ok {
+				t.Fatalf("empty Top want:false, real:%d", v)
+			}
+			for i := 0; i < maxNum; i++ {
+				s.Push(i)
+				if v, ok := s.Top(); !ok || v != i {
+					t.Fatalf("Top after Push want:%d, real:%d,%v", i, v, ok)
+				}
+			}
+			for i := maxNum - 1; i >= 0; i-- {
+				if v, ok := s.Top(); !ok || v != i || s.Size() != i+1 {
+					t.Fatalf("Top want:%d, real:%d,%v,size:%d", i, v, ok, s.Size())
+				}
+				s.Pop()
+			}
+			if v, ok := s.Top(); ok {
+				t.Fatalf("drained Top want:false, real:%d", v)
+			}
+		})
+	}
+}
+
+// Top和Push,Pop并发，返回的值都是Push过的。
+func TestConcurrentStackTop(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 12
+	for name, s := range dataStackMap() {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			var done int32
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						s.Push(g*maxNum + i + 1)
+						s.Pop()
+					}
+				}(g)
+			}
+			var tw sync.WaitGroup
+			tw.Add(1)
+			go func() {
+				defer tw.Done()
+				for atomic.LoadInt32(&done) == 0 {
+					if v, ok := s.Top(); ok && (v <= 0 || v > maxGo*maxNum) {
+						t.Errorf("Top got unknown value:%d", v)
+						return
+					}
+				}
+			}()
+			wg.Wait()
+			atomic.StoreInt32(&done, 1)
+			tw.Wait()
+		})
+	}
+}
+
+func TestStackRange(t *testing.T) {
+	const maxNum = 1 << 6
+	for name, s := range dataStackMap() {
+		t.Run(name, func(t *testing.T) {
+			s.Range(func(v int) bool {
+				t.Fatalf("empty Range visit:%d", v)
+				return false
+			})
+			for i := 0; i < maxNum; i++ {
+				s.Push(i)
+			}
+			s.Pop()
+			// 从栈顶到栈底
+			var vals []int
+			s.Range(func(v int) bool {
+				vals = append(vals, v)
+				return true
+			})
+			for i, v := range vals {
+				if v != maxNum-2-i {
+					t.Fatalf("Range want:%d, real:%d", maxNum-2-i, v)
+				}
+			}
+			if len(vals) != maxNum-1 {
+				t.Fatalf("Range len want:%d, real:%d", maxNum-1, len(vals))
+			}
+			// Snapshot从栈底到栈顶
+			if vals := s.Snapshot(); !reflect.DeepEqual(vals, seqInts(0, maxNum-1)) {
+				t.Fatalf("Snapshot want:%v, real:%v", seqInts(0, maxNum-1), vals)
+			}
+			vals = vals[:0]
+			s.Range(func(v int) bool {
+				vals = append(vals, v)
+				return len(vals) < 2
+			})
+			if !reflect.DeepEqual(vals, []int{maxNum - 2, maxNum - 3}) {
+				t.Fatalf("Range stop want:%v, real:%v", []int{maxNum - 2, maxNum - 3}, vals)
+			}
+		})
+	}
+}
+
+// LLStack的Range和Push,Pop并发。每个goroutine先Push再Pop，
+// 栈底的base个值一直不会出栈，每次遍历都要按顺序恰好访问一次。
+func TestConcurrentStackRange(t *testing.T) {
+	const maxGo, maxNum, base = 4, 1 << 12, 1 << 4
+	for name, s := range map[string]stack.DataStack[int]{
+		"LLStack":      &stack.LLStack[int]{},
+		"EpochLLStack": stack.NewLLStackWith[int](stack.ReclaimEpoch).(*stack.LLStack[int]),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < base; i++ {
+				s.Push(i)
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < maxGo; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < maxNum; i++ {
+						s.Push(base + i)
+						s.Pop()
+					}
+				}()
+			}
+			var done int32
+			go func() {
+				wg.Wait()
+				atomic.StoreInt32(&done, 1)
+			}()
+			for atomic.LoadInt32(&done) == 0 {
+				next := base - 1
+				s.Range(func(v int) bool {
+					if v < base {
+						if v != next {
+							t.Fatalf("base want:%d, real:%d", next, v)
+						}
+						next--
+					} else if next != base-1 || v >= base+maxNum {
+						t.Fatalf("unexpected value:%d, next base:%d", v, next)
+					}
+					return true
+				})
+				if next != -1 {
+					t.Fatalf("base not visited from:%d", next)
+				}
+			}
+			if vals := s.Snapshot(); !reflect.DeepEqual(vals, seqInts(0, base)) {
+				t.Fatalf("final Snapshot want:%v, real:%v", seqInts(0, base), vals)
+			}
+		})
+	}
+}
+
+// 注入退避策略后，并发Push,Pop的值不会丢失或者重复。
+func TestStackBackoff(t *testing.T) {
+	const maxGo, maxNum = 4, 1 << 10
+	for _, bo := range backoffs {
+		for name, s := range map[string]stack.Stack[int]{
+			"LLStack":      stack.NewLLStackWith[int](stack.ReclaimHazard, bo.b),
+			"EpochLLStack": stack.NewLLStackWith[int](stack.ReclaimEpoch, bo.b),
+			"LAStack":      stack.NewLAStackWith[int](bo.b, maxGo*maxNum),
+		} {
+			t.Run(bo.name+"/"+name, func(t *testing.T) {
+				var wg sync.WaitGroup
+				var seen [maxGo * maxNum]int32
+				for g := 0; g < maxGo; g++ {
+					wg.Add(2)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < maxNum; i++ {
+							s.Push(g*maxNum + i)
+						}
+					}(g)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < maxNum; {
+							if v, ok := s.Pop(); ok {
+								atomic.AddInt32(&seen[v], 1)
+								i++
+							} else {
+								runtime.Gosched()
+							}
+						}
+					}()
+				}
+				wg.Wait()
+				for v, n := range seen {
+					if n != 1 {
+						t.Fatalf("value:%d Pop %d times", v, n)
+					}
+				}
+			})
+		}
+	}
+}
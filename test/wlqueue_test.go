@@ -0,0 +1,58 @@
+package data_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/min1324/data/queue"
+)
+
+// TestWLQueueSteps 多个goroutine同时入队出队，记录每个操作的最大步数。
+// wait-free保证步数有上限：每个操作最多帮助所有handle各一次，
+// 每次帮助的循环次数只和同时进行的操作数有关，和总操作数无关。
+func TestWLQueueSteps(t *testing.T) {
+	const maxGo, maxNum = 8, 1 << 14
+	if runtime.GOMAXPROCS(0) < maxGo {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(maxGo))
+	}
+	var q queue.WLQueue[int]
+	var wg sync.WaitGroup
+	maxSteps := make([]int, maxGo)
+	for g := 0; g < maxGo; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < maxNum; i++ {
+				_, steps := q.EnQueueSteps(i)
+				if steps > maxSteps[g] {
+					maxSteps[g] = steps
+				}
+				_, _, steps = q.DeQueueSteps()
+				if steps > maxSteps[g] {
+					maxSteps[g] = steps
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	var max int
+	for _, s := range maxSteps {
+		if s > max {
+			max = s
+		}
+	}
+	// 每个goroutine同时只有一个操作，handle数量不超过goroutine数。
+	handles := q.Handles()
+	if handles > maxGo {
+		t.Fatalf("handles want<=%d, real:%d", maxGo, handles)
+	}
+	bound := q.StepBound()
+	t.Logf("handles:%d, max steps:%d, bound:%d", handles, max, bound)
+	if max > bound {
+		t.Fatalf("max steps:%d exceed bound:%d", max, bound)
+	}
+	if !q.Empty() {
+		t.Fatalf("size want:0, real:%d", q.Size())
+	}
+}
@@ -0,0 +1,113 @@
+package data_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/min1324/data/queue"
+)
+
+// 环形队列的enID,deID是uint32,约40亿次操作后回绕。
+// 从回绕点前开始，测试满空条件，数量和顺序都不受影响。
+
+const wrapCap = 1 << 4
+
+// wrapStart 回绕点前半个cap，入队cap个值时跨过回绕点。
+const wrapStart = math.MaxUint32 - wrapCap/2 + 1
+
+func wrapQueues() map[string]queue.DataQueue[int] {
+	var (
+		sr queue.SRQueue[int]
+		dr queue.DRQueue[int]
+		lr queue.LRQueue[int]
+		sp queue.SPRQueue[int]
+	)
+	sr.InitWith(wrapCap)
+	sr.InitAt(wrapStart)
+	dr.InitWith(wrapCap)
+	dr.InitAt(wrapStart)
+	lr.InitWith(wrapCap)
+	lr.InitAt(wrapStart)
+	sp.InitWith(wrapCap)
+	sp.InitAt(wrapStart)
+	return map[string]queue.DataQueue[int]{
+		"SRQueue":  &sr,
+		"DRQueue":  &dr,
+		"LRQueue":  &lr,
+		"SPRQueue": &sp,
+	}
+}
+
+func TestRingWrap(t *testing.T) {
+	for name, q := range wrapQueues() {
+		t.Run(name, func(t *testing.T) {
+			// 多跑几圈，每圈都跨过或者远离回绕点
+			for lap := 0; lap < 4; lap++ {
+				if !q.Empty() || q.Full() || q.Size() != 0 {
+					t.Fatalf("lap:%d empty:%v, full:%v, size:%d", lap, q.Empty(), q.Full(), q.Size())
+				}
+				for i := 0; i < wrapCap; i++ {
+					if !q.EnQueue(i) {
+						t.Fatalf("lap:%d EnQueue:%d fail,size:%d", lap, i, q.Size())
+					}
+				}
+				if !q.Full() || q.Size() != wrapCap {
+					t.Fatalf("lap:%d full:%v, size:%d", lap, q.Full(), q.Size())
+				}
+				if q.EnQueue(-1) {
+					t.Fatalf("lap:%d full EnQueue want:false", lap)
+				}
+				for i := 0; i < wrapCap; i++ {
+					if v, ok := q.DeQueue(); !ok || v != i {
+						t.Fatalf("lap:%d DeQueue want:%d, real:%d,%v", lap, i, v, ok)
+					}
+				}
+				if v, ok := q.DeQueue(); ok {
+					t.Fatalf("lap:%d empty DeQueue want:false, real:%d", lap, v)
+				}
+			}
+		})
+	}
+}
+
+func TestRingWrapMany(t *testing.T) {
+	for name, q := range wrapQueues() {
+		t.Run(name, func(t *testing.T) {
+			vals := make([]int, wrapCap*4)
+			for i := range vals {
+				vals[i] = i
+			}
+			dst := make([]int, 3)
+			next := 0
+			// 每次入队一部分，出队3个，ID逐步跨过回绕点
+			for lap := 0; lap < 6; lap++ {
+				n := q.EnQueueMany(vals[next+q.Size() : next+q.Size()+5])
+				if n != 5 {
+					t.Fatalf("lap:%d EnQueueMany want:5, real:%d,size:%d", lap, n, q.Size())
+				}
+				n = q.DeQueueMany(dst)
+				for _, v := range dst[:n] {
+					if v != next {
+						t.Fatalf("lap:%d DeQueueMany want:%d, real:%d", lap, next, v)
+					}
+					next++
+				}
+			}
+			// 剩下的空间只能加入一部分
+			if n := q.EnQueueMany(vals[next+q.Size() : next+q.Size()+8]); n != 4 || !q.Full() {
+				t.Fatalf("EnQueueMany want:4, real:%d,full:%v", n, q.Full())
+			}
+			rest := make([]int, wrapCap*2)
+			n := q.DeQueueMany(rest)
+			if n != wrapCap || !q.Empty() {
+				t.Fatalf("DeQueueMany want:%d, real:%d,size:%d", wrapCap, n, q.Size())
+			}
+			for _, v := range rest[:n] {
+				if v != next {
+					t.Fatalf("DeQueueMany want:%d, real:%d", next, v)
+				}
+				next++
+			}
+		})
+	}
+}